@@ -8,7 +8,6 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/serbanmarti/go-grpc/client/internal"
-	"github.com/serbanmarti/go-grpc/env"
 	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
 )
 
@@ -30,16 +29,13 @@ func runCrudCreateCmd(name string) {
 	// Create a new client to the CRUD service
 	client := internal.NewCrudServiceClient()
 
-	// Get the environment configuration
-	environment := env.GetEnvironment()
-
 	// Create a new request for the Create method
 	req := connect.NewRequest(&crudv1.CreateRequest{
 		Name: name,
 	})
 
-	// Set the authentication token
-	req.Header().Set(environment.TokenHeader, environment.TokenSecret)
+	// Set the authentication headers
+	internal.SetAuthHeaders(req.Header())
 
 	// Call the Create method
 	res, err := client.Create(
@@ -47,7 +43,7 @@ func runCrudCreateCmd(name string) {
 		req,
 	)
 	if err != nil {
-		log.Fatalf("[ERROR] Failed to create resource: %v\n", err)
+		log.Fatalf("[ERROR] Failed to create resource: %s\n", internal.DescribeError(err))
 	}
 	log.Printf("[INFO] Created resource with ID: %s\n", res.Msg.Id)
 }
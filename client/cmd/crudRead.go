@@ -8,7 +8,6 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/serbanmarti/go-grpc/client/internal"
-	"github.com/serbanmarti/go-grpc/env"
 	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
 )
 
@@ -30,16 +29,13 @@ func runCrudReadCmd(id string) {
 	// Create a new client to the CRUD service
 	client := internal.NewCrudServiceClient()
 
-	// Get the environment configuration
-	environment := env.GetEnvironment()
-
 	// Create a new request for the Read method
 	req := connect.NewRequest(&crudv1.ReadRequest{
 		Id: id,
 	})
 
-	// Set the authentication token
-	req.Header().Set(environment.TokenHeader, environment.TokenSecret)
+	// Set the authentication headers
+	internal.SetAuthHeaders(req.Header())
 
 	// Call the Read method
 	res, err := client.Read(
@@ -47,7 +43,7 @@ func runCrudReadCmd(id string) {
 		req,
 	)
 	if err != nil {
-		log.Fatalf("[ERROR] Failed to read resource: %v\n", err)
+		log.Fatalf("[ERROR] Failed to read resource: %s\n", internal.DescribeError(err))
 	}
 	log.Printf("[INFO] Read resource with ID: %s -> Name: %s\n", res.Msg.Id, res.Msg.Name)
 }
@@ -8,7 +8,6 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/serbanmarti/go-grpc/client/internal"
-	"github.com/serbanmarti/go-grpc/env"
 	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
 )
 
@@ -30,17 +29,14 @@ func runCrudUpdateCmd(id, newName string) {
 	// Create a new client to the CRUD service
 	client := internal.NewCrudServiceClient()
 
-	// Get the environment configuration
-	environment := env.GetEnvironment()
-
 	// Create a new request for the Update method
 	req := connect.NewRequest(&crudv1.UpdateRequest{
 		Id:          id,
 		UpdatedName: newName,
 	})
 
-	// Set the authentication token
-	req.Header().Set(environment.TokenHeader, environment.TokenSecret)
+	// Set the authentication headers
+	internal.SetAuthHeaders(req.Header())
 
 	// Call the Update method
 	res, err := client.Update(
@@ -48,7 +44,7 @@ func runCrudUpdateCmd(id, newName string) {
 		req,
 	)
 	if err != nil {
-		log.Fatalf("[ERROR] Failed to update resource: %v\n", err)
+		log.Fatalf("[ERROR] Failed to update resource: %s\n", internal.DescribeError(err))
 	}
 	log.Printf("[INFO] Updated resource with ID: %s -> New name: %s\n", res.Msg.Id, res.Msg.Name)
 }
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	"github.com/serbanmarti/go-grpc/client/internal"
+	filesv1 "github.com/serbanmarti/go-grpc/proto_gen/files/v1"
+)
+
+// fileDeleteCmd represents the file-delete command
+var fileDeleteCmd = &cobra.Command{
+	Use:   "file-delete [id]",
+	Short: "Command to delete a stored file by ID",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runFileDeleteCmd(args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fileDeleteCmd)
+}
+
+func runFileDeleteCmd(id string) {
+	// Create a new client to the File service
+	client := internal.NewFileServiceClient()
+
+	// Create a new request for the DeleteFile method
+	req := connect.NewRequest(&filesv1.DeleteFileRequest{
+		Id: id,
+	})
+
+	// Set the authentication headers
+	internal.SetAuthHeaders(req.Header())
+
+	// Call the DeleteFile method
+	res, err := client.DeleteFile(
+		context.Background(),
+		req,
+	)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to delete file: %s\n", internal.DescribeError(err))
+	}
+	log.Printf("[INFO] Deleted file with ID: %s\n", res.Msg.Id)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	"github.com/serbanmarti/go-grpc/client/internal"
+	filesv1 "github.com/serbanmarti/go-grpc/proto_gen/files/v1"
+)
+
+var (
+	fileListPageSize   int32
+	fileListPageToken  string
+	fileListNamePrefix string
+	fileListOwner      string
+)
+
+// fileListCmd represents the file-list command
+var fileListCmd = &cobra.Command{
+	Use:   "file-list",
+	Short: "Command to list the stored files",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runFileListCmd()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fileListCmd)
+
+	fileListCmd.Flags().Int32Var(&fileListPageSize, "page-size", 0, "maximum number of files to list (server default if 0)")
+	fileListCmd.Flags().StringVar(&fileListPageToken, "page-token", "", "token of the page to list, as returned by a previous call")
+	fileListCmd.Flags().StringVar(&fileListNamePrefix, "prefix", "", "only list files whose name starts with this prefix")
+	fileListCmd.Flags().StringVar(&fileListOwner, "owner", "", "list the files uploaded by this owner rather than ours (admins only)")
+}
+
+func runFileListCmd() {
+	// Create a new client to the File service
+	client := internal.NewFileServiceClient()
+
+	// Create a new request for the ListFiles method
+	req := connect.NewRequest(&filesv1.ListFilesRequest{
+		PageSize:   fileListPageSize,
+		PageToken:  fileListPageToken,
+		NamePrefix: fileListNamePrefix,
+		Owner:      fileListOwner,
+	})
+
+	// Set the authentication headers
+	internal.SetAuthHeaders(req.Header())
+
+	// Call the ListFiles method
+	res, err := client.ListFiles(
+		context.Background(),
+		req,
+	)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to list files: %s\n", internal.DescribeError(err))
+	}
+	for _, f := range res.Msg.Files {
+		log.Printf("[INFO] %s  %s  %d bytes  owner: %s\n", f.Id, f.FileName, f.Size, f.Owner)
+	}
+	if res.Msg.NextPageToken != "" {
+		log.Printf("[INFO] More files available, next page token: %s\n", res.Msg.NextPageToken)
+	}
+}
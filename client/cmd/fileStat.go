@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	"github.com/serbanmarti/go-grpc/client/internal"
+	filesv1 "github.com/serbanmarti/go-grpc/proto_gen/files/v1"
+)
+
+// fileStatCmd represents the file-stat command
+var fileStatCmd = &cobra.Command{
+	Use:   "file-stat [id]",
+	Short: "Command to show the metadata of a stored file by ID",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runFileStatCmd(args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fileStatCmd)
+}
+
+func runFileStatCmd(id string) {
+	// Create a new client to the File service
+	client := internal.NewFileServiceClient()
+
+	// Create a new request for the StatFile method
+	req := connect.NewRequest(&filesv1.StatFileRequest{
+		Id: id,
+	})
+
+	// Set the authentication headers
+	internal.SetAuthHeaders(req.Header())
+
+	// Call the StatFile method
+	res, err := client.StatFile(
+		context.Background(),
+		req,
+	)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to stat file: %s\n", internal.DescribeError(err))
+	}
+	f := res.Msg.File
+	log.Printf(
+		"[INFO] File with ID: %s -> Name: %s - Size: %d - SHA-256: %s - Content type: %s - Uploaded at: %s - Owner: %s\n",
+		f.Id, f.FileName, f.Size, f.Sha256, f.ContentType, f.UploadedAt.AsTime().Format(time.RFC3339), f.Owner,
+	)
+}
@@ -4,6 +4,8 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/serbanmarti/go-grpc/client/internal"
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -12,10 +14,20 @@ var rootCmd = &cobra.Command{
 	Short: "A CRUD & Stream sample client for gRPC",
 }
 
+func init() {
+	flags := rootCmd.PersistentFlags()
+	flags.BoolVar(&internal.TLS.Enabled, "tls", false, "connect to the server over TLS (implied by the other TLS flags)")
+	flags.StringVar(&internal.TLS.CAFile, "tls-ca-file", "", "PEM file of the CAs verifying the server certificate (system CAs if not set)")
+	flags.StringVar(&internal.TLS.CertFile, "tls-cert-file", "", "PEM file of the client certificate, for mutual TLS")
+	flags.StringVar(&internal.TLS.KeyFile, "tls-key-file", "", "PEM file of the client certificate key, for mutual TLS")
+	flags.StringVar(&internal.TLS.ServerName, "tls-server-name", "", "name to verify the server certificate against (localhost if not set)")
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	err := rootCmd.Execute()
+	internal.FlushSpans()
 	if err != nil {
 		os.Exit(1)
 	}
@@ -1,75 +1,294 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"connectrpc.com/connect"
 	"github.com/spf13/cobra"
 
 	"github.com/serbanmarti/go-grpc/client/internal"
-	"github.com/serbanmarti/go-grpc/env"
 	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
 )
 
 // streamDirectMessageCmd represents the stream-direct-message command
 var streamDirectMessageCmd = &cobra.Command{
 	Use:   "stream-direct-message",
-	Short: "Command to stream a direct message",
+	Short: "Command to chat interactively, in rooms or through direct messages",
+	Long: `Command to chat interactively, in rooms or through direct messages.
+
+Each line typed is sent to the current room, unless it is one of the commands:
+  /join <room>        join a room, making it the current one
+  /leave <room>       leave a room
+  /msg <user> <text>  send a direct message to a user
+  /quit               close the stream and exit, as do Ctrl+C and the end of the input`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		runStreamDirectMessageCmd()
 	},
 }
 
+var directMessageRoom string
+
+const (
+	// Delays between the attempts to reconnect a failed stream, doubling up to the maximum
+	reconnectMinBackoff = 500 * time.Millisecond
+	reconnectMaxBackoff = 30 * time.Second
+)
+
 func init() {
 	rootCmd.AddCommand(streamDirectMessageCmd)
+
+	streamDirectMessageCmd.Flags().StringVar(&directMessageRoom, "room", "general", "room joined, and the lines are sent to, at first")
 }
 
 func runStreamDirectMessageCmd() {
-	// Create a new client to the Stream service
-	client := internal.NewStreamServiceClient()
-
-	// Get the environment configuration
-	environment := env.GetEnvironment()
-
-	// Create a new stream for the DirectMessage method
-	stream := client.DirectMessage(context.Background())
-
-	// Set the authentication token
-	stream.RequestHeader().Set(environment.TokenHeader, environment.TokenSecret)
-
-	// We simulate the messages being read from input, and requests being created for each message
-	// In a real-world scenario, the messages would be read from a file, or a database, or any other source
-	reqData := []streamv1.DirectMessageRequest{
-		{
-			Message: "Hello",
-		},
-		{
-			Message: "How are you?",
-		},
+	// Stop on Ctrl+C, closing the stream properly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Read the input from another goroutine, so the responses are printed while waiting for it
+	lines := make(chan string)
+	go readLines(os.Stdin, lines)
+
+	// Chat until the user quits, reconnecting with an increasing delay whenever the stream fails
+	session := &chatSession{
+		client: internal.NewStreamServiceClient(),
+		room:   directMessageRoom,
+		rooms:  map[string]struct{}{directMessageRoom: {}},
 	}
+	backoff := reconnectMinBackoff
+	for {
+		responded, err := session.run(ctx, lines)
+		if err == nil {
+			log.Printf("[INFO] Stream closed successfully\n")
+			return
+		}
 
-	// Send each request to the server and receive the response
-	for _, req := range reqData {
-		err := stream.Send(&req)
-		if err != nil {
-			log.Fatalf("[ERROR] Failed to stream direct message: %v\n", err)
+		// Start over from the shortest delay once a stream worked
+		if responded {
+			backoff = reconnectMinBackoff
+		}
+		log.Printf("[ERROR] Chat stream failed, reconnecting in %s: %s\n", backoff, internal.DescribeError(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
 		}
+		backoff = min(backoff*2, reconnectMaxBackoff)
+	}
+}
 
-		res, err := stream.Receive()
-		if err != nil {
-			log.Fatalf("[ERROR] Failed to receive direct message response: %v\n", err)
+// readLines sends the lines read from the input to the channel, closing it at the end of the input
+func readLines(r io.Reader, lines chan<- string) {
+	defer close(lines)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines <- scanner.Text()
+	}
+}
+
+// chatSession is the state of the interactive chat, kept across reconnections
+type chatSession struct {
+	client streamv1connect.StreamServiceClient
+	room   string              // Room the lines are sent to, if any
+	rooms  map[string]struct{} // Rooms joined, joined again after reconnecting
+}
+
+// run chats over a new stream until the user quits, returning nil, or the stream fails
+// It also reports whether any response was received, telling whether the stream worked at all
+func (s *chatSession) run(ctx context.Context, lines <-chan string) (bool, error) {
+	// Create a new stream for the DirectMessage method, not bound to ctx so it can still be closed properly on Ctrl+C
+	stream := s.client.DirectMessage(context.Background())
+
+	// Set the authentication headers
+	internal.SetAuthHeaders(stream.RequestHeader())
+
+	// Receive the responses from another goroutine, handing back the pongs and acks to send,
+	// as sends on a stream must not be concurrent
+	replies := make(chan *streamv1.DirectMessageRequest, 16)
+	received := make(chan error, 1)
+	var responded atomic.Bool
+	go func() {
+		received <- receiveChatResponses(stream, replies, &responded)
+	}()
+
+	// Join the rooms again (or just signal our presence), which also opens the stream
+	opening := []*streamv1.DirectMessageRequest{{Kind: &streamv1.DirectMessageRequest_Heartbeat{Heartbeat: &streamv1.Heartbeat{}}}}
+	for room := range s.rooms {
+		opening = append(opening, &streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Join{Join: &streamv1.JoinRoom{Room: room}}})
+	}
+	for _, req := range opening {
+		if err := stream.Send(req); err != nil {
+			// A failed send only tells the stream is over, the pending receive returning why
+			return responded.Load(), releaseChatStream(stream, replies, received)
+		}
+	}
+
+	for {
+		var req *streamv1.DirectMessageRequest
+		select {
+		case <-ctx.Done():
+			return responded.Load(), closeChatStream(stream, replies, received)
+		case line, ok := <-lines:
+			if !ok {
+				return responded.Load(), closeChatStream(stream, replies, received)
+			}
+			var quit bool
+			var err error
+			req, quit, err = s.parseLine(line)
+			if err != nil {
+				log.Printf("[ERROR] %v\n", err)
+				continue
+			}
+			if quit {
+				return responded.Load(), closeChatStream(stream, replies, received)
+			}
+		case req = <-replies:
+		case err := <-received:
+			// The stream already ended, closing it only releases it
+			_ = stream.CloseResponse()
+			// The server only ends the stream successfully once we closed our side, which we did not
+			if errors.Is(err, io.EOF) {
+				err = fmt.Errorf("stream closed by the server")
+			}
+			return responded.Load(), err
+		}
+
+		if req == nil {
+			continue
 		}
-		log.Printf("[INFO] Received direct message response: <%s>\n", res.Message)
+		if err := stream.Send(req); err != nil {
+			return responded.Load(), releaseChatStream(stream, replies, received)
+		}
+	}
+}
+
+// parseLine builds the request for a line typed by the user, or reports that the user quits
+func (s *chatSession) parseLine(line string) (*streamv1.DirectMessageRequest, bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, false, nil
 	}
 
-	// Close the stream
-	err := stream.CloseRequest()
-	if err != nil {
-		log.Fatalf("[ERROR] Failed to close the stream request: %v\n", err)
+	// Plain lines are sent to the current room
+	if !strings.HasPrefix(line, "/") {
+		if s.room == "" {
+			return nil, false, fmt.Errorf("no current room, use /join <room> or /msg <user> <text>")
+		}
+		return &streamv1.DirectMessageRequest{Room: s.room, Message: line}, false, nil
 	}
-	err = stream.CloseResponse()
-	if err != nil {
-		log.Fatalf("[ERROR] Failed to close the stream reasponse: %v\n", err)
+
+	command, args, _ := strings.Cut(line, " ")
+	args = strings.TrimSpace(args)
+	switch command {
+	case "/join":
+		if args == "" || strings.Contains(args, " ") {
+			return nil, false, fmt.Errorf("usage: /join <room>")
+		}
+		s.room = args
+		s.rooms[args] = struct{}{}
+		return &streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Join{Join: &streamv1.JoinRoom{Room: args}}}, false, nil
+	case "/leave":
+		if args == "" || strings.Contains(args, " ") {
+			return nil, false, fmt.Errorf("usage: /leave <room>")
+		}
+		if s.room == args {
+			s.room = ""
+		}
+		delete(s.rooms, args)
+		return &streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Leave{Leave: &streamv1.LeaveRoom{Room: args}}}, false, nil
+	case "/msg":
+		recipient, text, _ := strings.Cut(args, " ")
+		text = strings.TrimSpace(text)
+		if recipient == "" || text == "" {
+			return nil, false, fmt.Errorf("usage: /msg <user> <text>")
+		}
+		return &streamv1.DirectMessageRequest{Recipient: recipient, Message: text}, false, nil
+	case "/quit":
+		return nil, true, nil
+	default:
+		return nil, false, fmt.Errorf("unknown command %s, use /join, /leave, /msg or /quit", command)
+	}
+}
+
+// closeChatStream closes our side of the stream, then releases it once the server ended it
+func closeChatStream(stream *connect.BidiStreamForClient[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse], replies <-chan *streamv1.DirectMessageRequest, received <-chan error) error {
+	// Close the stream request, telling the server we are done
+	if err := stream.CloseRequest(); err != nil {
+		log.Printf("[ERROR] Failed to close the stream request: %v\n", err)
+	}
+
+	// The server ends the stream successfully once it received our closing
+	err := releaseChatStream(stream, replies, received)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// releaseChatStream waits for the receiving side of the stream to end, then releases it, returning the error it ended with
+func releaseChatStream(stream *connect.BidiStreamForClient[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse], replies <-chan *streamv1.DirectMessageRequest, received <-chan error) error {
+	// Drop the replies in the meantime, as nothing is sent anymore and the receiving side must not block on them
+	var err error
+	for done := false; !done; {
+		select {
+		case <-replies:
+		case err = <-received:
+			done = true
+		}
+	}
+	// The stream already ended, closing it only releases it
+	_ = stream.CloseResponse()
+	return err
+}
+
+// receiveChatResponses prints the responses received on the stream until it ends, returning the error it ended with
+// The pings are answered, and the messages kept in our inbox acknowledged, through the replies
+func receiveChatResponses(stream *connect.BidiStreamForClient[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse], replies chan<- *streamv1.DirectMessageRequest, responded *atomic.Bool) error {
+	for {
+		res, err := stream.Receive()
+		if err != nil {
+			return err
+		}
+		responded.Store(true)
+
+		switch {
+		case res.GetPing() != nil:
+			// Answer the server's pings, so it keeps the stream open
+			replies <- &streamv1.DirectMessageRequest{
+				Kind: &streamv1.DirectMessageRequest_Pong{Pong: &streamv1.Pong{Id: res.GetPing().Id}},
+			}
+			continue
+		case res.GetPresence() != nil:
+			log.Printf("[INFO] Received presence: %s is %s\n", res.GetPresence().User, res.GetPresence().Status)
+			continue
+		case res.GetTyping() != nil:
+			log.Printf("[INFO] Received typing indicator: %s is typing\n", res.GetTyping().User)
+			continue
+		case res.Room != "":
+			log.Printf("[INFO] Received direct message response: [%s] %s: <%s>\n", res.Room, res.Sender, res.Message)
+		default:
+			log.Printf("[INFO] Received direct message response: %s -> %s: <%s>\n", res.Sender, res.Recipient, res.Message)
+		}
+
+		// Messages left in our inbox are acknowledged once printed
+		if res.AckRequired {
+			replies <- &streamv1.DirectMessageRequest{
+				Kind: &streamv1.DirectMessageRequest_Ack{Ack: &streamv1.AckMessage{Id: res.Id}},
+			}
+		}
 	}
-	log.Printf("[INFO] Stream closed successfully\n")
 }
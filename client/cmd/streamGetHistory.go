@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	"github.com/serbanmarti/go-grpc/client/internal"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+)
+
+var (
+	historyRoom   string
+	historyPeer   string
+	historyBefore string
+	historyAfter  string
+	historyLimit  int32
+)
+
+// streamGetHistoryCmd represents the stream-get-history command
+var streamGetHistoryCmd = &cobra.Command{
+	Use:   "stream-get-history",
+	Short: "Command to show the past messages of a room, or exchanged with a peer",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runStreamGetHistoryCmd()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(streamGetHistoryCmd)
+
+	streamGetHistoryCmd.Flags().StringVar(&historyRoom, "room", "", "room whose messages to show")
+	streamGetHistoryCmd.Flags().StringVar(&historyPeer, "peer", "", "user whose direct messages with us to show")
+	streamGetHistoryCmd.Flags().StringVar(&historyBefore, "before", "", "only show messages before this cursor")
+	streamGetHistoryCmd.Flags().StringVar(&historyAfter, "after", "", "only show messages after this cursor")
+	streamGetHistoryCmd.Flags().Int32Var(&historyLimit, "limit", 0, "maximum number of messages to show (server default if 0)")
+}
+
+func runStreamGetHistoryCmd() {
+	// Create a new client to the Stream service
+	client := internal.NewStreamServiceClient()
+
+	// Create a new request for the GetHistory method
+	req := connect.NewRequest(&streamv1.GetHistoryRequest{
+		Room:   historyRoom,
+		Peer:   historyPeer,
+		Before: historyBefore,
+		After:  historyAfter,
+		Limit:  historyLimit,
+	})
+
+	// Set the authentication headers
+	internal.SetAuthHeaders(req.Header())
+
+	// Call the GetHistory method
+	res, err := client.GetHistory(
+		context.Background(),
+		req,
+	)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to get history: %s\n", internal.DescribeError(err))
+	}
+	for _, msg := range res.Msg.Messages {
+		log.Printf(
+			"[INFO] Message with cursor: %s -> Sent at: %s - %s: <%s>\n",
+			msg.Cursor, msg.SentAt.AsTime().Format(time.RFC3339), msg.Sender, msg.Message,
+		)
+	}
+	if res.Msg.HasMore {
+		log.Printf("[INFO] More messages are available, use the cursors to page through them\n")
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	"github.com/serbanmarti/go-grpc/client/internal"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+)
+
+// streamListOnlineCmd represents the stream-list-online command
+var streamListOnlineCmd = &cobra.Command{
+	Use:   "stream-list-online",
+	Short: "Command to list the users connected to the chat",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runStreamListOnlineCmd()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(streamListOnlineCmd)
+}
+
+func runStreamListOnlineCmd() {
+	// Create a new client to the Stream service
+	client := internal.NewStreamServiceClient()
+
+	// Create a new request for the ListOnline method
+	req := connect.NewRequest(&streamv1.ListOnlineRequest{})
+
+	// Set the authentication headers
+	internal.SetAuthHeaders(req.Header())
+
+	// Call the ListOnline method
+	res, err := client.ListOnline(
+		context.Background(),
+		req,
+	)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to list online users: %s\n", internal.DescribeError(err))
+	}
+	for _, p := range res.Msg.Users {
+		log.Printf("[INFO] User: %s - Status: %s - Since: %s\n", p.User, p.Status, p.Since.AsTime().Format(time.RFC3339))
+	}
+	log.Printf("[INFO] Users online: %d\n", len(res.Msg.Users))
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	"github.com/serbanmarti/go-grpc/client/internal"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+)
+
+// streamPublishCmd represents the stream-publish command
+var streamPublishCmd = &cobra.Command{
+	Use:   "stream-publish [topic] [message]",
+	Short: "Command to publish an announcement to the subscribers of a topic (requires ADMIN_TOKEN)",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runStreamPublishCmd(args[0], args[1])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(streamPublishCmd)
+}
+
+func runStreamPublishCmd(topic, message string) {
+	// Create a new client to the Stream service
+	client := internal.NewStreamServiceClient()
+
+	// Create a new request for the Publish method
+	req := connect.NewRequest(&streamv1.PublishRequest{
+		Topic:   topic,
+		Message: message,
+	})
+
+	// Set the authentication headers, publishing being reserved to admins
+	internal.SetAdminHeaders(req.Header())
+
+	// Call the Publish method
+	res, err := client.Publish(
+		context.Background(),
+		req,
+	)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to publish announcement: %s\n", internal.DescribeError(err))
+	}
+	log.Printf("[INFO] Announcement published with ID: %s -> Subscribers: %d\n", res.Msg.Id, res.Msg.Subscribers)
+}
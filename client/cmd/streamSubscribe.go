@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	"github.com/serbanmarti/go-grpc/client/internal"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+)
+
+// streamSubscribeCmd represents the stream-subscribe command
+var streamSubscribeCmd = &cobra.Command{
+	Use:   "stream-subscribe [topic...]",
+	Short: "Command to receive the announcements published to the given topics",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runStreamSubscribeCmd(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(streamSubscribeCmd)
+}
+
+func runStreamSubscribeCmd(topics []string) {
+	// Create a new client to the Stream service
+	client := internal.NewStreamServiceClient()
+
+	// Create a new request for the Subscribe method
+	req := connect.NewRequest(&streamv1.SubscribeRequest{
+		Topics: topics,
+	})
+
+	// Set the authentication headers
+	internal.SetAuthHeaders(req.Header())
+
+	// Call the Subscribe method
+	stream, err := client.Subscribe(
+		context.Background(),
+		req,
+	)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to subscribe: %s\n", internal.DescribeError(err))
+	}
+	defer stream.Close()
+
+	// Print the announcements until the server ends the stream
+	for stream.Receive() {
+		msg := stream.Msg()
+		retained := ""
+		if msg.Retained {
+			retained = " (retained)"
+		}
+		log.Printf(
+			"[INFO] Announcement on %s%s -> Published at: %s - %s: <%s>\n",
+			msg.Topic, retained, msg.PublishedAt.AsTime().Format(time.RFC3339), msg.Publisher, msg.Message,
+		)
+	}
+	if err := stream.Err(); err != nil {
+		log.Fatalf("[ERROR] Failed to receive announcement: %s\n", internal.DescribeError(err))
+	}
+}
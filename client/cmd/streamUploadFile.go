@@ -1,69 +1,312 @@
 package cmd
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 
 	"github.com/spf13/cobra"
 
 	"github.com/serbanmarti/go-grpc/client/internal"
-	"github.com/serbanmarti/go-grpc/env"
 	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+)
+
+// stdinPath is the path argument used to upload the standard input
+const stdinPath = "-"
+
+var (
+	uploadChunkSize   int
+	uploadConcurrency int
+	uploadStdinName   string
+	uploadDeclareHash bool
+	uploadCompression string
 )
 
 // streamUploadFileCmd represents the stream-upload-file command
 var streamUploadFileCmd = &cobra.Command{
-	Use:   "stream-upload-file",
-	Short: "Command to stream upload a file",
+	Use:   "stream-upload-file [path...]",
+	Short: "Command to stream upload files or directories from disk (use - for stdin)",
+	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		runStreamUploadFileCmd()
+		runStreamUploadFileCmd(args)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(streamUploadFileCmd)
+
+	streamUploadFileCmd.Flags().IntVar(&uploadChunkSize, "chunk-size", 32*1024, "size in bytes of each streamed chunk")
+	streamUploadFileCmd.Flags().IntVar(&uploadConcurrency, "concurrency", 4, "number of files uploaded at the same time")
+	streamUploadFileCmd.Flags().StringVar(&uploadStdinName, "stdin-name", "stdin", "file name used when uploading from stdin")
+	streamUploadFileCmd.Flags().BoolVar(&uploadDeclareHash, "declare-digest", true, "declare the file digest up front, skipping content you already uploaded")
+	streamUploadFileCmd.Flags().StringVar(&uploadCompression, "compression", "none", "compression applied to each chunk (none, gzip)")
 }
 
-func runStreamUploadFileCmd() {
+// uploadSource describes a single file to be uploaded
+type uploadSource struct {
+	path string // Path on disk, or stdinPath for the standard input
+	name string // File name sent to the server
+	size int64  // Size of the file, -1 if unknown
+}
+
+func runStreamUploadFileCmd(paths []string) {
+	if uploadChunkSize <= 0 {
+		log.Fatalf("[ERROR] Chunk size must be positive, got %d\n", uploadChunkSize)
+	}
+	if uploadConcurrency <= 0 {
+		log.Fatalf("[ERROR] Concurrency must be positive, got %d\n", uploadConcurrency)
+	}
+	if uploadCompression != "none" && uploadCompression != "gzip" {
+		log.Fatalf("[ERROR] Unsupported compression: %s\n", uploadCompression)
+	}
+
+	// Resolve the paths to the list of files to upload
+	sources, err := collectUploadSources(paths)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to collect files to upload: %v\n", err)
+	}
+	if len(sources) == 0 {
+		log.Fatalf("[ERROR] No files found to upload\n")
+	}
+
+	// The total is only known if every file size is known (stdin has no size)
+	var total int64
+	for _, src := range sources {
+		if src.size < 0 {
+			total = 0
+			break
+		}
+		total += src.size
+	}
+
 	// Create a new client to the Stream service
 	client := internal.NewStreamServiceClient()
 
-	// Get the environment configuration
-	environment := env.GetEnvironment()
+	progress := internal.NewProgress(os.Stderr, total)
+	progress.Start()
+
+	// Upload the files concurrently, bounded by the configured concurrency
+	var wg sync.WaitGroup
+	var failures atomic.Int32
+	sem := make(chan struct{}, uploadConcurrency)
+	for _, src := range sources {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(src uploadSource) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			res, err := uploadFile(context.Background(), client, src, progress)
+			if err != nil {
+				failures.Add(1)
+				progress.Printf("[ERROR] Failed to upload %s: %s\n", src.path, internal.DescribeError(err))
+				return
+			}
+			dedup := ""
+			if res.Deduplicated {
+				dedup = " (deduplicated)"
+			}
+			progress.Printf(
+				"[INFO] File uploaded%s! Received confirmation: ID: %s - Filename: %s - Size: %d - Wire size: %d\n",
+				dedup, res.Id, res.FileName, res.Size, res.WireSize,
+			)
+		}(src)
+	}
+	wg.Wait()
+	progress.Stop()
+
+	// Report a failure exit code if any of the uploads failed
+	if n := failures.Load(); n > 0 {
+		log.Fatalf("[ERROR] %d of %d uploads failed\n", n, len(sources))
+	}
+	log.Printf("[INFO] Uploaded %d file(s) successfully\n", len(sources))
+}
+
+// collectUploadSources expands the given paths into the files to upload, walking directories recursively
+func collectUploadSources(paths []string) ([]uploadSource, error) {
+	var sources []uploadSource
+	stdinUsed := false
+
+	for _, path := range paths {
+		// The standard input can only be read once
+		if path == stdinPath {
+			if stdinUsed {
+				return nil, fmt.Errorf("stdin can only be uploaded once")
+			}
+			stdinUsed = true
+			sources = append(sources, uploadSource{path: stdinPath, name: uploadStdinName, size: -1})
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			sources = append(sources, uploadSource{path: path, name: filepath.Base(path), size: info.Size()})
+			continue
+		}
+
+		// Files in a directory are named relative to the directory's parent, so the directory name is kept
+		parent := filepath.Dir(filepath.Clean(path))
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			name, err := filepath.Rel(parent, p)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, uploadSource{path: p, name: filepath.ToSlash(name), size: info.Size()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sources, nil
+}
+
+// uploadFile streams a single file to the server in chunks, reporting the sent bytes to the progress
+func uploadFile(
+	ctx context.Context,
+	client streamv1connect.StreamServiceClient,
+	src uploadSource,
+	progress *internal.Progress,
+) (*streamv1.UploadFileResponse, error) {
+	// Open the file to upload
+	var reader io.Reader
+	digest := ""
+	if src.path == stdinPath {
+		reader = os.Stdin
+	} else {
+		file, err := os.Open(src.path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+
+		// Compute the digest up front, so the server can skip the upload if we already stored the content
+		if uploadDeclareHash {
+			digest, err = fileDigest(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compute file digest: %w", err)
+			}
+		}
+	}
 
 	// Create a new stream for the UploadFile method
-	stream := client.UploadFile(context.Background())
-
-	// Set the authentication token
-	stream.RequestHeader().Set(environment.TokenHeader, environment.TokenSecret)
-
-	// We simulate the file being read in chunks, and requests being created for each chunk
-	// In a real-world scenario, the file would be read in chunks from disk
-	reqData := []streamv1.UploadFileRequest{
-		{
-			FileName: "smaller.txt",
-			Chunk:    []byte("Hello, World!"),
-		},
-		{
-			Chunk: []byte("This is a small file."),
-		},
-		{
-			Chunk: []byte("Goodbye!"),
-		},
-	}
-
-	// Send each request to the server
-	for _, req := range reqData {
-		err := stream.Send(&req)
+	stream := client.UploadFile(ctx)
+
+	// Set the authentication headers
+	internal.SetAuthHeaders(stream.RequestHeader())
+
+	// Read the file in chunks and send each one to the server, the first one carrying the file name
+	buf := make([]byte, uploadChunkSize)
+	first := true
+	sent := int64(0)
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 || first {
+			req, err := newUploadFileRequest(buf[:n])
+			if err != nil {
+				return nil, fmt.Errorf("failed to compress chunk: %w", err)
+			}
+			if first {
+				req.FileName = src.name
+				req.Sha256 = digest
+				first = false
+			}
+			if err := stream.Send(req); err != nil {
+				// The server closed the stream, the actual error is returned when receiving the response
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, fmt.Errorf("failed to stream request: %w", err)
+			}
+			progress.Add(n)
+			sent += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
 		if err != nil {
-			log.Fatalf("[ERROR] Failed to stream request: %v\n", err)
+			return nil, fmt.Errorf("failed to read file: %w", err)
 		}
 	}
 
 	// Close the stream
 	res, err := stream.CloseAndReceive()
 	if err != nil {
-		log.Fatalf("[ERROR] Failed to close and receive from the stream: %v\n", err)
+		return nil, fmt.Errorf("failed to close and receive from the stream: %w", err)
+	}
+
+	// Account for the content the server did not need, so the progress still completes
+	if res.Msg.Deduplicated && src.size > sent {
+		progress.Add(int(src.size - sent))
+	}
+	return res.Msg, nil
+}
+
+// newUploadFileRequest creates the request carrying a chunk, compressed as configured
+// A chunk is only sent compressed if that actually makes it smaller
+func newUploadFileRequest(chunk []byte) (*streamv1.UploadFileRequest, error) {
+	req := &streamv1.UploadFileRequest{
+		Chunk: chunk,
+	}
+	if uploadCompression != "gzip" || len(chunk) == 0 {
+		return req, nil
+	}
+
+	var compressed bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&compressed, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gz.Write(chunk); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	if compressed.Len() < len(chunk) {
+		req.Chunk = compressed.Bytes()
+		req.Compression = streamv1.Compression_COMPRESSION_GZIP
+	}
+	return req, nil
+}
+
+// fileDigest computes the hex-encoded SHA-256 digest of a file, rewinding it afterwards
+func fileDigest(file *os.File) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
 	}
-	log.Printf("[INFO] File uploaded! Received confirmation: Filename: %s - Size: %d\n", res.Msg.FileName, res.Msg.Size)
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
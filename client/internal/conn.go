@@ -3,9 +3,13 @@ package internal
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"connectrpc.com/connect"
@@ -13,39 +17,184 @@ import (
 
 	"github.com/serbanmarti/go-grpc/env"
 	"github.com/serbanmarti/go-grpc/proto_gen/crud/v1/crudv1connect"
+	"github.com/serbanmarti/go-grpc/proto_gen/files/v1/filesv1connect"
 	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+	"github.com/serbanmarti/go-grpc/server/interceptor"
+	"github.com/serbanmarti/go-grpc/server/tracing"
 )
 
-func newInsecureClient() *http.Client {
+// TLSOptions configures the TLS connection to the server, set from the flags of the root command
+type TLSOptions struct {
+	Enabled    bool   // Connect over TLS, implied by the other options
+	CAFile     string // PEM-encoded CAs verifying the server certificate, the system ones if empty
+	CertFile   string // PEM-encoded client certificate, presented for mutual TLS
+	KeyFile    string // PEM-encoded private key of the client certificate
+	ServerName string // Name the server certificate is verified against, the host dialed if empty
+}
+
+// TLS holds the TLS options of the client
+var TLS TLSOptions
+
+// enabled reports whether the client connects over TLS
+func (o TLSOptions) enabled() bool {
+	return o.Enabled || o.CAFile != "" || o.CertFile != "" || o.ServerName != ""
+}
+
+// config builds the TLS configuration of the client
+func (o TLSOptions) config() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: o.ServerName,
+	}
+	if o.CAFile != "" {
+		data, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate in %s", o.CAFile)
+		}
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// newHTTPClient creates an HTTP/2 client, over TLS if enabled; a zero timeout disables it,
+// which is needed for streams that may stay open for a long time
+func newHTTPClient(timeout time.Duration) *http.Client {
+	if !TLS.enabled() {
+		return &http.Client{
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return net.Dial(network, addr)
+				},
+			},
+			Timeout: timeout,
+		}
+	}
+
+	config, err := TLS.config()
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to load the TLS configuration: %v\n", err)
+	}
 	return &http.Client{
 		Transport: &http2.Transport{
-			AllowHTTP: true,
-			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
-				return net.Dial(network, addr)
-			},
+			TLSClientConfig: config,
 		},
-		Timeout: 5 * time.Second,
+		Timeout: timeout,
 	}
 }
 
-func NewCrudServiceClient() crudv1connect.CrudServiceClient {
+// baseURL returns the URL of the server
+func baseURL() string {
 	// Get the environment configuration
 	environment := env.GetEnvironment()
 
-	return crudv1connect.NewCrudServiceClient(
-		newInsecureClient(),
-		fmt.Sprintf("http://localhost:%d", environment.Port),
-		connect.WithGRPC(),
-	)
+	scheme := "http"
+	if TLS.enabled() {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://localhost:%d", scheme, environment.Port)
 }
 
-func NewStreamServiceClient() streamv1connect.StreamServiceClient {
+// SetAuthHeaders sets the authentication token (the JWT bearer token or the API key if configured, the shared secret otherwise)
+// and the client ID (if configured) on the request headers
+func SetAuthHeaders(header http.Header) {
 	// Get the environment configuration
 	environment := env.GetEnvironment()
 
-	return streamv1connect.NewStreamServiceClient(
-		newInsecureClient(),
-		fmt.Sprintf("http://localhost:%d", environment.Port),
+	switch {
+	case environment.BearerToken != "":
+		header.Set("Authorization", "Bearer "+environment.BearerToken)
+	case environment.APIKey != "":
+		header.Set(environment.TokenHeader, environment.APIKey)
+	default:
+		header.Set(environment.TokenHeader, environment.TokenSecret)
+	}
+	if environment.ClientID != "" {
+		header.Set(environment.ClientIDHeader, environment.ClientID)
+	}
+}
+
+// SetAdminHeaders sets the admin token on the request headers, along with the authentication headers
+func SetAdminHeaders(header http.Header) {
+	// Get the environment configuration
+	environment := env.GetEnvironment()
+
+	SetAuthHeaders(header)
+	header.Set(environment.AdminTokenHeader, environment.AdminToken)
+}
+
+var (
+	tracerOnce sync.Once
+	tracer     *tracing.Tracer
+	exporter   *tracing.OTLPExporter // Set if a collector is configured
+)
+
+// clientTracer returns the tracer of the client's requests, exporting their spans if a collector is configured
+func clientTracer() *tracing.Tracer {
+	tracerOnce.Do(func() {
+		// Get the environment configuration
+		environment := env.GetEnvironment()
+
+		var spanExporter tracing.Exporter
+		if environment.TracingOTLPEndpoint != "" {
+			exporter = tracing.NewOTLPExporter(environment.TracingOTLPEndpoint, environment.TracingServiceName+"-client")
+			spanExporter = exporter
+		}
+		tracer = tracing.NewTracer(spanExporter, environment.TracingSampleRatio)
+	})
+	return tracer
+}
+
+// FlushSpans exports the spans of the client's requests not exported yet, if a collector is configured
+func FlushSpans() {
+	if exporter == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exporter.Shutdown(ctx); err != nil {
+		log.Printf("[ERROR] Failed to export the spans: %v\n", err)
+	}
+}
+
+// clientOptions returns the options of the clients, tracing their requests and propagating the trace to the server
+func clientOptions() []connect.ClientOption {
+	return []connect.ClientOption{
 		connect.WithGRPC(),
+		connect.WithInterceptors(interceptor.NewTracingInterceptor(clientTracer())),
+	}
+}
+
+func NewCrudServiceClient() crudv1connect.CrudServiceClient {
+	return crudv1connect.NewCrudServiceClient(
+		newHTTPClient(5*time.Second),
+		baseURL(),
+		clientOptions()...,
+	)
+}
+
+func NewStreamServiceClient() streamv1connect.StreamServiceClient {
+	return streamv1connect.NewStreamServiceClient(
+		newHTTPClient(0),
+		baseURL(),
+		clientOptions()...,
+	)
+}
+
+func NewFileServiceClient() filesv1connect.FileServiceClient {
+	return filesv1connect.NewFileServiceClient(
+		newHTTPClient(5*time.Second),
+		baseURL(),
+		clientOptions()...,
 	)
 }
@@ -0,0 +1,23 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+
+	"connectrpc.com/connect"
+)
+
+// requestIDHeader is the header the server identifies the requests with, in its responses and errors
+const requestIDHeader = "x-request-id"
+
+// DescribeError describes an error along with the ID of the failed request, if the server identified it,
+// so it can be reported and looked for in the server logs
+func DescribeError(err error) string {
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		if id := connectErr.Meta().Get(requestIDHeader); id != "" {
+			return fmt.Sprintf("%v (request ID: %s)", err, id)
+		}
+	}
+	return err.Error()
+}
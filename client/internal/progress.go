@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	progressBarWidth    = 30
+	progressRefreshRate = 200 * time.Millisecond
+)
+
+// Progress renders a single status line with the transferred bytes, throughput and ETA
+// of a set of transfers, allowing other messages to be printed without garbling the line
+type Progress struct {
+	out   io.Writer
+	total int64 // Total number of bytes expected, 0 if unknown
+	done  atomic.Int64
+	start time.Time
+
+	mutex sync.Mutex
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewProgress creates a new progress renderer writing to the given output
+func NewProgress(out io.Writer, total int64) *Progress {
+	return &Progress{
+		out:   out,
+		total: total,
+		stop:  make(chan struct{}),
+	}
+}
+
+// Start begins rendering the status line periodically, until Stop is called
+func (p *Progress) Start() {
+	p.start = time.Now()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(progressRefreshRate)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.mutex.Lock()
+				p.render()
+				p.mutex.Unlock()
+			}
+		}
+	}()
+}
+
+// Add records n more bytes as transferred
+func (p *Progress) Add(n int) {
+	p.done.Add(int64(n))
+}
+
+// Printf prints a message on its own line, redrawing the status line below it
+func (p *Progress) Printf(format string, args ...any) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	fmt.Fprint(p.out, "\r\033[K")
+	fmt.Fprintf(p.out, format, args...)
+	p.render()
+}
+
+// Stop renders the final state of the status line and stops the periodic rendering
+func (p *Progress) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.render()
+	fmt.Fprintln(p.out)
+}
+
+// render writes the status line; the mutex must be held by the caller
+func (p *Progress) render() {
+	done := p.done.Load()
+	elapsed := time.Since(p.start)
+
+	// Compute the throughput over the whole transfer
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(done) / elapsed.Seconds()
+	}
+
+	// Without a known total, we can only show what was transferred so far
+	if p.total <= 0 {
+		fmt.Fprintf(p.out, "\r\033[K%s  %s/s", formatBytes(done), formatBytes(int64(rate)))
+		return
+	}
+
+	ratio := float64(done) / float64(p.total)
+	if ratio > 1 {
+		ratio = 1
+	}
+	filled := int(ratio * progressBarWidth)
+
+	eta := "--"
+	if rate > 0 && done < p.total {
+		eta = time.Duration(float64(p.total-done) / rate * float64(time.Second)).Round(time.Second).String()
+	} else if done >= p.total {
+		eta = "0s"
+	}
+
+	fmt.Fprintf(
+		p.out, "\r\033[K[%s%s] %3.0f%%  %s/%s  %s/s  ETA %s",
+		strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled),
+		ratio*100, formatBytes(done), formatBytes(p.total), formatBytes(int64(rate)), eta,
+	)
+}
+
+// formatBytes returns a human-readable representation of a byte count
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
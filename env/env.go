@@ -3,16 +3,100 @@ package env
 import (
 	"log"
 	"sync"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/joho/godotenv"
 )
 
 type Conf struct {
-	Environment string `env:"ENVIRONMENT" envDefault:"development"`
-	Port        int    `env:"PORT" envDefault:"8080"`
-	TokenSecret string `env:"SECRET_TOKEN,required"`
-	TokenHeader string `env:"TOKEN_HEADER" envDefault:"x-auth-token"`
+	Environment    string `env:"ENVIRONMENT" envDefault:"development"`
+	Port           int    `env:"PORT" envDefault:"8080"`
+	MetricsHost    string `env:"METRICS_HOST" envDefault:"127.0.0.1"`
+	MetricsPort    int    `env:"METRICS_PORT" envDefault:"9090"`
+	TokenSecret    string `env:"SECRET_TOKEN"`
+	TokenHeader    string `env:"TOKEN_HEADER" envDefault:"x-auth-token"`
+	ClientID       string `env:"CLIENT_ID"`
+	ClientIDHeader string `env:"CLIENT_ID_HEADER" envDefault:"x-client-id"`
+	StorageDir     string `env:"STORAGE_DIR" envDefault:"data"`
+	MaxChunkBytes  int64  `env:"UPLOAD_MAX_CHUNK_BYTES" envDefault:"4194304"`
+
+	CrudReadMaxBytes   int `env:"CRUD_READ_MAX_BYTES" envDefault:"65536"`
+	CrudSendMaxBytes   int `env:"CRUD_SEND_MAX_BYTES" envDefault:"65536"`
+	StreamReadMaxBytes int `env:"STREAM_READ_MAX_BYTES" envDefault:"5242880"`
+	StreamSendMaxBytes int `env:"STREAM_SEND_MAX_BYTES" envDefault:"4194304"`
+	FilesReadMaxBytes  int `env:"FILES_READ_MAX_BYTES" envDefault:"65536"`
+	FilesSendMaxBytes  int `env:"FILES_SEND_MAX_BYTES" envDefault:"4194304"`
+
+	StreamMaxReceiveBytes           int64  `env:"STREAM_MAX_RECEIVE_BYTES" envDefault:"1073741824"`
+	StreamMaxReceiveBytesProcedures string `env:"STREAM_MAX_RECEIVE_BYTES_PROCEDURES"`
+
+	APIKey               string        `env:"API_KEY"`
+	APIKeysFile          string        `env:"API_KEYS_FILE"`
+	APIKeysCheckInterval time.Duration `env:"API_KEYS_CHECK_INTERVAL" envDefault:"5s"`
+
+	AuthzPolicyFile string `env:"AUTHZ_POLICY_FILE"`
+
+	CrashDumpDir string `env:"CRASH_DUMP_DIR"`
+
+	AuditLogFile  string `env:"AUDIT_LOG_FILE"`
+	AuditMaxBytes int64  `env:"AUDIT_MAX_BYTES" envDefault:"10485760"`
+	AuditMaxFiles int    `env:"AUDIT_MAX_FILES" envDefault:"0"`
+
+	LogPayloadSampleRate float64  `env:"LOG_PAYLOAD_SAMPLE_RATE" envDefault:"0"`
+	LogRedactedFields    []string `env:"LOG_REDACTED_FIELDS" envDefault:"message,chunk"`
+
+	TracingOTLPEndpoint string  `env:"TRACING_OTLP_ENDPOINT"`
+	TracingServiceName  string  `env:"TRACING_SERVICE_NAME" envDefault:"go-grpc"`
+	TracingSampleRatio  float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+
+	DeadlineDefault       time.Duration `env:"DEADLINE_DEFAULT" envDefault:"30s"`
+	DeadlineMax           time.Duration `env:"DEADLINE_MAX" envDefault:"5m"`
+	DeadlineStreamDefault time.Duration `env:"DEADLINE_STREAM_DEFAULT" envDefault:"0s"`
+	DeadlineStreamMax     time.Duration `env:"DEADLINE_STREAM_MAX" envDefault:"0s"`
+	DeadlineProcedures    string        `env:"DEADLINE_PROCEDURES"`
+
+	RateLimitRate        float64       `env:"RATE_LIMIT_RATE" envDefault:"20"`
+	RateLimitBurst       int           `env:"RATE_LIMIT_BURST" envDefault:"40"`
+	RateLimitProcedures  string        `env:"RATE_LIMIT_PROCEDURES"`
+	RateLimitMaxStreams  int           `env:"RATE_LIMIT_MAX_STREAMS" envDefault:"8"`
+	RateLimitIdleTimeout time.Duration `env:"RATE_LIMIT_IDLE_TIMEOUT" envDefault:"10m"`
+
+	TLSCertFile           string        `env:"TLS_CERT_FILE"`
+	TLSKeyFile            string        `env:"TLS_KEY_FILE"`
+	TLSClientCAFile       string        `env:"TLS_CLIENT_CA_FILE"`
+	TLSClientCertOptional bool          `env:"TLS_CLIENT_CERT_OPTIONAL"`
+	TLSCheckInterval      time.Duration `env:"TLS_CHECK_INTERVAL" envDefault:"5s"`
+
+	AdminToken       string `env:"ADMIN_TOKEN"`
+	AdminTokenHeader string `env:"ADMIN_TOKEN_HEADER" envDefault:"x-admin-token"`
+
+	JWTHMACSecret     string        `env:"JWT_HMAC_SECRET"`
+	JWTPublicKeysFile string        `env:"JWT_PUBLIC_KEYS_FILE"`
+	JWTJWKSFile       string        `env:"JWT_JWKS_FILE"`
+	JWTIssuer         string        `env:"JWT_ISSUER"`
+	JWTAudience       string        `env:"JWT_AUDIENCE"`
+	JWTClockSkew      time.Duration `env:"JWT_CLOCK_SKEW" envDefault:"30s"`
+	BearerToken       string        `env:"BEARER_TOKEN"`
+
+	ChatRedeliveryTimeout time.Duration `env:"CHAT_REDELIVERY_TIMEOUT" envDefault:"30s"`
+	ChatIdleTimeout       time.Duration `env:"CHAT_IDLE_TIMEOUT" envDefault:"2m"`
+	ChatHistoryLimit      int           `env:"CHAT_HISTORY_LIMIT" envDefault:"1000"`
+	ChatHistoryPersist    bool          `env:"CHAT_HISTORY_PERSIST" envDefault:"true"`
+	ChatInboxLimit        int           `env:"CHAT_INBOX_LIMIT" envDefault:"1000"`
+	ChatQueueSize         int           `env:"CHAT_QUEUE_SIZE" envDefault:"64"`
+	ChatQueuePolicy       string        `env:"CHAT_QUEUE_POLICY" envDefault:"drop-oldest"`
+	ChatQueueBlockTimeout time.Duration `env:"CHAT_QUEUE_BLOCK_TIMEOUT" envDefault:"5s"`
+
+	BroadcastRetention int `env:"BROADCAST_RETENTION" envDefault:"10"`
+
+	StreamPingInterval time.Duration `env:"STREAM_PING_INTERVAL" envDefault:"30s"`
+	StreamIdleTimeout  time.Duration `env:"STREAM_IDLE_TIMEOUT" envDefault:"90s"`
+	StreamMaxLifetime  time.Duration `env:"STREAM_MAX_LIFETIME" envDefault:"24h"`
+
+	HTTP2ReadIdleTimeout time.Duration `env:"HTTP2_READ_IDLE_TIMEOUT" envDefault:"1m"`
+	HTTP2PingTimeout     time.Duration `env:"HTTP2_PING_TIMEOUT" envDefault:"15s"`
+	HTTP2IdleTimeout     time.Duration `env:"HTTP2_IDLE_TIMEOUT" envDefault:"5m"`
 }
 
 var lock = &sync.Mutex{}
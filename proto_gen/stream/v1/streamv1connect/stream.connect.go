@@ -39,6 +39,16 @@ const (
 	// StreamServiceDirectMessageProcedure is the fully-qualified name of the StreamService's
 	// DirectMessage RPC.
 	StreamServiceDirectMessageProcedure = "/stream.v1.StreamService/DirectMessage"
+	// StreamServiceListOnlineProcedure is the fully-qualified name of the StreamService's ListOnline
+	// RPC.
+	StreamServiceListOnlineProcedure = "/stream.v1.StreamService/ListOnline"
+	// StreamServiceGetHistoryProcedure is the fully-qualified name of the StreamService's GetHistory
+	// RPC.
+	StreamServiceGetHistoryProcedure = "/stream.v1.StreamService/GetHistory"
+	// StreamServiceSubscribeProcedure is the fully-qualified name of the StreamService's Subscribe RPC.
+	StreamServiceSubscribeProcedure = "/stream.v1.StreamService/Subscribe"
+	// StreamServicePublishProcedure is the fully-qualified name of the StreamService's Publish RPC.
+	StreamServicePublishProcedure = "/stream.v1.StreamService/Publish"
 )
 
 // These variables are the protoreflect.Descriptor objects for the RPCs defined in this package.
@@ -46,12 +56,22 @@ var (
 	streamServiceServiceDescriptor             = v1.File_stream_v1_stream_proto.Services().ByName("StreamService")
 	streamServiceUploadFileMethodDescriptor    = streamServiceServiceDescriptor.Methods().ByName("UploadFile")
 	streamServiceDirectMessageMethodDescriptor = streamServiceServiceDescriptor.Methods().ByName("DirectMessage")
+	streamServiceListOnlineMethodDescriptor    = streamServiceServiceDescriptor.Methods().ByName("ListOnline")
+	streamServiceGetHistoryMethodDescriptor    = streamServiceServiceDescriptor.Methods().ByName("GetHistory")
+	streamServiceSubscribeMethodDescriptor     = streamServiceServiceDescriptor.Methods().ByName("Subscribe")
+	streamServicePublishMethodDescriptor       = streamServiceServiceDescriptor.Methods().ByName("Publish")
 )
 
 // StreamServiceClient is a client for the stream.v1.StreamService service.
 type StreamServiceClient interface {
 	UploadFile(context.Context) *connect.ClientStreamForClient[v1.UploadFileRequest, v1.UploadFileResponse]
 	DirectMessage(context.Context) *connect.BidiStreamForClient[v1.DirectMessageRequest, v1.DirectMessageResponse]
+	ListOnline(context.Context, *connect.Request[v1.ListOnlineRequest]) (*connect.Response[v1.ListOnlineResponse], error)
+	GetHistory(context.Context, *connect.Request[v1.GetHistoryRequest]) (*connect.Response[v1.GetHistoryResponse], error)
+	// Streams the announcements published to the requested topics, starting with the ones retained for late subscribers
+	Subscribe(context.Context, *connect.Request[v1.SubscribeRequest]) (*connect.ServerStreamForClient[v1.Announcement], error)
+	// Publishes an announcement to the subscribers of a topic, reserved to the callers presenting the admin token
+	Publish(context.Context, *connect.Request[v1.PublishRequest]) (*connect.Response[v1.PublishResponse], error)
 }
 
 // NewStreamServiceClient constructs a client for the stream.v1.StreamService service. By default,
@@ -76,6 +96,30 @@ func NewStreamServiceClient(httpClient connect.HTTPClient, baseURL string, opts
 			connect.WithSchema(streamServiceDirectMessageMethodDescriptor),
 			connect.WithClientOptions(opts...),
 		),
+		listOnline: connect.NewClient[v1.ListOnlineRequest, v1.ListOnlineResponse](
+			httpClient,
+			baseURL+StreamServiceListOnlineProcedure,
+			connect.WithSchema(streamServiceListOnlineMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		getHistory: connect.NewClient[v1.GetHistoryRequest, v1.GetHistoryResponse](
+			httpClient,
+			baseURL+StreamServiceGetHistoryProcedure,
+			connect.WithSchema(streamServiceGetHistoryMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		subscribe: connect.NewClient[v1.SubscribeRequest, v1.Announcement](
+			httpClient,
+			baseURL+StreamServiceSubscribeProcedure,
+			connect.WithSchema(streamServiceSubscribeMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		publish: connect.NewClient[v1.PublishRequest, v1.PublishResponse](
+			httpClient,
+			baseURL+StreamServicePublishProcedure,
+			connect.WithSchema(streamServicePublishMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
 	}
 }
 
@@ -83,6 +127,10 @@ func NewStreamServiceClient(httpClient connect.HTTPClient, baseURL string, opts
 type streamServiceClient struct {
 	uploadFile    *connect.Client[v1.UploadFileRequest, v1.UploadFileResponse]
 	directMessage *connect.Client[v1.DirectMessageRequest, v1.DirectMessageResponse]
+	listOnline    *connect.Client[v1.ListOnlineRequest, v1.ListOnlineResponse]
+	getHistory    *connect.Client[v1.GetHistoryRequest, v1.GetHistoryResponse]
+	subscribe     *connect.Client[v1.SubscribeRequest, v1.Announcement]
+	publish       *connect.Client[v1.PublishRequest, v1.PublishResponse]
 }
 
 // UploadFile calls stream.v1.StreamService.UploadFile.
@@ -95,10 +143,36 @@ func (c *streamServiceClient) DirectMessage(ctx context.Context) *connect.BidiSt
 	return c.directMessage.CallBidiStream(ctx)
 }
 
+// ListOnline calls stream.v1.StreamService.ListOnline.
+func (c *streamServiceClient) ListOnline(ctx context.Context, req *connect.Request[v1.ListOnlineRequest]) (*connect.Response[v1.ListOnlineResponse], error) {
+	return c.listOnline.CallUnary(ctx, req)
+}
+
+// GetHistory calls stream.v1.StreamService.GetHistory.
+func (c *streamServiceClient) GetHistory(ctx context.Context, req *connect.Request[v1.GetHistoryRequest]) (*connect.Response[v1.GetHistoryResponse], error) {
+	return c.getHistory.CallUnary(ctx, req)
+}
+
+// Subscribe calls stream.v1.StreamService.Subscribe.
+func (c *streamServiceClient) Subscribe(ctx context.Context, req *connect.Request[v1.SubscribeRequest]) (*connect.ServerStreamForClient[v1.Announcement], error) {
+	return c.subscribe.CallServerStream(ctx, req)
+}
+
+// Publish calls stream.v1.StreamService.Publish.
+func (c *streamServiceClient) Publish(ctx context.Context, req *connect.Request[v1.PublishRequest]) (*connect.Response[v1.PublishResponse], error) {
+	return c.publish.CallUnary(ctx, req)
+}
+
 // StreamServiceHandler is an implementation of the stream.v1.StreamService service.
 type StreamServiceHandler interface {
 	UploadFile(context.Context, *connect.ClientStream[v1.UploadFileRequest]) (*connect.Response[v1.UploadFileResponse], error)
 	DirectMessage(context.Context, *connect.BidiStream[v1.DirectMessageRequest, v1.DirectMessageResponse]) error
+	ListOnline(context.Context, *connect.Request[v1.ListOnlineRequest]) (*connect.Response[v1.ListOnlineResponse], error)
+	GetHistory(context.Context, *connect.Request[v1.GetHistoryRequest]) (*connect.Response[v1.GetHistoryResponse], error)
+	// Streams the announcements published to the requested topics, starting with the ones retained for late subscribers
+	Subscribe(context.Context, *connect.Request[v1.SubscribeRequest], *connect.ServerStream[v1.Announcement]) error
+	// Publishes an announcement to the subscribers of a topic, reserved to the callers presenting the admin token
+	Publish(context.Context, *connect.Request[v1.PublishRequest]) (*connect.Response[v1.PublishResponse], error)
 }
 
 // NewStreamServiceHandler builds an HTTP handler from the service implementation. It returns the
@@ -119,12 +193,44 @@ func NewStreamServiceHandler(svc StreamServiceHandler, opts ...connect.HandlerOp
 		connect.WithSchema(streamServiceDirectMessageMethodDescriptor),
 		connect.WithHandlerOptions(opts...),
 	)
+	streamServiceListOnlineHandler := connect.NewUnaryHandler(
+		StreamServiceListOnlineProcedure,
+		svc.ListOnline,
+		connect.WithSchema(streamServiceListOnlineMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	streamServiceGetHistoryHandler := connect.NewUnaryHandler(
+		StreamServiceGetHistoryProcedure,
+		svc.GetHistory,
+		connect.WithSchema(streamServiceGetHistoryMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	streamServiceSubscribeHandler := connect.NewServerStreamHandler(
+		StreamServiceSubscribeProcedure,
+		svc.Subscribe,
+		connect.WithSchema(streamServiceSubscribeMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	streamServicePublishHandler := connect.NewUnaryHandler(
+		StreamServicePublishProcedure,
+		svc.Publish,
+		connect.WithSchema(streamServicePublishMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
 	return "/stream.v1.StreamService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case StreamServiceUploadFileProcedure:
 			streamServiceUploadFileHandler.ServeHTTP(w, r)
 		case StreamServiceDirectMessageProcedure:
 			streamServiceDirectMessageHandler.ServeHTTP(w, r)
+		case StreamServiceListOnlineProcedure:
+			streamServiceListOnlineHandler.ServeHTTP(w, r)
+		case StreamServiceGetHistoryProcedure:
+			streamServiceGetHistoryHandler.ServeHTTP(w, r)
+		case StreamServiceSubscribeProcedure:
+			streamServiceSubscribeHandler.ServeHTTP(w, r)
+		case StreamServicePublishProcedure:
+			streamServicePublishHandler.ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -141,3 +247,19 @@ func (UnimplementedStreamServiceHandler) UploadFile(context.Context, *connect.Cl
 func (UnimplementedStreamServiceHandler) DirectMessage(context.Context, *connect.BidiStream[v1.DirectMessageRequest, v1.DirectMessageResponse]) error {
 	return connect.NewError(connect.CodeUnimplemented, errors.New("stream.v1.StreamService.DirectMessage is not implemented"))
 }
+
+func (UnimplementedStreamServiceHandler) ListOnline(context.Context, *connect.Request[v1.ListOnlineRequest]) (*connect.Response[v1.ListOnlineResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("stream.v1.StreamService.ListOnline is not implemented"))
+}
+
+func (UnimplementedStreamServiceHandler) GetHistory(context.Context, *connect.Request[v1.GetHistoryRequest]) (*connect.Response[v1.GetHistoryResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("stream.v1.StreamService.GetHistory is not implemented"))
+}
+
+func (UnimplementedStreamServiceHandler) Subscribe(context.Context, *connect.Request[v1.SubscribeRequest], *connect.ServerStream[v1.Announcement]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("stream.v1.StreamService.Subscribe is not implemented"))
+}
+
+func (UnimplementedStreamServiceHandler) Publish(context.Context, *connect.Request[v1.PublishRequest]) (*connect.Response[v1.PublishResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("stream.v1.StreamService.Publish is not implemented"))
+}
@@ -0,0 +1,372 @@
+// Package audit records the changes made through the services in a tamper-evident log:
+// each entry carries the hash of the previous one, so altering, removing or reordering entries breaks the chain
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+)
+
+const (
+	// DefaultMaxBytes is the size past which the log file is rotated
+	DefaultMaxBytes = 10 << 20
+
+	// OutcomeOK is the outcome of the successful changes, the failed ones having the code of their error
+	OutcomeOK = "ok"
+
+	// rotatedSuffixLength is the number of digits of the sequence number ending the name of the rotated files
+	rotatedSuffixLength = 12
+)
+
+// genesisHash is the previous hash of the first entry of a log
+var genesisHash = strings.Repeat("0", sha256.Size*2)
+
+// Event describes a change, or an attempted one, made by a caller
+// Caller is the principal the caller authenticated as, while Subject is the name it goes by, which callers
+// of the shared secret declare themselves, so it only tells them apart
+// Before and After are the values of the record before and after the change, if any
+type Event struct {
+	Caller    string `json:"caller"`
+	Subject   string `json:"subject,omitempty"`
+	Procedure string `json:"procedure"`
+	RecordID  string `json:"record_id,omitempty"`
+	Before    any    `json:"before,omitempty"`
+	After     any    `json:"after,omitempty"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Outcome returns the outcome of a change failed with the given error, OutcomeOK if it is nil
+func Outcome(err error) string {
+	if err == nil {
+		return OutcomeOK
+	}
+	return connect.CodeOf(err).String()
+}
+
+// Entry is an event as recorded in the log, chained to the previous entry through its hash
+type Entry struct {
+	Sequence uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	PrevHash string    `json:"prev_hash"`
+	Event
+}
+
+// line is a line of the log file: an entry, along with the hash of its exact encoding
+// The entry is kept raw, so the hash can be checked against the bytes written
+type line struct {
+	Entry json.RawMessage `json:"entry"`
+	Hash  string          `json:"hash"`
+}
+
+func hashEntry(entry []byte) string {
+	sum := sha256.Sum256(entry)
+	return hex.EncodeToString(sum[:])
+}
+
+// Config configures where the log is written and when it is rotated
+type Config struct {
+	// Path is the file the entries are appended to, the rotated files being named after it
+	Path string
+	// MaxBytes is the size past which the file is rotated, DefaultMaxBytes if not positive
+	MaxBytes int64
+	// MaxFiles is the number of rotated files kept, the oldest ones being removed; 0 keeps them all
+	MaxFiles int
+}
+
+// Log appends the events as NDJSON to a file, rotated once it grows past its maximum size
+// The chain continues across the rotated files, which are named after the sequence number of their last entry
+// A nil log records nothing
+type Log struct {
+	config Config
+
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+	sequence uint64
+	lastHash string
+}
+
+// Open opens the log, continuing the chain of the entries already written, if any
+func Open(config Config) (*Log, error) {
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DefaultMaxBytes
+	}
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0o700); err != nil {
+		return nil, err
+	}
+
+	l := &Log{config: config, lastHash: genesisHash}
+	if err := l.resume(); err != nil {
+		return nil, err
+	}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// resume continues the chain from the last entry written, in the current file or else in the last rotated one
+func (l *Log) resume() error {
+	files, err := Files(l.config.Path)
+	if err != nil {
+		return err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		last, err := lastLine(files[i])
+		if err != nil {
+			return err
+		}
+		if last == nil {
+			continue
+		}
+
+		entry, hash, err := parseLine(last)
+		if err != nil {
+			return fmt.Errorf("last entry of %s: %w", files[i], err)
+		}
+		l.sequence, l.lastHash = entry.Sequence, hash
+		return nil
+	}
+	return nil
+}
+
+// openFile opens the current file for appending, creating it if needed
+func (l *Log) openFile() error {
+	file, err := os.OpenFile(l.config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// Record appends an event to the log, returning once it is written to disk
+func (l *Log) Record(event Event) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Chain the entry to the previous one
+	entry, err := json.Marshal(Entry{
+		Sequence: l.sequence + 1,
+		Time:     time.Now().UTC(),
+		PrevHash: l.lastHash,
+		Event:    event,
+	})
+	if err != nil {
+		return err
+	}
+	hash := hashEntry(entry)
+	data, err := json.Marshal(line{Entry: entry, Hash: hash})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	// Rotate the file first if the entry would make it grow past its maximum size
+	if l.size > 0 && l.size+int64(len(data)) > l.config.MaxBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := l.file.Write(data); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.size += int64(len(data))
+	l.sequence++
+	l.lastHash = hash
+	return nil
+}
+
+// rotate renames the current file after its last entry and starts a new one, removing the oldest rotated files
+// The mutex must be held
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	rotated := fmt.Sprintf("%s.%0*d", l.config.Path, rotatedSuffixLength, l.sequence)
+	if err := os.Rename(l.config.Path, rotated); err != nil {
+		return err
+	}
+	if err := l.openFile(); err != nil {
+		return err
+	}
+
+	if l.config.MaxFiles > 0 {
+		files, err := rotatedFiles(l.config.Path)
+		if err != nil {
+			return err
+		}
+		for len(files) > l.config.MaxFiles {
+			if err := os.Remove(files[0]); err != nil {
+				return err
+			}
+			files = files[1:]
+		}
+	}
+	return nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.file.Close()
+}
+
+// Files returns the files of the log at the given path, in the order they were written:
+// the rotated files, then the current one (if it exists)
+func Files(path string) ([]string, error) {
+	files, err := rotatedFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return files, nil
+}
+
+// rotatedFiles returns the rotated files of the log at the given path, oldest first
+func rotatedFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, path+".")
+		if _, err := strconv.ParseUint(suffix, 10, 64); err == nil && len(suffix) == rotatedSuffixLength {
+			files = append(files, match)
+		}
+	}
+	// The zero-padded sequence numbers sort in the order the files were rotated
+	sort.Strings(files)
+	return files, nil
+}
+
+// lastLine returns the last line of a file, or nil if it is empty
+func lastLine(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	return data, nil
+}
+
+// parseLine decodes a line of the log, checking the hash of its entry
+func parseLine(data []byte) (Entry, string, error) {
+	var l line
+	if err := json.Unmarshal(data, &l); err != nil {
+		return Entry{}, "", fmt.Errorf("malformed line: %w", err)
+	}
+	if hashEntry(l.Entry) != l.Hash {
+		return Entry{}, "", fmt.Errorf("hash mismatch")
+	}
+	var entry Entry
+	if err := json.Unmarshal(l.Entry, &entry); err != nil {
+		return Entry{}, "", fmt.Errorf("malformed entry: %w", err)
+	}
+	return entry, l.Hash, nil
+}
+
+// Summary describes the entries of a verified log
+type Summary struct {
+	Entries       int
+	FirstSequence uint64
+	LastSequence  uint64
+	LastHash      string
+}
+
+// Verify checks the chain of the entries of the given files, read in order, returning the first break found
+// The first entry may follow removed rotated files, so its previous hash is only checked if it starts the log
+// Entries removed from the end of the log cannot be detected from the log alone, but only by comparing
+// the last hash with one recorded elsewhere
+func Verify(files []string) (Summary, error) {
+	var summary Summary
+	for _, path := range files {
+		if err := verifyFile(path, &summary); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// verifyFile checks the chain of the entries of a file, continuing the summary of the previous files
+func verifyFile(path string, summary *Summary) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for number := 1; scanner.Scan(); number++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry, hash, err := parseLine(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, number, err)
+		}
+
+		switch {
+		case summary.Entries == 0 && entry.Sequence == 1 && entry.PrevHash != genesisHash:
+			return fmt.Errorf("%s:%d: first entry does not start the chain", path, number)
+		case summary.Entries > 0 && entry.Sequence != summary.LastSequence+1:
+			return fmt.Errorf("%s:%d: sequence %d follows %d", path, number, entry.Sequence, summary.LastSequence)
+		case summary.Entries > 0 && entry.PrevHash != summary.LastHash:
+			return fmt.Errorf("%s:%d: previous hash does not match the previous entry", path, number)
+		}
+
+		if summary.Entries == 0 {
+			summary.FirstSequence = entry.Sequence
+		}
+		summary.Entries++
+		summary.LastSequence = entry.Sequence
+		summary.LastHash = hash
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,179 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordEvents records n update events in the log
+func recordEvents(t *testing.T, l *Log, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		err := l.Record(Event{
+			Caller:    "alice",
+			Procedure: "/crud.v1.CrudService/Update",
+			RecordID:  "record",
+			Before:    "Old Name",
+			After:     "New Name",
+			Outcome:   OutcomeOK,
+		})
+		require.NoError(t, err)
+	}
+}
+
+// verifyLog verifies the log at the given path, along with its rotated files
+func verifyLog(t *testing.T, path string) (Summary, error) {
+	t.Helper()
+	files, err := Files(path)
+	require.NoError(t, err)
+	return Verify(files)
+}
+
+func TestLogChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+
+	l, err := Open(Config{Path: path})
+	require.NoError(t, err)
+	recordEvents(t, l, 3)
+	require.NoError(t, l.Close())
+
+	// Reopening the log continues the chain
+	l, err = Open(Config{Path: path})
+	require.NoError(t, err)
+	recordEvents(t, l, 2)
+	require.NoError(t, l.Close())
+
+	summary, err := verifyLog(t, path)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, summary.Entries)
+	assert.Equal(t, uint64(1), summary.FirstSequence)
+	assert.Equal(t, uint64(5), summary.LastSequence)
+	assert.Len(t, summary.LastHash, 64)
+}
+
+func TestLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// Each entry takes more than half of the maximum size, so every entry starts a new file
+	l, err := Open(Config{Path: path, MaxBytes: 400, MaxFiles: 2})
+	require.NoError(t, err)
+	recordEvents(t, l, 4)
+
+	files, err := Files(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{path + ".000000000002", path + ".000000000003", path}, files)
+
+	// The chain continues across the files, the removed ones being tolerated
+	summary, err := Verify(files)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, summary.Entries)
+	assert.Equal(t, uint64(2), summary.FirstSequence)
+
+	// Reopening continues from the last entry, even from a rotated file if the current one is missing
+	require.NoError(t, l.Close())
+	require.NoError(t, os.Remove(path))
+	l, err = Open(Config{Path: path, MaxBytes: 400, MaxFiles: 2})
+	require.NoError(t, err)
+	recordEvents(t, l, 1)
+	require.NoError(t, l.Close())
+
+	summary, err = verifyLog(t, path)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), summary.LastSequence)
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		err    string
+	}{
+		{
+			name: "altered value",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte("New Name"), []byte("Bad Name"), 1)
+				return lines
+			},
+			err: "audit.log:2: hash mismatch",
+		},
+		{
+			name: "altered value with its hash recomputed",
+			tamper: func(lines [][]byte) [][]byte {
+				entry, _, err := parseLine(lines[1])
+				require.NoError(t, err)
+				entry.After = "Bad Name"
+				lines[1] = encodeLine(t, entry)
+				return lines
+			},
+			err: "audit.log:3: previous hash does not match the previous entry",
+		},
+		{
+			name: "removed entry",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines[:1], lines[2:]...)
+			},
+			err: "audit.log:2: sequence 3 follows 1",
+		},
+		{
+			name: "reordered entries",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			err: "audit.log:2: sequence 3 follows 1",
+		},
+		{
+			name: "forged first entry",
+			tamper: func(lines [][]byte) [][]byte {
+				entry, _, err := parseLine(lines[0])
+				require.NoError(t, err)
+				entry.PrevHash = hashEntry([]byte("forged"))
+				lines[0] = encodeLine(t, entry)
+				return lines
+			},
+			err: "audit.log:1: first entry does not start the chain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			l, err := Open(Config{Path: path})
+			require.NoError(t, err)
+			recordEvents(t, l, 3)
+			require.NoError(t, l.Close())
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			lines := tt.tamper(bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n")))
+			require.NoError(t, os.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0o600))
+
+			_, err = verifyLog(t, path)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var l *Log
+	assert.NoError(t, l.Record(Event{Procedure: "/crud.v1.CrudService/Create", Outcome: OutcomeOK}))
+	assert.NoError(t, l.Close())
+}
+
+// encodeLine encodes an entry as a line of the log, with a valid hash
+func encodeLine(t *testing.T, entry Entry) []byte {
+	t.Helper()
+	encoded, err := json.Marshal(entry)
+	require.NoError(t, err)
+	data, err := json.Marshal(line{Entry: encoded, Hash: hashEntry(encoded)})
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The methods the callers authenticate with, prefixing their principals
+const (
+	MethodSecret = "secret"
+	MethodKey    = "key"
+	MethodJWT    = "jwt"
+	MethodCert   = "cert"
+)
+
+// SharedSecretPrincipal is the principal of all the callers presenting the shared secret, which cannot be told apart
+const SharedSecretPrincipal = MethodSecret + ":shared"
+
+// AdminRole is the role making the callers it is granted to by the authorization policy admins
+const AdminRole = "admin"
+
+// NewPrincipal returns the principal of a caller authenticated with the given method under the given name
+func NewPrincipal(method, name string) string {
+	return method + ":" + name
+}
+
+// Identity describes the caller of a request, as established by the authentication interceptor
+type Identity struct {
+	// Subject is the name the caller is known by, which owns its chats, inbox and uploaded files
+	// It is its principal, except for the callers presenting the shared secret, which name themselves
+	// within its namespace (secret:<name>): they cannot claim the subject of the callers of other methods,
+	// but must not be trusted to tell apart the callers of the shared secret
+	Subject string
+	// Principal names the credential the caller authenticated with, prefixed by its method
+	// (key:<name>, jwt:<subject>, cert:<subject>, or SharedSecretPrincipal), which no other caller can claim
+	// The callers are authorized, limited and audited by it
+	Principal string
+	// Admin is set when the caller presented the admin token, allowing it to publish announcements
+	// and to manage the files of every owner (as does the AdminRole)
+	Admin bool
+	// Claims are the verified claims of the caller's JWT bearer token, if it presented one
+	Claims jwt.MapClaims
+	// Roles are the roles granted to the caller by the authorization policy, if one is enforced
+	Roles []string
+}
+
+type identityKey struct{}
+
+// NewContext returns a copy of the context carrying the given caller identity
+func NewContext(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the caller identity stored in the context, if any
+func FromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
+
+// SubjectFromContext returns the subject of the caller identity stored in the context,
+// or an empty string if the request carries no identity
+func SubjectFromContext(ctx context.Context) string {
+	if identity, ok := FromContext(ctx); ok {
+		return identity.Subject
+	}
+	return ""
+}
+
+// PrincipalFromContext returns the principal of the caller identity stored in the context,
+// or an empty string if the request carries no identity
+func PrincipalFromContext(ctx context.Context) string {
+	if identity, ok := FromContext(ctx); ok {
+		return identity.Principal
+	}
+	return ""
+}
+
+// IsAdmin reports whether the caller identity stored in the context is an admin,
+// having presented the admin token or been granted the AdminRole
+func IsAdmin(ctx context.Context) bool {
+	identity, ok := FromContext(ctx)
+	return ok && (identity.Admin || slices.Contains(identity.Roles, AdminRole))
+}
+
+// ClaimsFromContext returns the verified JWT claims of the caller identity stored in the context, if any
+func ClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	identity, ok := FromContext(ctx)
+	if !ok || identity.Claims == nil {
+		return nil, false
+	}
+	return identity.Claims, true
+}
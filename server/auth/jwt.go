@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrNoSubject is returned for tokens that do not name their subject
+var ErrNoSubject = errors.New("token has no subject")
+
+// JWTConfig configures the verification of JWT bearer tokens
+type JWTConfig struct {
+	// HMACSecret verifies HS256 tokens (HS256 is refused if empty)
+	HMACSecret []byte
+	// PublicKeysFile holds PEM-encoded RSA or P-256 ECDSA public keys (or certificates) verifying RS256 and ES256 tokens
+	PublicKeysFile string
+	// JWKSFile holds a JSON Web Key Set verifying RS256 and ES256 tokens, matched on their key ID
+	JWKSFile string
+	// Issuer and Audience are required in the tokens, when set
+	Issuer   string
+	Audience string
+	// ClockSkew is the leeway allowed when checking the exp and nbf claims
+	ClockSkew time.Duration
+}
+
+// Enabled reports whether any key is configured to verify tokens
+func (c JWTConfig) Enabled() bool {
+	return len(c.HMACSecret) > 0 || c.PublicKeysFile != "" || c.JWKSFile != ""
+}
+
+// publicKey is a key verifying RS256 or ES256 tokens, along with its key ID (empty for PEM keys)
+type publicKey struct {
+	id  string
+	key any
+}
+
+// Verifier verifies JWT bearer tokens, signed with HS256, RS256 or ES256
+type Verifier struct {
+	hmacSecret []byte
+	publicKeys []publicKey
+	parser     *jwt.Parser
+}
+
+// NewVerifier loads the keys of the configuration, only accepting the algorithms a key is configured for
+func NewVerifier(config JWTConfig) (*Verifier, error) {
+	v := &Verifier{hmacSecret: config.HMACSecret}
+
+	if config.PublicKeysFile != "" {
+		keys, err := loadPEMKeys(config.PublicKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		v.publicKeys = append(v.publicKeys, keys...)
+	}
+	if config.JWKSFile != "" {
+		keys, err := loadJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.publicKeys = append(v.publicKeys, keys...)
+	}
+
+	// Accepting only the algorithms of the configured keys prevents tokens from picking another one
+	var methods []string
+	if len(v.hmacSecret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if v.hasKey(isRSAKey) {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if v.hasKey(isECDSAKey) {
+		methods = append(methods, jwt.SigningMethodES256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no key configured to verify tokens")
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithLeeway(config.ClockSkew),
+		jwt.WithExpirationRequired(),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+	v.parser = jwt.NewParser(options...)
+
+	return v, nil
+}
+
+// Verify checks the signature and the claims of a token, returning its claims
+func (v *Verifier) Verify(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.keyFunc); err != nil {
+		return nil, err
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil {
+		return nil, err
+	}
+	if subject == "" {
+		return nil, ErrNoSubject
+	}
+	return claims, nil
+}
+
+// keyFunc returns the keys which may have signed the token, given its algorithm and key ID
+func (v *Verifier) keyFunc(token *jwt.Token) (any, error) {
+	var matches func(key any) bool
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return v.hmacSecret, nil
+	case *jwt.SigningMethodRSA:
+		matches = isRSAKey
+	case *jwt.SigningMethodECDSA:
+		matches = isECDSAKey
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+	}
+
+	// Keys without ID (from PEM files) are tried for every token
+	id, _ := token.Header["kid"].(string)
+	var keys jwt.VerificationKeySet
+	for _, key := range v.publicKeys {
+		if matches(key.key) && (key.id == "" || key.id == id) {
+			keys.Keys = append(keys.Keys, key.key)
+		}
+	}
+	if len(keys.Keys) == 0 {
+		return nil, fmt.Errorf("no key matching the token")
+	}
+	return keys, nil
+}
+
+// hasKey reports whether one of the public keys matches
+func (v *Verifier) hasKey(matches func(key any) bool) bool {
+	for _, key := range v.publicKeys {
+		if matches(key.key) {
+			return true
+		}
+	}
+	return false
+}
+
+func isRSAKey(key any) bool {
+	_, ok := key.(*rsa.PublicKey)
+	return ok
+}
+
+func isECDSAKey(key any) bool {
+	_, ok := key.(*ecdsa.PublicKey)
+	return ok
+}
+
+// loadPEMKeys loads the public keys, or the keys of the certificates, of a PEM file
+func loadPEMKeys(path string) ([]publicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []publicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key any
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in %s: %w", block.Type, path, err)
+		}
+		if ecdsaKey, ok := key.(*ecdsa.PublicKey); ok && ecdsaKey.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ECDSA curve in %s: %s", path, ecdsaKey.Curve.Params().Name)
+		}
+		if !isRSAKey(key) && !isECDSAKey(key) {
+			return nil, fmt.Errorf("unsupported %T public key in %s", key, path)
+		}
+		keys = append(keys, publicKey{key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public key in %s", path)
+	}
+	return keys, nil
+}
+
+// jsonWebKey is a key of a JSON Web Key Set (RFC 7517), limited to the fields of RSA and EC public keys
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// loadJWKS loads the signature keys of a JSON Web Key Set file, skipping the ones of other types
+func loadJWKS(path string) ([]publicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS in %s: %w", path, err)
+	}
+
+	var keys []publicKey
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		var key any
+		switch {
+		case jwk.Kty == "RSA":
+			key, err = jwk.rsaKey()
+		case jwk.Kty == "EC" && jwk.Crv == "P-256":
+			key, err = jwk.ecdsaKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in %s: %w", jwk.Kid, path, err)
+		}
+		keys = append(keys, publicKey{id: jwk.Kid, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA or P-256 signature key in %s", path)
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+		return nil, fmt.Errorf("invalid RSA modulus or exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func (jwk jsonWebKey) ecdsaKey() (*ecdsa.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the point is on the curve, through its uncompressed encoding
+	if len(x) != 32 || len(y) != 32 {
+		return nil, fmt.Errorf("invalid P-256 coordinates")
+	}
+	if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}, nil
+}
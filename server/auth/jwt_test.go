@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestKeys writes the RSA key as a PEM file and the ECDSA key as a JWKS file, returning their paths
+func writeTestKeys(t *testing.T, rsaKey *rsa.PrivateKey, ecdsaKey *ecdsa.PrivateKey) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	assert.NoError(t, err)
+	pemPath := filepath.Join(dir, "keys.pem")
+	assert.NoError(t, os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	jwks, err := json.Marshal(map[string]any{
+		"keys": []map[string]string{
+			// Encryption keys are skipped
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+			{
+				"kty": "EC",
+				"kid": "ec-1",
+				"use": "sig",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(ecdsaKey.X.FillBytes(make([]byte, 32))),
+				"y":   base64.RawURLEncoding.EncodeToString(ecdsaKey.Y.FillBytes(make([]byte, 32))),
+			},
+		},
+	})
+	assert.NoError(t, err)
+	jwksPath := filepath.Join(dir, "keys.jwks")
+	assert.NoError(t, os.WriteFile(jwksPath, jwks, 0o600))
+
+	return pemPath, jwksPath
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	pemPath, jwksPath := writeTestKeys(t, rsaKey, ecdsaKey)
+
+	verifier, err := NewVerifier(JWTConfig{
+		HMACSecret:     []byte("hmac-secret"),
+		PublicKeysFile: pemPath,
+		JWKSFile:       jwksPath,
+		Issuer:         "https://issuer.example",
+		Audience:       "go-grpc",
+		ClockSkew:      time.Minute,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	now := time.Now()
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":  "alice",
+			"iss":  "https://issuer.example",
+			"aud":  "go-grpc",
+			"exp":  now.Add(time.Hour).Unix(),
+			"role": "admin",
+		}
+	}
+	sign := func(method jwt.SigningMethod, kid string, key any, change func(jwt.MapClaims)) string {
+		claims := validClaims()
+		if change != nil {
+			change(claims)
+		}
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "HS256",
+			token: sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), nil),
+		},
+		{
+			name:  "RS256 from PEM",
+			token: sign(jwt.SigningMethodRS256, "any", rsaKey, nil),
+		},
+		{
+			name:  "ES256 from JWKS",
+			token: sign(jwt.SigningMethodES256, "ec-1", ecdsaKey, nil),
+		},
+		{
+			name:  "Expired within clock skew",
+			token: sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), func(c jwt.MapClaims) { c["exp"] = now.Add(-30 * time.Second).Unix() }),
+		},
+		{
+			name:    "Expired",
+			token:   sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Hour).Unix() }),
+			wantErr: true,
+		},
+		{
+			name:    "Not valid yet",
+			token:   sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), func(c jwt.MapClaims) { c["nbf"] = now.Add(time.Hour).Unix() }),
+			wantErr: true,
+		},
+		{
+			name:    "Without expiration",
+			token:   sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), func(c jwt.MapClaims) { delete(c, "exp") }),
+			wantErr: true,
+		},
+		{
+			name:    "Wrong issuer",
+			token:   sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), func(c jwt.MapClaims) { c["iss"] = "https://other.example" }),
+			wantErr: true,
+		},
+		{
+			name:    "Wrong audience",
+			token:   sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), func(c jwt.MapClaims) { c["aud"] = "other" }),
+			wantErr: true,
+		},
+		{
+			name:    "Without subject",
+			token:   sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), func(c jwt.MapClaims) { delete(c, "sub") }),
+			wantErr: true,
+		},
+		{
+			name:    "Wrong HMAC secret",
+			token:   sign(jwt.SigningMethodHS256, "", []byte("other-secret"), nil),
+			wantErr: true,
+		},
+		{
+			name:    "Unknown key",
+			token:   sign(jwt.SigningMethodES256, "ec-1", otherKey, nil),
+			wantErr: true,
+		},
+		{
+			name:    "Unknown key ID",
+			token:   sign(jwt.SigningMethodES256, "ec-2", ecdsaKey, nil),
+			wantErr: true,
+		},
+		{
+			name:    "Unexpected algorithm",
+			token:   sign(jwt.SigningMethodHS384, "", []byte("hmac-secret"), nil),
+			wantErr: true,
+		},
+		{
+			name:    "Unsigned",
+			token:   sign(jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, nil),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Verify(tt.token)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			subject, _ := claims.GetSubject()
+			assert.Equal(t, "alice", subject)
+			assert.Equal(t, "admin", claims["role"])
+		})
+	}
+}
+
+func TestNewVerifier_Algorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	pemPath, _ := writeTestKeys(t, rsaKey, ecdsaKey)
+
+	// Without an HMAC secret, HS256 tokens are refused even when signed with the public key
+	verifier, err := NewVerifier(JWTConfig{PublicKeysFile: pemPath})
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	assert.NoError(t, err)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	assert.NoError(t, err)
+	_, err = verifier.Verify(token)
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+
+	_, err = NewVerifier(JWTConfig{})
+	assert.Error(t, err)
+	_, err = NewVerifier(JWTConfig{JWKSFile: filepath.Join(t.TempDir(), "missing.jwks")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUnknownKey  = errors.New("unknown API key")
+	ErrKeyExpired  = errors.New("API key expired")
+	ErrKeyDisabled = errors.New("API key disabled")
+)
+
+// APIKey is an entry of the keyring file, storing a salted hash of the key rather than the key itself
+type APIKey struct {
+	// Name identifies the key, and is the subject of the callers presenting it
+	Name string `json:"name"`
+	// Salt and Hash are hex-encoded, the hash being the SHA-256 digest of the salt followed by the key
+	Salt string `json:"salt"`
+	Hash string `json:"hash"`
+	// ExpiresAt is when the key stops being accepted, if set
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Disabled revokes the key, while keeping it listed
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// NewAPIKey generates a random key, returning it along with its keyring entry
+func NewAPIKey(name string, expiresAt *time.Time) (string, APIKey, error) {
+	secret := make([]byte, 32)
+	salt := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKey{}, err
+	}
+	if _, err := rand.Read(salt); err != nil {
+		return "", APIKey{}, err
+	}
+
+	key := base64.RawURLEncoding.EncodeToString(secret)
+	return key, APIKey{
+		Name:      name,
+		Salt:      hex.EncodeToString(salt),
+		Hash:      hex.EncodeToString(hashAPIKey(salt, key)),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// keyringFile is the content of the keyring file
+type keyringFile struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// loadedKey is a keyring entry, with its salt and hash decoded
+type loadedKey struct {
+	APIKey
+	salt []byte
+	hash []byte
+}
+
+// Keyring authenticates the callers with named API keys, loaded from a file reloaded when it changes
+type Keyring struct {
+	path string
+
+	mutex   sync.RWMutex
+	keys    []loadedKey
+	modTime time.Time
+	size    int64
+
+	stop chan struct{}
+}
+
+// OpenKeyring loads the keyring from a file, checking it for changes at the given interval (never if zero)
+func OpenKeyring(path string, reloadInterval time.Duration) (*Keyring, error) {
+	k := &Keyring{
+		path: path,
+		stop: make(chan struct{}),
+	}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+
+	if reloadInterval > 0 {
+		go k.watch(reloadInterval)
+	}
+	return k, nil
+}
+
+// Close stops checking the keyring file for changes
+func (k *Keyring) Close() {
+	close(k.stop)
+}
+
+// Reload loads the keyring file again, keeping the current keys if it is invalid
+func (k *Keyring) Reload() error {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+	keys, err := parseKeyring(data)
+	if err != nil {
+		return fmt.Errorf("invalid keyring %s: %w", k.path, err)
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.keys = keys
+	k.modTime = info.ModTime()
+	k.size = info.Size()
+	return nil
+}
+
+// Authenticate returns the name of the given key, if it is in the keyring and currently valid
+// Every entry is checked in constant time, so the time taken tells nothing about the keys
+func (k *Keyring) Authenticate(key string, now time.Time) (string, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	var match *loadedKey
+	for i := range k.keys {
+		entry := &k.keys[i]
+		if subtle.ConstantTimeCompare(hashAPIKey(entry.salt, key), entry.hash) == 1 && match == nil {
+			match = entry
+		}
+	}
+
+	switch {
+	case match == nil:
+		return "", ErrUnknownKey
+	case match.Disabled:
+		return "", ErrKeyDisabled
+	case match.ExpiresAt != nil && !now.Before(*match.ExpiresAt):
+		return "", ErrKeyExpired
+	default:
+		return match.Name, nil
+	}
+}
+
+// watch reloads the keyring whenever its file changes, until the keyring is closed
+func (k *Keyring) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.stop:
+			return
+		case <-ticker.C:
+			if !k.changed() {
+				continue
+			}
+			if err := k.Reload(); err != nil {
+				zap.L().Error("Error reloading the API keys", zap.Error(err))
+				continue
+			}
+			zap.L().Info("Reloaded the API keys", zap.String("path", k.path))
+		}
+	}
+}
+
+// changed reports whether the keyring file changed since it was last loaded
+func (k *Keyring) changed() bool {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		// Reloading reports why the file cannot be read
+		return true
+	}
+
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	return !info.ModTime().Equal(k.modTime) || info.Size() != k.size
+}
+
+// parseKeyring decodes and checks the entries of a keyring file
+func parseKeyring(data []byte) ([]loadedKey, error) {
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	keys := make([]loadedKey, 0, len(file.Keys))
+	names := make(map[string]struct{}, len(file.Keys))
+	for _, entry := range file.Keys {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("key without name")
+		}
+		if _, ok := names[entry.Name]; ok {
+			return nil, fmt.Errorf("duplicate key name: %s", entry.Name)
+		}
+		names[entry.Name] = struct{}{}
+
+		salt, err := hex.DecodeString(entry.Salt)
+		if err != nil || len(salt) == 0 {
+			return nil, fmt.Errorf("invalid salt for key %s", entry.Name)
+		}
+		hash, err := hex.DecodeString(entry.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid hash for key %s", entry.Name)
+		}
+		keys = append(keys, loadedKey{APIKey: entry, salt: salt, hash: hash})
+	}
+	return keys, nil
+}
+
+// hashAPIKey returns the SHA-256 digest of the salt followed by the key
+func hashAPIKey(salt []byte, key string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(key))
+	return h.Sum(nil)
+}
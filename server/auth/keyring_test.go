@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestKeyring writes the keyring file with the given entries
+func writeTestKeyring(t *testing.T, path string, keys ...APIKey) {
+	t.Helper()
+
+	data, err := json.Marshal(keyringFile{Keys: keys})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func TestKeyring_Authenticate(t *testing.T) {
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	expiredAt := now.Add(-time.Hour)
+	ciKey, ci, err := NewAPIKey("ci", &expiresAt)
+	assert.NoError(t, err)
+	oldKey, old, err := NewAPIKey("old", &expiredAt)
+	assert.NoError(t, err)
+	revokedKey, revoked, err := NewAPIKey("revoked", nil)
+	assert.NoError(t, err)
+	revoked.Disabled = true
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeTestKeyring(t, path, ci, old, revoked)
+	keyring, err := OpenKeyring(path, 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer keyring.Close()
+
+	tests := []struct {
+		name     string
+		key      string
+		wantName string
+		wantErr  error
+	}{
+		{name: "Valid key", key: ciKey, wantName: "ci"},
+		{name: "Expired key", key: oldKey, wantErr: ErrKeyExpired},
+		{name: "Disabled key", key: revokedKey, wantErr: ErrKeyDisabled},
+		{name: "Unknown key", key: "not-a-key", wantErr: ErrUnknownKey},
+		{name: "Empty key", key: "", wantErr: ErrUnknownKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := keyring.Authenticate(tt.key, now)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantName, name)
+		})
+	}
+
+	// Past its expiry, the valid key is refused as well
+	_, err = keyring.Authenticate(ciKey, expiresAt)
+	assert.ErrorIs(t, err, ErrKeyExpired)
+}
+
+func TestKeyring_Reload(t *testing.T) {
+	aliceKey, alice, err := NewAPIKey("alice", nil)
+	assert.NoError(t, err)
+	bobKey, bob, err := NewAPIKey("bob", nil)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeTestKeyring(t, path, alice)
+	keyring, err := OpenKeyring(path, 10*time.Millisecond)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer keyring.Close()
+
+	// Changes of the file are picked up without reopening the keyring
+	writeTestKeyring(t, path, bob)
+	assert.Eventually(t, func() bool {
+		_, err := keyring.Authenticate(bobKey, time.Now())
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	_, err = keyring.Authenticate(aliceKey, time.Now())
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// An invalid file is refused, keeping the current keys
+	assert.NoError(t, os.WriteFile(path, []byte(`{"keys": [{"name": "bob"}, {"name": "bob"}]}`), 0o600))
+	assert.Error(t, keyring.Reload())
+	name, err := keyring.Authenticate(bobKey, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", name)
+
+	_, err = OpenKeyring(filepath.Join(t.TempDir(), "missing.json"), 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Policy grants roles to the callers, and procedures to the roles; anything not granted is denied
+type Policy struct {
+	// Roles maps each role to the procedures it allows, either by full name (/crud.v1.CrudService/Delete),
+	// by service (/crud.v1.CrudService/*) or all of them (*)
+	Roles map[string][]string `json:"roles"`
+	// Subjects maps the principals of the callers (key:<name>, jwt:<subject>, cert:<subject> or secret:shared)
+	// to their roles, the method prefix keeping a caller from claiming the roles of another method's caller
+	Subjects map[string][]string `json:"subjects"`
+	// RoleClaim names the JWT claim listing the roles of the caller, as a string or a list of strings, if any
+	RoleClaim string `json:"role_claim"`
+	// DefaultRoles are granted to every authenticated caller
+	DefaultRoles []string `json:"default_roles"`
+}
+
+// LoadPolicy loads a policy from a JSON file, checking every role it grants is defined
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+
+	for role, procedures := range policy.Roles {
+		for _, procedure := range procedures {
+			if procedure != "*" && !strings.HasPrefix(procedure, "/") {
+				return nil, fmt.Errorf("invalid procedure %q of role %s in %s", procedure, role, path)
+			}
+		}
+	}
+	granted := append([]string(nil), policy.DefaultRoles...)
+	for principal, roles := range policy.Subjects {
+		if !validPrincipal(principal) {
+			return nil, fmt.Errorf("invalid subject %q in %s, expecting key:<name>, jwt:<subject>, cert:<subject> or %s", principal, path, SharedSecretPrincipal)
+		}
+		granted = append(granted, roles...)
+	}
+	for _, role := range granted {
+		if _, ok := policy.Roles[role]; !ok {
+			return nil, fmt.Errorf("undefined role %s in %s", role, path)
+		}
+	}
+
+	return &policy, nil
+}
+
+// validPrincipal reports whether a subject of the policy is a principal, prefixed by its authentication method
+func validPrincipal(principal string) bool {
+	if principal == SharedSecretPrincipal {
+		return true
+	}
+	method, name, ok := strings.Cut(principal, ":")
+	return ok && name != "" && (method == MethodKey || method == MethodJWT || method == MethodCert)
+}
+
+// RolesOf returns the roles of a caller, sorted
+// Roles named by a JWT claim but not defined by the policy are kept, even though they allow nothing
+func (p *Policy) RolesOf(identity *Identity) []string {
+	set := make(map[string]struct{})
+	add := func(roles ...string) {
+		for _, role := range roles {
+			set[role] = struct{}{}
+		}
+	}
+
+	add(p.DefaultRoles...)
+	add(p.Subjects[identity.Principal]...)
+	if p.RoleClaim != "" && identity.Claims != nil {
+		switch claim := identity.Claims[p.RoleClaim].(type) {
+		case string:
+			add(strings.Fields(claim)...)
+		case []any:
+			for _, role := range claim {
+				if role, ok := role.(string); ok {
+					add(role)
+				}
+			}
+		}
+	}
+
+	roles := make([]string, 0, len(set))
+	for role := range set {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Allows reports whether one of the roles allows the procedure
+func (p *Policy) Allows(roles []string, procedure string) bool {
+	for _, role := range roles {
+		for _, allowed := range p.Roles[role] {
+			if matchProcedure(allowed, procedure) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchProcedure reports whether a procedure matches a pattern of the policy
+func matchProcedure(pattern, procedure string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(procedure, strings.TrimSuffix(pattern, "*"))
+	default:
+		return pattern == procedure
+	}
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicy = `{
+	"roles": {
+		"reader": ["/crud.v1.CrudService/Read", "/files.v1.FileService/*"],
+		"writer": ["/crud.v1.CrudService/Create", "/crud.v1.CrudService/Update"],
+		"admin": ["*"]
+	},
+	"subjects": {
+		"key:ci": ["writer"],
+		"cert:ops": ["admin"]
+	},
+	"role_claim": "roles",
+	"default_roles": ["reader"]
+}`
+
+// writeTestPolicy writes the policy file, returning its path
+func writeTestPolicy(t *testing.T, policy string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	assert.NoError(t, os.WriteFile(path, []byte(policy), 0o600))
+	return path
+}
+
+func TestPolicy_Allows(t *testing.T) {
+	policy, err := LoadPolicy(writeTestPolicy(t, testPolicy))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	tests := []struct {
+		name      string
+		identity  *Identity
+		procedure string
+		wantRoles []string
+		want      bool
+	}{
+		{
+			name:      "Default role",
+			identity:  &Identity{Subject: "alice", Principal: "key:alice"},
+			procedure: "/crud.v1.CrudService/Read",
+			wantRoles: []string{"reader"},
+			want:      true,
+		},
+		{
+			name:      "Service wildcard",
+			identity:  &Identity{Subject: "alice", Principal: "key:alice"},
+			procedure: "/files.v1.FileService/DeleteFile",
+			wantRoles: []string{"reader"},
+			want:      true,
+		},
+		{
+			name:      "Denied by default",
+			identity:  &Identity{Subject: "alice", Principal: "key:alice"},
+			procedure: "/crud.v1.CrudService/Delete",
+			wantRoles: []string{"reader"},
+			want:      false,
+		},
+		{
+			name:      "Subject role",
+			identity:  &Identity{Subject: "ci", Principal: "key:ci"},
+			procedure: "/crud.v1.CrudService/Create",
+			wantRoles: []string{"reader", "writer"},
+			want:      true,
+		},
+		{
+			name:      "Subject role without the procedure",
+			identity:  &Identity{Subject: "ci", Principal: "key:ci"},
+			procedure: "/crud.v1.CrudService/Delete",
+			wantRoles: []string{"reader", "writer"},
+			want:      false,
+		},
+		{
+			name:      "Wildcard role",
+			identity:  &Identity{Subject: "ops", Principal: "cert:ops"},
+			procedure: "/crud.v1.CrudService/Delete",
+			wantRoles: []string{"admin", "reader"},
+			want:      true,
+		},
+		{
+			name:      "Claim roles",
+			identity:  &Identity{Subject: "bob", Principal: "jwt:bob", Claims: jwt.MapClaims{"roles": []any{"admin", "unknown"}}},
+			procedure: "/crud.v1.CrudService/Delete",
+			wantRoles: []string{"admin", "reader", "unknown"},
+			want:      true,
+		},
+		{
+			name:      "Claim roles as a string",
+			identity:  &Identity{Subject: "bob", Principal: "jwt:bob", Claims: jwt.MapClaims{"roles": "writer"}},
+			procedure: "/crud.v1.CrudService/Update",
+			wantRoles: []string{"reader", "writer"},
+			want:      true,
+		},
+		{
+			name:      "Subject of another method",
+			identity:  &Identity{Subject: "ci", Principal: "jwt:ci"},
+			procedure: "/crud.v1.CrudService/Create",
+			wantRoles: []string{"reader"},
+			want:      false,
+		},
+		{
+			name:      "Shared secret callers naming themselves",
+			identity:  &Identity{Subject: "ops", Principal: SharedSecretPrincipal},
+			procedure: "/crud.v1.CrudService/Delete",
+			wantRoles: []string{"reader"},
+			want:      false,
+		},
+		{
+			name:      "Service prefix is not a wildcard",
+			identity:  &Identity{Subject: "alice", Principal: "key:alice"},
+			procedure: "/files.v1.FileServiceV2/DeleteFile",
+			wantRoles: []string{"reader"},
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roles := policy.RolesOf(tt.identity)
+			assert.Equal(t, tt.wantRoles, roles)
+			assert.Equal(t, tt.want, policy.Allows(roles, tt.procedure))
+		})
+	}
+}
+
+func TestLoadPolicy_Invalid(t *testing.T) {
+	_, err := LoadPolicy(writeTestPolicy(t, `{"roles": {"reader": ["*"]}, "subjects": {"key:ci": ["writer"]}}`))
+	assert.ErrorContains(t, err, "undefined role writer")
+	_, err = LoadPolicy(writeTestPolicy(t, `{"roles": {"reader": ["crud.v1.CrudService/Read"]}}`))
+	assert.ErrorContains(t, err, "invalid procedure")
+	_, err = LoadPolicy(writeTestPolicy(t, `{"roles": {"reader": ["*"]}, "subjects": {"ci": ["reader"]}}`))
+	assert.ErrorContains(t, err, `invalid subject "ci"`)
+	_, err = LoadPolicy(writeTestPolicy(t, `{"roles": {"reader": ["*"]}, "subjects": {"secret:ci": ["reader"]}}`))
+	assert.ErrorContains(t, err, `invalid subject "secret:ci"`)
+	_, err = LoadPolicy(writeTestPolicy(t, `not json`))
+	assert.Error(t, err)
+}
+
+func TestIsAdmin(t *testing.T) {
+	assert.False(t, IsAdmin(context.Background()))
+	assert.False(t, IsAdmin(NewContext(context.Background(), &Identity{Principal: "key:ci", Roles: []string{"reader"}})))
+	// The admins either present the admin token or are granted the admin role by the policy
+	assert.True(t, IsAdmin(NewContext(context.Background(), &Identity{Principal: "key:ci", Admin: true})))
+	assert.True(t, IsAdmin(NewContext(context.Background(), &Identity{Principal: "key:ops", Roles: []string{AdminRole, "reader"}})))
+}
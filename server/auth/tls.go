@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TLSConfig configures the TLS listener of the server
+type TLSConfig struct {
+	// CertFile and KeyFile hold the PEM-encoded certificate chain and private key of the server
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds the PEM-encoded CAs verifying the client certificates, enabling mutual TLS when set
+	ClientCAFile string
+	// ClientCertOptional lets the clients without certificate in, to authenticate with a token instead
+	ClientCertOptional bool
+}
+
+// fileStamp identifies a version of a file, to tell when it changes
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Certificates serves the certificate of the server and the CAs of the clients, reloaded when their files change
+type Certificates struct {
+	config TLSConfig
+
+	mutex     sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	stamps    map[string]fileStamp
+
+	stop chan struct{}
+}
+
+// OpenCertificates loads the certificates, checking their files for changes at the given interval (never if zero)
+func OpenCertificates(config TLSConfig, reloadInterval time.Duration) (*Certificates, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, fmt.Errorf("both a certificate and a key file are required")
+	}
+	c := &Certificates{
+		config: config,
+		stop:   make(chan struct{}),
+	}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	if reloadInterval > 0 {
+		go c.watch(reloadInterval)
+	}
+	return c, nil
+}
+
+// Close stops checking the certificate files for changes
+func (c *Certificates) Close() {
+	close(c.stop)
+}
+
+// Reload loads the certificate files again, keeping the current certificates if they are invalid
+// New connections use the reloaded certificates, the established ones being left untouched
+func (c *Certificates) Reload() error {
+	stamps := make(map[string]fileStamp)
+	for _, path := range c.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		stamps[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.config.CertFile, c.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("invalid certificate %s: %w", c.config.CertFile, err)
+	}
+	var clientCAs *x509.CertPool
+	if c.config.ClientCAFile != "" {
+		data, err := os.ReadFile(c.config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificate in %s", c.config.ClientCAFile)
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.cert = &cert
+	c.clientCAs = clientCAs
+	c.stamps = stamps
+	return nil
+}
+
+// ServerConfig returns the TLS configuration of the server, picking the current certificates for each connection
+// Clients must present a certificate signed by one of the client CAs, if any, unless it is optional
+func (c *Certificates) ServerConfig() *tls.Config {
+	nextProtos := []string{"h2", "http/1.1"}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mutex.RLock()
+			defer c.mutex.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   nextProtos,
+				Certificates: []tls.Certificate{*c.cert},
+			}
+			if c.clientCAs != nil {
+				config.ClientCAs = c.clientCAs
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				if c.config.ClientCertOptional {
+					config.ClientAuth = tls.VerifyClientCertIfGiven
+				}
+			}
+			return config, nil
+		},
+	}
+}
+
+// files returns the paths of the certificate files
+func (c *Certificates) files() []string {
+	files := []string{c.config.CertFile, c.config.KeyFile}
+	if c.config.ClientCAFile != "" {
+		files = append(files, c.config.ClientCAFile)
+	}
+	return files
+}
+
+// watch reloads the certificates whenever one of their files changes, until they are closed
+func (c *Certificates) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if !c.changed() {
+				continue
+			}
+			if err := c.Reload(); err != nil {
+				zap.L().Error("Error reloading the TLS certificates", zap.Error(err))
+				continue
+			}
+			zap.L().Info("Reloaded the TLS certificates", zap.String("path", c.config.CertFile))
+		}
+	}
+}
+
+// changed reports whether one of the certificate files changed since they were last loaded
+func (c *Certificates) changed() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for path, stamp := range c.stamps {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Reloading reports why the file cannot be read
+			return true
+		}
+		if !info.ModTime().Equal(stamp.modTime) || info.Size() != stamp.size {
+			return true
+		}
+	}
+	return false
+}
+
+// CertificateSubject returns the name a client certificate identifies its holder by:
+// its common name, or else its first DNS, URI or email subject alternative name
+func CertificateSubject(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	default:
+		return ""
+	}
+}
+
+type peerCertificateKey struct{}
+
+// PeerCertificateHandler stores the verified client certificate of the requests, if any, in their context
+// The connect handlers being below it, the interceptors find it with PeerCertificateFromContext
+func PeerCertificateHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			ctx := context.WithValue(r.Context(), peerCertificateKey{}, r.TLS.VerifiedChains[0][0])
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PeerCertificateFromContext returns the verified client certificate stored in the context, if any
+func PeerCertificateFromContext(ctx context.Context) (*x509.Certificate, bool) {
+	cert, ok := ctx.Value(peerCertificateKey{}).(*x509.Certificate)
+	return cert, ok
+}
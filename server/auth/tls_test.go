@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCA issues the certificates of the tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue writes a certificate signed by the CA, and its key, returning their paths
+func (ca *testCA) issue(t *testing.T, dir string, template *x509.Certificate) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.NoError(t, err)
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	name := fmt.Sprintf("%d", serial)
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	assert.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certPath, keyPath
+}
+
+func serverTemplate(commonName string) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+}
+
+func clientTemplate(commonName string) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+}
+
+func TestCertificateSubject(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ci")
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want string
+	}{
+		{
+			name: "Common name",
+			cert: &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}, DNSNames: []string{"alice.example.org"}},
+			want: "alice",
+		},
+		{
+			name: "DNS name",
+			cert: &x509.Certificate{DNSNames: []string{"worker.example.org"}, URIs: []*url.URL{spiffe}},
+			want: "worker.example.org",
+		},
+		{
+			name: "URI",
+			cert: &x509.Certificate{URIs: []*url.URL{spiffe}},
+			want: "spiffe://example.org/ci",
+		},
+		{
+			name: "Email",
+			cert: &x509.Certificate{EmailAddresses: []string{"bob@example.org"}},
+			want: "bob@example.org",
+		},
+		{
+			name: "Anonymous",
+			cert: &x509.Certificate{},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CertificateSubject(tt.cert))
+		})
+	}
+}
+
+func TestCertificates_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	caPath := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, os.WriteFile(caPath, ca.pem, 0o600))
+	serverCert, serverKey := ca.issue(t, dir, serverTemplate("server-1"))
+	aliceCert, aliceKey := ca.issue(t, dir, clientTemplate("alice"))
+	strangerCert, strangerKey := otherCA.issue(t, dir, clientTemplate("stranger"))
+
+	certificates, err := OpenCertificates(TLSConfig{
+		CertFile:     serverCert,
+		KeyFile:      serverKey,
+		ClientCAFile: caPath,
+	}, 10*time.Millisecond)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer certificates.Close()
+
+	// Echo the subject of the client certificate found in the request context
+	server := httptest.NewUnstartedServer(PeerCertificateHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cert, ok := PeerCertificateFromContext(r.Context())
+		if !ok {
+			http.Error(w, "no client certificate", http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, CertificateSubject(cert))
+	})))
+	server.TLS = certificates.ServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	// get calls the server with the given client certificate, if any, returning the name of the server certificate
+	get := func(certPath, keyPath string) (string, string, error) {
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		config := &tls.Config{RootCAs: roots}
+		if certPath != "" {
+			cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+			assert.NoError(t, err)
+			config.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		defer client.CloseIdleConnections()
+
+		res, err := client.Get(server.URL)
+		if err != nil {
+			return "", "", err
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		return string(body), res.TLS.PeerCertificates[0].Subject.CommonName, err
+	}
+
+	subject, serverName, err := get(aliceCert, aliceKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", subject)
+	assert.Equal(t, "server-1", serverName)
+
+	// Clients without certificate, or with one signed by another CA, are refused during the handshake
+	_, _, err = get("", "")
+	assert.Error(t, err)
+	_, _, err = get(strangerCert, strangerKey)
+	assert.Error(t, err)
+
+	// A renewed server certificate is picked up by the new connections
+	renewedCert, renewedKey := ca.issue(t, dir, serverTemplate("server-2"))
+	assert.NoError(t, os.Rename(renewedCert, serverCert))
+	assert.NoError(t, os.Rename(renewedKey, serverKey))
+	assert.Eventually(t, func() bool {
+		_, serverName, err := get(aliceCert, aliceKey)
+		return err == nil && serverName == "server-2"
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// An invalid certificate file keeps the current certificate in use
+	assert.NoError(t, os.WriteFile(serverCert, []byte("not a certificate"), 0o600))
+	assert.Error(t, certificates.Reload())
+	_, serverName, err = get(aliceCert, aliceKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "server-2", serverName)
+}
+
+func TestCertificates_OptionalClientCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caPath := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, os.WriteFile(caPath, ca.pem, 0o600))
+	serverCert, serverKey := ca.issue(t, dir, serverTemplate("server"))
+
+	certificates, err := OpenCertificates(TLSConfig{
+		CertFile:           serverCert,
+		KeyFile:            serverKey,
+		ClientCAFile:       caPath,
+		ClientCertOptional: true,
+	}, 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer certificates.Close()
+
+	server := httptest.NewUnstartedServer(PeerCertificateHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := PeerCertificateFromContext(r.Context())
+		assert.False(t, ok)
+	})))
+	server.TLS = certificates.ServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	// Clients without certificate get in, without identity
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	res, err := client.Get(server.URL)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		_ = res.Body.Close()
+	}
+
+	_, err = OpenCertificates(TLSConfig{CertFile: serverCert}, 0)
+	assert.Error(t, err)
+}
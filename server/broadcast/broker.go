@@ -0,0 +1,209 @@
+package broadcast
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// DefaultRetention is the number of announcements retained per topic for late subscribers, when none is configured
+	DefaultRetention = 10
+	// DefaultQueueSize is the number of announcements queued for each subscriber, when none is configured
+	DefaultQueueSize = 64
+)
+
+var (
+	ErrNoTopic    = errors.New("at least one topic is required")
+	ErrEmptyTopic = errors.New("topic must not be empty")
+	ErrEmptyText  = errors.New("announcement must not be empty")
+)
+
+// Announcement is a message published to every subscriber of a topic
+type Announcement struct {
+	ID          string
+	Topic       string
+	Text        string
+	Publisher   string
+	PublishedAt time.Time
+}
+
+// Subscriber receives the announcements published to its topics, until it unsubscribes
+type Subscriber struct {
+	topics   []string
+	retained []Announcement
+	out      chan Announcement
+}
+
+// Retained returns the announcements retained on the subscriber's topics when it subscribed, oldest first
+func (s *Subscriber) Retained() []Announcement {
+	return s.retained
+}
+
+// Announcements returns the channel receiving the announcements published after the subscription,
+// closed once the subscriber unsubscribes
+func (s *Subscriber) Announcements() <-chan Announcement {
+	return s.out
+}
+
+// topic holds the retained announcements and the subscribers of a topic
+type topic struct {
+	retained    []Announcement
+	subscribers map[*Subscriber]struct{}
+}
+
+// Broker fans out the published announcements to the subscribers of their topic
+// Announcements are kept in memory only, as they are meant for the clients connected at the time
+type Broker struct {
+	mutex     sync.Mutex
+	topics    map[string]*topic
+	retention int
+	queueSize int
+	dropped   uint64
+}
+
+// NewBroker creates a broker retaining the given number of announcements per topic (DefaultRetention if zero)
+func NewBroker(retention int) *Broker {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &Broker{
+		topics:    make(map[string]*topic),
+		retention: retention,
+		queueSize: DefaultQueueSize,
+	}
+}
+
+// Subscribe registers a subscriber to the given topics, along with the announcements retained on them
+func (b *Broker) Subscribe(topics []string) (*Subscriber, error) {
+	if len(topics) == 0 {
+		return nil, ErrNoTopic
+	}
+
+	// Subscribing twice to the same topic would deliver its announcements twice
+	unique := make([]string, 0, len(topics))
+	seen := make(map[string]struct{}, len(topics))
+	for _, name := range topics {
+		if name == "" {
+			return nil, ErrEmptyTopic
+		}
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			unique = append(unique, name)
+		}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// Take the retained announcements under the same lock as the registration, so none is missed or received twice
+	s := &Subscriber{
+		topics: unique,
+		out:    make(chan Announcement, b.queueSize),
+	}
+	for _, name := range unique {
+		t := b.topic(name)
+		s.retained = append(s.retained, t.retained...)
+		t.subscribers[s] = struct{}{}
+	}
+	sortAnnouncements(s.retained)
+	return s, nil
+}
+
+// Unsubscribe unregisters a subscriber, closing its announcements
+func (b *Broker) Unsubscribe(s *Subscriber) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, name := range s.topics {
+		t, ok := b.topics[name]
+		if !ok {
+			continue
+		}
+		delete(t.subscribers, s)
+		if len(t.subscribers) == 0 && len(t.retained) == 0 {
+			delete(b.topics, name)
+		}
+	}
+	close(s.out)
+}
+
+// Publish retains an announcement on the topic and fans it out to its subscribers, returning how many there were
+func (b *Broker) Publish(publisher, name, text string) (Announcement, int, error) {
+	if name == "" {
+		return Announcement{}, 0, ErrEmptyTopic
+	}
+	if text == "" {
+		return Announcement{}, 0, ErrEmptyText
+	}
+
+	announcement := Announcement{
+		ID:          ksuid.New().String(),
+		Topic:       name,
+		Text:        text,
+		Publisher:   publisher,
+		PublishedAt: time.Now().UTC(),
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// Keep only the latest announcements for late subscribers
+	t := b.topic(name)
+	t.retained = append(t.retained, announcement)
+	if len(t.retained) > b.retention {
+		t.retained = append([]Announcement(nil), t.retained[len(t.retained)-b.retention:]...)
+	}
+
+	for s := range t.subscribers {
+		b.deliver(s, announcement)
+	}
+	return announcement, len(t.subscribers), nil
+}
+
+// Dropped returns the number of announcements dropped from the queues of slow subscribers
+func (b *Broker) Dropped() uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.dropped
+}
+
+// topic returns the topic of the given name, creating it if needed; the mutex must be held by the caller
+func (b *Broker) topic(name string) *topic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topic{subscribers: make(map[*Subscriber]struct{})}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// deliver queues an announcement for a subscriber, dropping its oldest one if its queue is full
+// Announcements are notices, so a slow subscriber rather misses an old one than holds back the publisher
+// The mutex must be held by the caller, as only the broker sends on the queues
+func (b *Broker) deliver(s *Subscriber, announcement Announcement) {
+	select {
+	case s.out <- announcement:
+		return
+	default:
+	}
+
+	// The subscriber may have received an announcement in the meantime, making room on its own
+	select {
+	case <-s.out:
+		b.dropped++
+	default:
+	}
+	s.out <- announcement
+}
+
+// sortAnnouncements sorts announcements of several topics by publication, oldest first
+func sortAnnouncements(announcements []Announcement) {
+	sort.SliceStable(announcements, func(i, j int) bool {
+		return announcements[i].PublishedAt.Before(announcements[j].PublishedAt)
+	})
+}
@@ -0,0 +1,69 @@
+package broadcast
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// announcementTexts returns the texts of the announcements
+func announcementTexts(announcements []Announcement) []string {
+	texts := make([]string, 0, len(announcements))
+	for _, announcement := range announcements {
+		texts = append(texts, announcement.Text)
+	}
+	return texts
+}
+
+func TestBroker_Retention(t *testing.T) {
+	broker := NewBroker(2)
+
+	// Only the latest announcements of each topic are retained
+	for _, text := range []string{"Notice 1", "Notice 2", "Notice 3"} {
+		_, subscribers, err := broker.Publish("ops", "maintenance", text)
+		assert.NoError(t, err)
+		assert.Zero(t, subscribers)
+	}
+	_, _, err := broker.Publish("ops", "releases", "Release 1")
+	assert.NoError(t, err)
+
+	// Late subscribers receive the retained announcements of all their topics, oldest first
+	subscriber, err := broker.Subscribe([]string{"releases", "maintenance", "releases"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Notice 2", "Notice 3", "Release 1"}, announcementTexts(subscriber.Retained()))
+
+	// Then the announcements published to their topics only, once each
+	_, subscribers, err := broker.Publish("ops", "releases", "Release 2")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, subscribers)
+	_, _, err = broker.Publish("ops", "other", "Other")
+	assert.NoError(t, err)
+	broker.Unsubscribe(subscriber)
+
+	var received []Announcement
+	for announcement := range subscriber.Announcements() {
+		received = append(received, announcement)
+	}
+	assert.Equal(t, []string{"Release 2"}, announcementTexts(received))
+
+	_, err = broker.Subscribe(nil)
+	assert.ErrorIs(t, err, ErrNoTopic)
+	_, err = broker.Subscribe([]string{""})
+	assert.ErrorIs(t, err, ErrEmptyTopic)
+	_, _, err = broker.Publish("ops", "maintenance", "")
+	assert.ErrorIs(t, err, ErrEmptyText)
+}
+
+func TestBroker_SlowSubscriber(t *testing.T) {
+	broker := NewBroker(0)
+	subscriber, err := broker.Subscribe([]string{"maintenance"})
+	assert.NoError(t, err)
+
+	// A subscriber not keeping up misses its oldest announcements, without holding back the publisher
+	for i := 0; i < DefaultQueueSize+3; i++ {
+		_, _, err := broker.Publish("ops", "maintenance", "Notice")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, uint64(3), broker.Dropped())
+	assert.Len(t, subscriber.Announcements(), DefaultQueueSize)
+}
@@ -0,0 +1,330 @@
+package chat
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/serbanmarti/go-grpc/server/storage"
+)
+
+const (
+	// DefaultHistoryLimit is the number of messages kept for each conversation, when none is configured
+	DefaultHistoryLimit = 1000
+	// DefaultHistoryPageSize is the number of messages returned by a history query, when none is requested
+	DefaultHistoryPageSize = 50
+	// MaxHistoryPageSize is the maximum number of messages returned by a history query
+	MaxHistoryPageSize = 1000
+
+	historyFileExt = ".jsonl"
+)
+
+var (
+	ErrNoConversation = errors.New("history must be requested for either a room or a peer")
+	ErrInvalidCursor  = errors.New("invalid history cursor")
+)
+
+// Conversation identifies either a room, or the direct messages between two users
+type Conversation struct {
+	Room  string
+	Peers [2]string
+}
+
+// RoomConversation returns the conversation of a room
+func RoomConversation(room string) Conversation {
+	return Conversation{Room: room}
+}
+
+// PeerConversation returns the conversation between two users, regardless of their order
+func PeerConversation(user, peer string) Conversation {
+	if peer < user {
+		user, peer = peer, user
+	}
+	return Conversation{Peers: [2]string{user, peer}}
+}
+
+// conversationOf returns the conversation a message belongs to
+func conversationOf(msg Message) Conversation {
+	if msg.Room != "" {
+		return RoomConversation(msg.Room)
+	}
+	return PeerConversation(msg.Sender, msg.Recipient)
+}
+
+// HistoryEntry is a message kept in the history of its conversation, along with its position in it
+type HistoryEntry struct {
+	Seq uint64 `json:"seq"`
+	Message
+}
+
+// Cursor returns the opaque cursor of the entry, usable to query the messages before or after it
+func (e HistoryEntry) Cursor() string {
+	return strconv.FormatUint(e.Seq, 10)
+}
+
+// HistoryQuery selects a page of a conversation's history
+// Without an after cursor, the page holds the latest messages (before the before cursor, if set),
+// otherwise it holds the earliest messages after the after cursor (and before the before cursor, if set)
+type HistoryQuery struct {
+	Before string
+	After  string
+	Limit  int
+}
+
+// conversationLog is the bounded history of a single conversation
+type conversationLog struct {
+	entries []HistoryEntry // Oldest first
+	nextSeq uint64
+	lines   int // Number of entries in the file, including the ones dropped from memory
+}
+
+// History keeps the latest messages of each conversation, optionally persisting them
+// Each conversation is persisted as a file of JSON lines, appended to and compacted once it grows past twice the limit
+type History struct {
+	dir   string
+	limit int
+	mutex sync.Mutex
+	logs  map[Conversation]*conversationLog
+}
+
+// OpenHistory opens the history stored in the given directory, creating it if needed,
+// keeping up to limit messages per conversation (DefaultHistoryLimit if not positive)
+// With an empty directory, the history is kept in memory only
+func OpenHistory(dir string, limit int) (*History, error) {
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+
+	h := &History{
+		dir:   dir,
+		limit: limit,
+		logs:  make(map[Conversation]*conversationLog),
+	}
+	if dir == "" {
+		return h, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), historyFileExt) {
+			continue
+		}
+		if err := h.load(filepath.Join(dir, entry.Name())); err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+// load reads the history of a conversation from its file
+func (h *History) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+
+	// A crash may leave the last line partially written, so drop anything after the last line break
+	if complete := bytes.LastIndexByte(data, '\n') + 1; complete < len(data) {
+		if err := os.Truncate(path, int64(complete)); err != nil {
+			return fmt.Errorf("failed to truncate history: %w", err)
+		}
+		data = data[:complete]
+	}
+
+	log := &conversationLog{}
+	var conversation Conversation
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry HistoryEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("failed to parse history %s: %w", filepath.Base(path), err)
+		}
+		conversation = conversationOf(entry.Message)
+		log.entries = append(log.entries, entry)
+		log.nextSeq = entry.Seq + 1
+		log.lines++
+	}
+	if len(log.entries) == 0 {
+		return nil
+	}
+
+	if len(log.entries) > h.limit {
+		log.entries = append([]HistoryEntry(nil), log.entries[len(log.entries)-h.limit:]...)
+	}
+	h.logs[conversation] = log
+	return nil
+}
+
+// Append adds a message to the history of its conversation, dropping the oldest one if the conversation is full
+func (h *History) Append(msg Message) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	conversation := conversationOf(msg)
+	log, ok := h.logs[conversation]
+	if !ok {
+		log = &conversationLog{}
+		h.logs[conversation] = log
+	}
+
+	entry := HistoryEntry{Seq: log.nextSeq, Message: msg}
+	if err := h.write(conversation, log, entry); err != nil {
+		return err
+	}
+
+	log.nextSeq++
+	log.entries = append(log.entries, entry)
+	if len(log.entries) > h.limit {
+		log.entries = append([]HistoryEntry(nil), log.entries[1:]...)
+	}
+	return nil
+}
+
+// Query returns a page of the history of a conversation, oldest first,
+// reporting whether more messages are available past the page in the direction of the query
+func (h *History) Query(conversation Conversation, query HistoryQuery) ([]HistoryEntry, bool, error) {
+	before, err := parseCursor(query.Before)
+	if err != nil {
+		return nil, false, err
+	}
+	after, err := parseCursor(query.After)
+	if err != nil {
+		return nil, false, err
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultHistoryPageSize
+	}
+	if limit > MaxHistoryPageSize {
+		limit = MaxHistoryPageSize
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	log, ok := h.logs[conversation]
+	if !ok {
+		return nil, false, nil
+	}
+
+	// Select the entries between the cursors, a missing cursor leaving that side open
+	selected := make([]HistoryEntry, 0, len(log.entries))
+	for _, entry := range log.entries {
+		if (query.After == "" || entry.Seq > after) && (query.Before == "" || entry.Seq < before) {
+			selected = append(selected, entry)
+		}
+	}
+
+	if len(selected) <= limit {
+		return selected, false, nil
+	}
+	if query.After != "" {
+		return selected[:limit], true, nil
+	}
+	return selected[len(selected)-limit:], true, nil
+}
+
+// History returns a page of the history of either a room, or the direct messages between the user and a peer
+func (h *Hub) History(user, room, peer string, query HistoryQuery) ([]HistoryEntry, bool, error) {
+	switch {
+	case room != "" && peer == "":
+		return h.history.Query(RoomConversation(room), query)
+	case room == "" && peer != "":
+		return h.history.Query(PeerConversation(user, peer), query)
+	default:
+		return nil, false, ErrNoConversation
+	}
+}
+
+// write persists a new entry of a conversation, compacting its file when needed; the mutex must be held by the caller
+func (h *History) write(conversation Conversation, log *conversationLog, entry HistoryEntry) error {
+	if h.dir == "" {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	// Rewrite the file with the retained entries only, once the dropped ones make up half of it
+	path := h.path(conversation)
+	if log.lines+1 > 2*h.limit {
+		retained := log.entries
+		if len(retained) >= h.limit {
+			retained = retained[len(retained)-h.limit+1:]
+		}
+
+		var data []byte
+		for _, kept := range retained {
+			keptLine, err := json.Marshal(kept)
+			if err != nil {
+				return err
+			}
+			data = append(append(data, keptLine...), '\n')
+		}
+		data = append(data, line...)
+
+		if err := storage.WriteFileAtomic(path, data); err != nil {
+			return fmt.Errorf("failed to compact history: %w", err)
+		}
+		log.lines = len(retained) + 1
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	log.lines++
+	return nil
+}
+
+// path returns the file holding the history of a conversation, named after a digest as room and user names are arbitrary
+func (h *History) path(conversation Conversation) string {
+	key := "room\x00" + conversation.Room
+	if conversation.Room == "" {
+		key = "peers\x00" + conversation.Peers[0] + "\x00" + conversation.Peers[1]
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(h.dir, hex.EncodeToString(sum[:])+historyFileExt)
+}
+
+// parseCursor parses a history cursor, an empty one being returned as zero
+func parseCursor(cursor string) (uint64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return seq, nil
+}
@@ -0,0 +1,117 @@
+package chat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// appendTestMessages appends numbered messages from alice to bob to the history
+func appendTestMessages(t *testing.T, history *History, from, to int) {
+	t.Helper()
+
+	for i := from; i < to; i++ {
+		msg := newMessage("alice", fmt.Sprintf("Message %d", i))
+		msg.Recipient = "bob"
+		if !assert.NoError(t, history.Append(msg)) {
+			t.FailNow()
+		}
+	}
+}
+
+// historyTexts returns the texts of the history entries
+func historyTexts(entries []HistoryEntry) []string {
+	texts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		texts = append(texts, entry.Text)
+	}
+	return texts
+}
+
+func TestHistory_Query(t *testing.T) {
+	history, err := OpenHistory("", 0)
+	assert.NoError(t, err)
+	appendTestMessages(t, history, 0, 5)
+	conversation := PeerConversation("bob", "alice")
+
+	// Without cursors, the latest messages are returned
+	entries, hasMore, err := history.Query(conversation, HistoryQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Message 3", "Message 4"}, historyTexts(entries))
+	assert.True(t, hasMore)
+
+	// Paging backwards from the oldest returned message
+	entries, hasMore, err = history.Query(conversation, HistoryQuery{Before: entries[0].Cursor(), Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Message 1", "Message 2"}, historyTexts(entries))
+	assert.True(t, hasMore)
+	entries, hasMore, err = history.Query(conversation, HistoryQuery{Before: entries[0].Cursor(), Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Message 0"}, historyTexts(entries))
+	assert.False(t, hasMore)
+
+	// Paging forwards, optionally bounded on both sides
+	entries, hasMore, err = history.Query(conversation, HistoryQuery{After: entries[0].Cursor(), Limit: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Message 1", "Message 2", "Message 3"}, historyTexts(entries))
+	assert.True(t, hasMore)
+	entries, hasMore, err = history.Query(conversation, HistoryQuery{After: entries[0].Cursor(), Before: entries[2].Cursor()})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Message 2"}, historyTexts(entries))
+	assert.False(t, hasMore)
+
+	// Other conversations are kept apart
+	entries, _, err = history.Query(PeerConversation("alice", "carol"), HistoryQuery{})
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	_, _, err = history.Query(conversation, HistoryQuery{Before: "not-a-cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestHistory_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	history, err := OpenHistory(dir, 3)
+	assert.NoError(t, err)
+
+	// Appending past twice the limit compacts the file, keeping the cursors stable
+	appendTestMessages(t, history, 0, 7)
+	conversation := PeerConversation("alice", "bob")
+	entries, _, err := history.Query(conversation, HistoryQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Message 4", "Message 5", "Message 6"}, historyTexts(entries))
+	assert.Equal(t, "6", entries[2].Cursor())
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	if !assert.Len(t, files, 1) {
+		t.FailNow()
+	}
+	path := filepath.Join(dir, files[0].Name())
+
+	// Simulate a crash in the middle of an append
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"seq":7,"id":"torn`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	// Reopen the history from the same directory, as a restarted server would
+	reopened, err := OpenHistory(dir, 3)
+	assert.NoError(t, err)
+	appendTestMessages(t, reopened, 7, 8)
+	entries, _, err = reopened.Query(conversation, HistoryQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Message 5", "Message 6", "Message 7"}, historyTexts(entries))
+	assert.Equal(t, "7", entries[2].Cursor())
+
+	// The torn line is gone for good
+	reopened, err = OpenHistory(dir, 3)
+	assert.NoError(t, err)
+	entries, _, err = reopened.Query(conversation, HistoryQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Message 5", "Message 6", "Message 7"}, historyTexts(entries))
+}
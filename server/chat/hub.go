@@ -0,0 +1,429 @@
+package chat
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// DefaultRedeliveryTimeout is how long a direct message may stay unacknowledged before it is delivered again
+	DefaultRedeliveryTimeout = 30 * time.Second
+	// DefaultIdleTimeout is how long a user may stay inactive before being reported idle
+	DefaultIdleTimeout = 2 * time.Minute
+)
+
+var (
+	ErrNoTarget  = errors.New("message must be sent to either a room or a recipient")
+	ErrEmptyRoom = errors.New("room name must not be empty")
+	ErrEmptyText = errors.New("message must not be empty")
+	ErrEmptyID   = errors.New("message ID must not be empty")
+)
+
+// Message is a chat message, sent either to a room or directly to a recipient
+type Message struct {
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Room      string    `json:"room,omitempty"`
+	Recipient string    `json:"recipient,omitempty"`
+	Text      string    `json:"text"`
+	SentAt    time.Time `json:"sent_at"`
+	// Redelivered is set when the message may have been delivered before without being acknowledged
+	Redelivered bool `json:"-"`
+}
+
+// Typing tells that a user is typing, either in a room or to a recipient
+type Typing struct {
+	User      string
+	Room      string
+	Recipient string
+}
+
+// Event is delivered to the participants, holding exactly one of a message, a presence change or a typing indicator
+type Event struct {
+	Message  *Message
+	Presence *Presence
+	Typing   *Typing
+}
+
+// Participant is a single connection of a user to the hub (a user may be connected more than once)
+type Participant struct {
+	User    string
+	rooms   map[string]struct{} // Guarded by the hub mutex
+	backlog []Message
+	out     chan Event
+	closed  bool          // Guarded by the hub mutex
+	err     error         // Set before done is closed
+	done    chan struct{} // Closed once the participant is removed from the hub
+
+	// The events delivered by the hub are queued in pending, then moved to out by the participant's own goroutine,
+	// without holding the hub mutex (see Hub.pump)
+	queueMutex sync.Mutex // Guards pending and inFlight
+	pending    []Event
+	inFlight   bool          // Whether an event taken from pending waits for room in out
+	wake       chan struct{} // Signals events added to pending
+}
+
+// Backlog returns the messages pending in the user's inbox when the participant connected
+// They must be handled before the events received on the events channel, to keep them in order
+func (p *Participant) Backlog() []Message {
+	return p.backlog
+}
+
+// Events returns the channel the events delivered to the participant are received on
+// It is closed once the participant disconnects, or once the hub disconnects it as it does not keep up (see Err)
+func (p *Participant) Events() <-chan Event {
+	return p.out
+}
+
+// Err returns why the hub disconnected the participant, once its events channel is closed
+// It is nil if the participant was disconnected through Disconnect
+func (p *Participant) Err() error {
+	return p.err
+}
+
+// Options configures a hub, the zero values selecting the defaults
+type Options struct {
+	RedeliveryTimeout time.Duration
+	IdleTimeout       time.Duration
+	// QueueSize is the number of events queued for each participant, DefaultQueueSize if not positive
+	QueueSize int
+	// QueuePolicy is applied when delivering to a participant whose queue is full
+	QueuePolicy QueuePolicy
+	// BlockTimeout is how long delivery waits with QueueBlock before disconnecting, DefaultBlockTimeout if not positive
+	BlockTimeout time.Duration
+}
+
+// Hub fans out chat messages to the connected participants, in-process
+// Direct messages are also kept in the recipient's inbox, and redelivered, until the recipient acknowledges them,
+// while all messages are recorded in the history of their conversation
+type Hub struct {
+	mutex             sync.RWMutex
+	users             map[string]map[*Participant]struct{}
+	rooms             map[string]map[*Participant]struct{}
+	presence          map[string]*userPresence // Presence of the connected users
+	inboxes           *Inboxes
+	history           *History
+	delivered         map[string]time.Time // Last delivery of the pending direct messages, by ID
+	redeliveryTimeout time.Duration
+	idleTimeout       time.Duration
+	queueSize         int
+	queuePolicy       QueuePolicy
+	blockTimeout      time.Duration
+	dropped           atomic.Uint64 // Events dropped from full queues
+	evicted           uint64        // Participants disconnected as their queue was full
+	stop              chan struct{}
+}
+
+// NewHub creates an empty hub keeping direct messages in the given inboxes, and recording all messages in the given history
+func NewHub(inboxes *Inboxes, history *History, opts Options) *Hub {
+	if opts.RedeliveryTimeout <= 0 {
+		opts.RedeliveryTimeout = DefaultRedeliveryTimeout
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.BlockTimeout <= 0 {
+		opts.BlockTimeout = DefaultBlockTimeout
+	}
+
+	h := &Hub{
+		users:             make(map[string]map[*Participant]struct{}),
+		rooms:             make(map[string]map[*Participant]struct{}),
+		presence:          make(map[string]*userPresence),
+		inboxes:           inboxes,
+		history:           history,
+		delivered:         make(map[string]time.Time),
+		redeliveryTimeout: opts.RedeliveryTimeout,
+		idleTimeout:       opts.IdleTimeout,
+		queueSize:         opts.QueueSize,
+		queuePolicy:       opts.QueuePolicy,
+		blockTimeout:      opts.BlockTimeout,
+		stop:              make(chan struct{}),
+	}
+	go h.run()
+
+	return h
+}
+
+// Close stops the redelivery of unacknowledged messages and the idle detection
+func (h *Hub) Close() {
+	close(h.stop)
+}
+
+// Connect registers a new connection of the given user, with the messages pending in its inbox as backlog
+func (h *Hub) Connect(user string) *Participant {
+	p := &Participant{
+		User:  user,
+		rooms: make(map[string]struct{}),
+		done:  make(chan struct{}),
+		wake:  make(chan struct{}, 1),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// Taking the backlog under the lock ensures no message is both in it and on the events channel
+	p.out = make(chan Event, h.queueSize)
+	p.backlog = h.inboxes.Pending(user)
+	now := time.Now()
+	for idx, msg := range p.backlog {
+		if _, ok := h.delivered[msg.ID]; ok {
+			p.backlog[idx].Redelivered = true
+		}
+		h.delivered[msg.ID] = now
+	}
+
+	addMember(h.users, user, p)
+	h.touch(user, now)
+	go h.pump(p)
+	return p
+}
+
+// Disconnect removes a connection from the hub and all its rooms, closing its events channel
+// Disconnecting a participant the hub already disconnected is a no-op
+func (h *Hub) Disconnect(p *Participant) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.remove(p, nil)
+}
+
+// remove removes a connection from the hub and all its rooms, recording why, its events channel being closed
+// by its goroutine (dropping the queued events that no longer fit in it)
+// The user is reported offline once its last connection is removed; the mutex must be held by the caller
+func (h *Hub) remove(p *Participant, err error) {
+	if p.closed {
+		return
+	}
+
+	for room := range p.rooms {
+		removeMember(h.rooms, room, p)
+	}
+	removeMember(h.users, p.User, p)
+	p.closed = true
+	p.err = err
+	close(p.done)
+
+	if _, ok := h.users[p.User]; !ok {
+		h.setOffline(p.User, time.Now())
+	}
+}
+
+// Join adds the participant to a room, creating it if needed
+func (h *Hub) Join(p *Participant, room string) error {
+	if room == "" {
+		return ErrEmptyRoom
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// A participant disconnected by the hub may still be handling a request
+	if p.closed {
+		return nil
+	}
+	p.rooms[room] = struct{}{}
+	addMember(h.rooms, room, p)
+	return nil
+}
+
+// Leave removes the participant from a room; leaving a room the participant is not a member of is a no-op
+func (h *Hub) Leave(p *Participant, room string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := p.rooms[room]; !ok {
+		return
+	}
+	delete(p.rooms, room)
+	removeMember(h.rooms, room, p)
+}
+
+// Send delivers a message from the participant to either a room or a recipient, returning the delivered message
+// Messages sent to a room reach all its members (joining the sender to it if needed),
+// while messages sent to a recipient reach all the connections of both the recipient and the sender
+func (h *Hub) Send(p *Participant, room, recipient, text string) (Message, error) {
+	if text == "" {
+		return Message{}, ErrEmptyText
+	}
+
+	switch {
+	case room != "" && recipient == "":
+		return h.sendToRoom(p, room, text)
+	case room == "" && recipient != "":
+		return h.sendToUser(p, recipient, text)
+	default:
+		return Message{}, ErrNoTarget
+	}
+}
+
+// sendToRoom delivers a message to every participant of a room, including the sender
+func (h *Hub) sendToRoom(p *Participant, room, text string) (Message, error) {
+	if err := h.Join(p, room); err != nil {
+		return Message{}, err
+	}
+
+	msg := newMessage(p.User, text)
+	msg.Room = room
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// Record the message before delivering it, so nothing is delivered if it cannot be recorded
+	if err := h.history.Append(msg); err != nil {
+		return Message{}, err
+	}
+
+	for member := range h.rooms[room] {
+		h.deliver(member, Event{Message: &msg})
+	}
+	return msg, nil
+}
+
+// sendToUser stores a message in the recipient's inbox, then delivers it to every connection of the recipient and of the sender
+func (h *Hub) sendToUser(p *Participant, recipient, text string) (Message, error) {
+	msg := newMessage(p.User, text)
+	msg.Recipient = recipient
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// The inbox goes first, so a message refused by a full inbox is not recorded in the history either
+	if err := h.inboxes.Append(recipient, msg); err != nil {
+		return Message{}, err
+	}
+	if err := h.history.Append(msg); err != nil {
+		return Message{}, err
+	}
+
+	for conn := range h.users[recipient] {
+		h.deliver(conn, Event{Message: &msg})
+	}
+	if len(h.users[recipient]) > 0 {
+		h.delivered[msg.ID] = time.Now()
+	}
+	// The sender's connections get a copy too, unless the sender wrote to themselves
+	if recipient != p.User {
+		for conn := range h.users[p.User] {
+			h.deliver(conn, Event{Message: &msg})
+		}
+	}
+	return msg, nil
+}
+
+// Typing tells the other members of a room, or the connections of a recipient, that the participant is typing
+func (h *Hub) Typing(p *Participant, room, recipient string) error {
+	typing := &Typing{User: p.User, Room: room, Recipient: recipient}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var targets map[*Participant]struct{}
+	switch {
+	case room != "" && recipient == "":
+		targets = h.rooms[room]
+	case room == "" && recipient != "":
+		targets = h.users[recipient]
+	default:
+		return ErrNoTarget
+	}
+
+	for target := range targets {
+		if target.User != p.User {
+			h.deliver(target, Event{Typing: typing})
+		}
+	}
+	return nil
+}
+
+// Ack removes a direct message from the participant's inbox, so it is no longer redelivered
+// Acknowledging a message that is not pending, as it was already acknowledged, is a no-op
+func (h *Hub) Ack(p *Participant, id string) error {
+	if id == "" {
+		return ErrEmptyID
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	acked, err := h.inboxes.Ack(p.User, id)
+	if err != nil {
+		return err
+	}
+	if acked {
+		delete(h.delivered, id)
+	}
+	return nil
+}
+
+// run periodically redelivers the unacknowledged messages and reports the inactive users idle, until the hub is closed
+func (h *Hub) run() {
+	redelivery := time.NewTicker(h.redeliveryTimeout / 2)
+	defer redelivery.Stop()
+	idleness := time.NewTicker(h.idleTimeout / 2)
+	defer idleness.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case now := <-redelivery.C:
+			h.redeliverDue(now)
+		case now := <-idleness.C:
+			h.markIdle(now)
+		}
+	}
+}
+
+// redeliverDue delivers again the pending messages of connected users last delivered before the redelivery timeout
+func (h *Hub) redeliverDue(now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for user, conns := range h.users {
+		for _, msg := range h.inboxes.Pending(user) {
+			if now.Sub(h.delivered[msg.ID]) < h.redeliveryTimeout {
+				continue
+			}
+
+			msg.Redelivered = true
+			for conn := range conns {
+				h.deliver(conn, Event{Message: &msg})
+			}
+			h.delivered[msg.ID] = now
+		}
+	}
+}
+
+// newMessage creates a message from the sender, with a new ID and the current time
+func newMessage(sender, text string) Message {
+	return Message{
+		ID:     ksuid.New().String(),
+		Sender: sender,
+		Text:   text,
+		SentAt: time.Now().UTC(),
+	}
+}
+
+// addMember adds a participant to the set stored under the given key
+func addMember(sets map[string]map[*Participant]struct{}, key string, p *Participant) {
+	if sets[key] == nil {
+		sets[key] = make(map[*Participant]struct{})
+	}
+	sets[key][p] = struct{}{}
+}
+
+// removeMember removes a participant from the set stored under the given key, dropping empty sets
+func removeMember(sets map[string]map[*Participant]struct{}, key string, p *Participant) {
+	delete(sets[key], p)
+	if len(sets[key]) == 0 {
+		delete(sets, key)
+	}
+}
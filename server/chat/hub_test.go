@@ -0,0 +1,357 @@
+package chat
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestHistory creates a history kept in memory only
+func newTestHistory(t *testing.T) *History {
+	t.Helper()
+
+	history, err := OpenHistory("", 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return history
+}
+
+// receiveTestEvent waits for the next event delivered to the participant
+func receiveTestEvent(t *testing.T, p *Participant) Event {
+	t.Helper()
+
+	select {
+	case event := <-p.Events():
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for an event")
+		return Event{}
+	}
+}
+
+// receiveTestMessage waits for the next message delivered to the participant, skipping the other events
+func receiveTestMessage(t *testing.T, p *Participant) Message {
+	t.Helper()
+
+	for {
+		if event := receiveTestEvent(t, p); event.Message != nil {
+			return *event.Message
+		}
+	}
+}
+
+// countTestMessages drains the events already delivered to the participant, counting the messages
+func countTestMessages(p *Participant) int {
+	count := 0
+	for {
+		select {
+		case event := <-p.Events():
+			if event.Message != nil {
+				count++
+			}
+		default:
+			return count
+		}
+	}
+}
+
+func TestHub_OfflineInbox(t *testing.T) {
+	dir := t.TempDir()
+	inboxes, err := OpenInboxes(dir, 0)
+	assert.NoError(t, err)
+	hub := NewHub(inboxes, newTestHistory(t), Options{})
+
+	// Alice writes to Bob while he is offline
+	alice := hub.Connect("alice")
+	for _, text := range []string{"First", "Second"} {
+		_, err = hub.Send(alice, "", "bob", text)
+		assert.NoError(t, err)
+	}
+	hub.Disconnect(alice)
+	hub.Close()
+
+	// Reopen the inboxes from the same directory, as a restarted server would
+	reopened, err := OpenInboxes(dir, 0)
+	assert.NoError(t, err)
+	hub = NewHub(reopened, newTestHistory(t), Options{})
+	defer hub.Close()
+
+	bob := hub.Connect("bob")
+	backlog := bob.Backlog()
+	if assert.Len(t, backlog, 2) {
+		assert.Equal(t, "First", backlog[0].Text)
+		assert.Equal(t, "Second", backlog[1].Text)
+	}
+
+	// Acknowledged messages are gone for good, unknown ones are ignored
+	assert.NoError(t, hub.Ack(bob, backlog[0].ID))
+	assert.NoError(t, hub.Ack(bob, "unknown"))
+	assert.ErrorIs(t, hub.Ack(bob, ""), ErrEmptyID)
+	hub.Disconnect(bob)
+
+	// Reconnecting delivers the rest again, flagged as such
+	bob = hub.Connect("bob")
+	backlog = bob.Backlog()
+	if assert.Len(t, backlog, 1) {
+		assert.Equal(t, "Second", backlog[0].Text)
+		assert.True(t, backlog[0].Redelivered)
+	}
+	hub.Disconnect(bob)
+}
+
+func TestHub_Redelivery(t *testing.T) {
+	inboxes, err := OpenInboxes(t.TempDir(), 0)
+	assert.NoError(t, err)
+	hub := NewHub(inboxes, newTestHistory(t), Options{RedeliveryTimeout: 50 * time.Millisecond})
+	defer hub.Close()
+
+	alice := hub.Connect("alice")
+	bob := hub.Connect("bob")
+
+	sent, err := hub.Send(alice, "", "bob", "Hello")
+	assert.NoError(t, err)
+	assert.Equal(t, sent, receiveTestMessage(t, alice))
+	assert.Equal(t, sent, receiveTestMessage(t, bob))
+
+	// Left unacknowledged, the message is delivered to the recipient again, and only to them
+	redelivered := receiveTestMessage(t, bob)
+	assert.Equal(t, sent.ID, redelivered.ID)
+	assert.True(t, redelivered.Redelivered)
+	assert.Zero(t, countTestMessages(alice))
+
+	// Once acknowledged, it is no longer redelivered
+	assert.NoError(t, hub.Ack(bob, sent.ID))
+	// A redelivery may have raced with the acknowledgement
+	time.Sleep(200 * time.Millisecond)
+	countTestMessages(bob)
+	time.Sleep(200 * time.Millisecond)
+	assert.Zero(t, countTestMessages(bob))
+
+	hub.Disconnect(alice)
+	hub.Disconnect(bob)
+}
+
+func TestHub_Presence(t *testing.T) {
+	inboxes, err := OpenInboxes(t.TempDir(), 0)
+	assert.NoError(t, err)
+	hub := NewHub(inboxes, newTestHistory(t), Options{IdleTimeout: 100 * time.Millisecond})
+	defer hub.Close()
+
+	// Each user is reported online to everyone connected, itself included
+	alice := hub.Connect("alice")
+	assert.Equal(t, StatusOnline, receiveTestEvent(t, alice).Presence.Status)
+	bob := hub.Connect("bob")
+	for _, p := range []*Participant{alice, bob} {
+		presence := receiveTestEvent(t, p).Presence
+		assert.Equal(t, "bob", presence.User)
+		assert.Equal(t, StatusOnline, presence.Status)
+	}
+
+	// A second connection of a user does not change its presence
+	second := hub.Connect("bob")
+	online := hub.Online()
+	if assert.Len(t, online, 2) {
+		assert.Equal(t, "alice", online[0].User)
+		assert.Equal(t, "bob", online[1].User)
+	}
+
+	// Typing reaches the recipient's connections only
+	assert.NoError(t, hub.Typing(alice, "", "bob"))
+	for _, p := range []*Participant{bob, second} {
+		assert.Equal(t, &Typing{User: "alice", Recipient: "bob"}, receiveTestEvent(t, p).Typing)
+	}
+	assert.ErrorIs(t, hub.Typing(alice, "", ""), ErrNoTarget)
+
+	// Without any activity, both users go idle, and a heartbeat brings Alice back
+	idle := map[string]bool{}
+	for len(idle) < 2 {
+		presence := receiveTestEvent(t, alice).Presence
+		assert.Equal(t, StatusIdle, presence.Status)
+		idle[presence.User] = true
+	}
+	hub.Heartbeat(alice)
+	presence := receiveTestEvent(t, alice).Presence
+	assert.Equal(t, "alice", presence.User)
+	assert.Equal(t, StatusOnline, presence.Status)
+
+	// Bob is only reported offline once his last connection closes
+	hub.Disconnect(bob)
+	hub.Disconnect(second)
+	for {
+		presence = receiveTestEvent(t, alice).Presence
+		if presence.User == "bob" {
+			break
+		}
+	}
+	assert.Equal(t, StatusOffline, presence.Status)
+	online = hub.Online()
+	if assert.Len(t, online, 1) {
+		assert.Equal(t, "alice", online[0].User)
+	}
+
+	hub.Disconnect(alice)
+}
+
+func TestHub_SlowConsumer(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         Options
+		consumeAfter time.Duration // Delay before the slow participant starts consuming, not consuming at all if zero
+		messages     []string      // Messages expected by the slow participant
+		err          error         // Error expected from the slow participant once disconnected
+		stats        QueueStats
+	}{
+		{
+			name:     "Test drop oldest policy",
+			opts:     Options{QueueSize: 2, QueuePolicy: QueueDropOldest},
+			messages: []string{"Message 2", "Message 3"},
+			stats:    QueueStats{Participants: 2, Capacity: 2, Dropped: 1},
+		},
+		{
+			name:     "Test disconnect policy",
+			opts:     Options{QueueSize: 2, QueuePolicy: QueueDisconnect},
+			messages: []string{"Message 1", "Message 2"},
+			err:      ErrSlowConsumer,
+			stats:    QueueStats{Participants: 1, Capacity: 2, Disconnected: 1},
+		},
+		{
+			name:         "Test block policy with a consumer catching up",
+			opts:         Options{QueueSize: 2, QueuePolicy: QueueBlock, BlockTimeout: time.Second},
+			consumeAfter: 50 * time.Millisecond,
+			messages:     []string{"Message 1", "Message 2", "Message 3"},
+			stats:        QueueStats{Participants: 2, Capacity: 2},
+		},
+		{
+			name:         "Test block policy with a consumer catching up too late",
+			opts:         Options{QueueSize: 2, QueuePolicy: QueueBlock, BlockTimeout: 50 * time.Millisecond},
+			consumeAfter: 200 * time.Millisecond,
+			messages:     []string{"Message 1", "Message 2"},
+			err:          ErrSlowConsumer,
+			stats:        QueueStats{Participants: 1, Capacity: 2, Disconnected: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inboxes, err := OpenInboxes(t.TempDir(), 0)
+			assert.NoError(t, err)
+			hub := NewHub(inboxes, newTestHistory(t), tt.opts)
+			defer hub.Close()
+
+			// Alice keeps up with her events, while Bob only starts consuming his once all messages are sent (if ever)
+			alice := hub.Connect("alice")
+			bob := hub.Connect("bob")
+			assert.NoError(t, hub.Join(bob, "room"))
+			countTestMessages(alice)
+			countTestMessages(bob)
+
+			received := make(chan []string, 1)
+			go func() {
+				if tt.consumeAfter == 0 {
+					return
+				}
+				time.Sleep(tt.consumeAfter)
+				var texts []string
+				for len(texts) < len(tt.messages) {
+					texts = append(texts, receiveTestMessage(t, bob).Text)
+				}
+				received <- texts
+			}()
+
+			for _, text := range []string{"Message 1", "Message 2", "Message 3"} {
+				_, err := hub.Send(alice, "room", "", text)
+				assert.NoError(t, err)
+				countTestMessages(alice)
+			}
+
+			var texts []string
+			if tt.consumeAfter == 0 {
+				for event := range bob.Events() {
+					if event.Message != nil {
+						texts = append(texts, event.Message.Text)
+					}
+					if tt.err == nil && len(texts) == len(tt.messages) {
+						break
+					}
+				}
+			} else {
+				texts = <-received
+			}
+			assert.Equal(t, tt.messages, texts)
+			if tt.err != nil {
+				// The error is set once the events channel is closed
+				for range bob.Events() {
+				}
+			}
+			assert.ErrorIs(t, bob.Err(), tt.err)
+			countTestMessages(alice)
+			// The goroutine moving Bob's events may not be done with the last one yet
+			assert.Eventually(t, func() bool { return hub.QueueStats() == tt.stats }, time.Second, 10*time.Millisecond)
+			assert.Equal(t, tt.stats, hub.QueueStats())
+
+			hub.Disconnect(bob)
+			hub.Disconnect(alice)
+		})
+	}
+}
+
+func TestHub_BlockedConsumer(t *testing.T) {
+	inboxes, err := OpenInboxes(t.TempDir(), 0)
+	assert.NoError(t, err)
+	hub := NewHub(inboxes, newTestHistory(t), Options{QueueSize: 8, QueuePolicy: QueueBlock, BlockTimeout: time.Minute})
+	defer hub.Close()
+
+	alice := hub.Connect("alice")
+	bob := hub.Connect("bob")
+	carol := hub.Connect("carol")
+	dave := hub.Connect("dave")
+	assert.NoError(t, hub.Join(bob, "room"))
+	for _, p := range []*Participant{bob, carol, dave} {
+		countTestMessages(p)
+	}
+	go func() {
+		for range alice.Events() {
+		}
+	}()
+
+	// Bob does not consume his events, so they wait in his queue once his events channel is full, without blocking the senders
+	texts := make([]string, 10)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("Message %d", i+1)
+	}
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for _, text := range texts {
+			_, err := hub.Send(alice, "room", "", text)
+			assert.NoError(t, err)
+		}
+	}()
+	assert.Eventually(t, func() bool { return hub.QueueStats().MaxDepth == len(texts) }, time.Second, 10*time.Millisecond)
+
+	// The rest of the hub goes on meanwhile
+	_, err = hub.Send(carol, "", "dave", "Hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", receiveTestMessage(t, dave).Text)
+	assert.NoError(t, hub.Join(carol, "other"))
+	hub.Leave(carol, "other")
+	assert.Len(t, hub.Online(), 4)
+
+	// Bob still gets all his events, in order, once he consumes them
+	for _, text := range texts {
+		assert.Equal(t, text, receiveTestMessage(t, bob).Text)
+	}
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the messages to be sent")
+	}
+	assert.NoError(t, bob.Err())
+
+	for _, p := range []*Participant{alice, bob, carol, dave} {
+		hub.Disconnect(p)
+	}
+}
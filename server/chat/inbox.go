@@ -0,0 +1,248 @@
+package chat
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/serbanmarti/go-grpc/server/storage"
+)
+
+const (
+	// DefaultInboxLimit is the number of messages pending in each inbox, when none is configured
+	DefaultInboxLimit = 1000
+
+	// inboxFileExt is the extension of the files holding the inboxes, one per recipient
+	inboxFileExt = ".jsonl"
+)
+
+// ErrInboxFull is returned when sending a direct message to a recipient whose inbox holds too many pending messages
+var ErrInboxFull = errors.New("recipient has too many pending messages")
+
+// inboxRecord is a line of an inbox file, either adding a message to the inbox or acknowledging one
+type inboxRecord struct {
+	User    string   `json:"user"`
+	Message *Message `json:"message,omitempty"`
+	Ack     string   `json:"ack,omitempty"`
+}
+
+// inbox holds the pending messages of a recipient
+type inbox struct {
+	messages []Message // In the order they were sent
+	lines    int       // Number of records in the file, including the acknowledged messages and their acks
+}
+
+// Inboxes durably stores the direct messages of each recipient until they are acknowledged, up to a limit per recipient
+// Each inbox is persisted as a file of JSON lines, appended to and compacted once it grows past twice the limit
+type Inboxes struct {
+	dir     string
+	limit   int
+	mutex   sync.Mutex
+	inboxes map[string]*inbox // By recipient, only holding the inboxes with pending messages
+}
+
+// OpenInboxes opens the inboxes stored in the given directory, creating it if needed,
+// holding up to limit pending messages per recipient (DefaultInboxLimit if not positive)
+func OpenInboxes(dir string, limit int) (*Inboxes, error) {
+	if limit <= 0 {
+		limit = DefaultInboxLimit
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create inbox directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inbox directory: %w", err)
+	}
+
+	i := &Inboxes{
+		dir:     dir,
+		limit:   limit,
+		inboxes: make(map[string]*inbox),
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), inboxFileExt) {
+			continue
+		}
+		if err := i.load(filepath.Join(dir, entry.Name())); err != nil {
+			return nil, err
+		}
+	}
+
+	return i, nil
+}
+
+// load reads an inbox from its file, replaying its records
+func (i *Inboxes) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read inbox: %w", err)
+	}
+
+	// A crash may leave the last line partially written, so drop anything after the last line break
+	if complete := bytes.LastIndexByte(data, '\n') + 1; complete < len(data) {
+		if err := os.Truncate(path, int64(complete)); err != nil {
+			return fmt.Errorf("failed to truncate inbox: %w", err)
+		}
+		data = data[:complete]
+	}
+
+	box := &inbox{}
+	var user string
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		var record inboxRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("failed to parse inbox %s: %w", filepath.Base(path), err)
+		}
+		user = record.User
+		if record.Message != nil {
+			box.messages = append(box.messages, *record.Message)
+		} else {
+			box.messages, _ = removeMessage(box.messages, record.Ack)
+		}
+		box.lines++
+	}
+	if len(box.messages) > 0 {
+		i.inboxes[user] = box
+	}
+	return nil
+}
+
+// Append durably adds a message to the inbox of the given user, failing with ErrInboxFull if it is full
+func (i *Inboxes) Append(user string, msg Message) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	box, ok := i.inboxes[user]
+	if !ok {
+		box = &inbox{}
+	}
+	if len(box.messages) >= i.limit {
+		return ErrInboxFull
+	}
+
+	messages := append(box.messages, msg)
+	if err := i.write(user, box, messages, inboxRecord{User: user, Message: &msg}); err != nil {
+		return err
+	}
+	box.messages = messages
+	i.inboxes[user] = box
+	return nil
+}
+
+// Ack removes a message from the inbox of the given user, reporting whether it was pending
+func (i *Inboxes) Ack(user, id string) (bool, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	box, ok := i.inboxes[user]
+	if !ok {
+		return false, nil
+	}
+	messages, acked := removeMessage(append([]Message(nil), box.messages...), id)
+	if !acked {
+		return false, nil
+	}
+
+	if err := i.write(user, box, messages, inboxRecord{User: user, Ack: id}); err != nil {
+		return false, err
+	}
+	if len(messages) == 0 {
+		delete(i.inboxes, user)
+	} else {
+		box.messages = messages
+	}
+	return true, nil
+}
+
+// Pending returns the messages of the given user not acknowledged yet, in the order they were sent
+func (i *Inboxes) Pending(user string) []Message {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	box, ok := i.inboxes[user]
+	if !ok {
+		return nil
+	}
+	return append([]Message(nil), box.messages...)
+}
+
+// write persists a record of the inbox of the given user, leaving it with the given messages:
+// the record is appended to its file, which is rewritten with the messages only once the records make up twice the limit,
+// and removed once empty; the mutex must be held by the caller
+func (i *Inboxes) write(user string, box *inbox, messages []Message, record inboxRecord) error {
+	path := i.path(user)
+	if len(messages) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove inbox: %w", err)
+		}
+		box.lines = 0
+		return nil
+	}
+
+	if box.lines+1 > 2*i.limit {
+		var data []byte
+		for idx := range messages {
+			line, err := json.Marshal(inboxRecord{User: user, Message: &messages[idx]})
+			if err != nil {
+				return err
+			}
+			data = append(append(data, line...), '\n')
+		}
+		if err := storage.WriteFileAtomic(path, data); err != nil {
+			return fmt.Errorf("failed to compact inbox: %w", err)
+		}
+		box.lines = len(messages)
+		return nil
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open inbox: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write inbox: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write inbox: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write inbox: %w", err)
+	}
+	box.lines++
+	return nil
+}
+
+// removeMessage removes the message with the given ID from the messages, reporting whether it was there
+func removeMessage(messages []Message, id string) ([]Message, bool) {
+	for idx, msg := range messages {
+		if msg.ID == id {
+			return append(messages[:idx], messages[idx+1:]...), true
+		}
+	}
+	return messages, false
+}
+
+// path returns the file holding the inbox of the given user, named after a digest as user names are arbitrary
+func (i *Inboxes) path(user string) string {
+	sum := sha256.Sum256([]byte(user))
+	return filepath.Join(i.dir, hex.EncodeToString(sum[:])+inboxFileExt)
+}
@@ -0,0 +1,83 @@
+package chat
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inboxTexts returns the texts of the messages pending in the inbox of the given user
+func inboxTexts(inboxes *Inboxes, user string) []string {
+	texts := []string{}
+	for _, msg := range inboxes.Pending(user) {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+func TestInboxes_Limit(t *testing.T) {
+	inboxes, err := OpenInboxes(t.TempDir(), 2)
+	require.NoError(t, err)
+
+	first, second := newMessage("alice", "First"), newMessage("alice", "Second")
+	assert.NoError(t, inboxes.Append("bob", first))
+	assert.NoError(t, inboxes.Append("bob", second))
+
+	// A full inbox refuses the new messages, while the other inboxes still accept them
+	assert.ErrorIs(t, inboxes.Append("bob", newMessage("alice", "Third")), ErrInboxFull)
+	assert.NoError(t, inboxes.Append("carol", newMessage("alice", "Third")))
+	assert.Equal(t, []string{"First", "Second"}, inboxTexts(inboxes, "bob"))
+
+	// Acknowledging a message makes room for another one
+	acked, err := inboxes.Ack("bob", first.ID)
+	assert.NoError(t, err)
+	assert.True(t, acked)
+	assert.NoError(t, inboxes.Append("bob", newMessage("alice", "Third")))
+	assert.Equal(t, []string{"Second", "Third"}, inboxTexts(inboxes, "bob"))
+}
+
+func TestInboxes_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	inboxes, err := OpenInboxes(dir, 3)
+	require.NoError(t, err)
+
+	// Append and acknowledge enough messages for the file to be compacted, keeping the first and last ones pending
+	for n := range 10 {
+		msg := newMessage("alice", fmt.Sprintf("Message %d", n))
+		require.NoError(t, inboxes.Append("bob", msg))
+		if n > 0 && n < 9 {
+			acked, err := inboxes.Ack("bob", msg.ID)
+			require.NoError(t, err)
+			assert.True(t, acked)
+		}
+	}
+	assert.Equal(t, []string{"Message 0", "Message 9"}, inboxTexts(inboxes, "bob"))
+
+	// The file is appended to, and compacted once it holds twice the limit
+	data, err := os.ReadFile(inboxes.path("bob"))
+	require.NoError(t, err)
+	assert.LessOrEqual(t, bytes.Count(data, []byte("\n")), 6)
+
+	// A crash may leave a partial line behind, which is dropped when reopening
+	f, err := os.OpenFile(inboxes.path("bob"), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"user":"bob","ack":`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reopened, err := OpenInboxes(dir, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Message 0", "Message 9"}, inboxTexts(reopened, "bob"))
+
+	// Once all the messages are acknowledged, the file is removed
+	for _, msg := range reopened.Pending("bob") {
+		_, err := reopened.Ack("bob", msg.ID)
+		require.NoError(t, err)
+	}
+	_, err = os.Stat(reopened.path("bob"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
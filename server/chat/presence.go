@@ -0,0 +1,99 @@
+package chat
+
+import (
+	"sort"
+	"time"
+)
+
+// PresenceStatus tells whether a user is connected, and active
+type PresenceStatus int
+
+const (
+	StatusOffline PresenceStatus = iota
+	StatusOnline
+	StatusIdle
+)
+
+// Presence is the status of a user, since its last change
+type Presence struct {
+	User   string
+	Status PresenceStatus
+	Since  time.Time
+}
+
+// userPresence is the presence of a connected user, along with its last activity
+type userPresence struct {
+	Presence
+	lastActive time.Time
+}
+
+// Heartbeat marks the participant's user as active, reporting it online again if it was idle
+func (h *Hub) Heartbeat(p *Participant) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// The user may have disconnected concurrently, through another of its connections
+	if _, ok := h.users[p.User]; ok {
+		h.touch(p.User, time.Now())
+	}
+}
+
+// Online returns the presence of the connected users, online or idle, sorted by user
+func (h *Hub) Online() []Presence {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	online := make([]Presence, 0, len(h.presence))
+	for _, presence := range h.presence {
+		online = append(online, presence.Presence)
+	}
+	sort.Slice(online, func(i, j int) bool {
+		return online[i].User < online[j].User
+	})
+	return online
+}
+
+// touch records an activity of the user, broadcasting its presence if it was not online; the mutex must be held by the caller
+func (h *Hub) touch(user string, now time.Time) {
+	presence, ok := h.presence[user]
+	if !ok {
+		presence = &userPresence{Presence: Presence{User: user}}
+		h.presence[user] = presence
+	}
+	presence.lastActive = now
+
+	if presence.Status != StatusOnline {
+		presence.Status = StatusOnline
+		presence.Since = now.UTC()
+		h.broadcastPresence(presence.Presence)
+	}
+}
+
+// setOffline forgets the presence of a user whose last connection closed, broadcasting it; the mutex must be held by the caller
+func (h *Hub) setOffline(user string, now time.Time) {
+	delete(h.presence, user)
+	h.broadcastPresence(Presence{User: user, Status: StatusOffline, Since: now.UTC()})
+}
+
+// markIdle reports idle the online users inactive for longer than the idle timeout
+func (h *Hub) markIdle(now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, presence := range h.presence {
+		if presence.Status == StatusOnline && now.Sub(presence.lastActive) >= h.idleTimeout {
+			presence.Status = StatusIdle
+			presence.Since = now.UTC()
+			h.broadcastPresence(presence.Presence)
+		}
+	}
+}
+
+// broadcastPresence delivers a presence change to every connected participant; the mutex must be held by the caller
+func (h *Hub) broadcastPresence(presence Presence) {
+	for _, conns := range h.users {
+		for conn := range conns {
+			h.deliver(conn, Event{Presence: &presence})
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultQueueSize is the number of events queued for each participant, when none is configured
+	DefaultQueueSize = 64
+	// DefaultBlockTimeout is how long an event waits for room in a full queue with QueueBlock, when none is configured
+	DefaultBlockTimeout = 5 * time.Second
+)
+
+// ErrSlowConsumer is reported by participants the hub disconnected, as they did not keep up with their events
+var ErrSlowConsumer = errors.New("participant does not keep up with its events")
+
+// QueuePolicy selects what happens when delivering to a participant whose queue is full
+type QueuePolicy int
+
+const (
+	// QueueDropOldest drops the oldest queued event to make room for the new one
+	// Dropped direct messages are not lost, as they are redelivered until acknowledged
+	QueueDropOldest QueuePolicy = iota
+	// QueueDisconnect disconnects the participant right away
+	QueueDisconnect
+	// QueueBlock waits for room in the queue, disconnecting the participant after the block timeout,
+	// or once as many events again wait for room
+	// Only the participant's own goroutine waits, the events delivered meanwhile being kept in order
+	QueueBlock
+)
+
+// ParseQueuePolicy parses the name of a queue policy: drop-oldest, disconnect or block
+func ParseQueuePolicy(name string) (QueuePolicy, error) {
+	switch name {
+	case "drop-oldest":
+		return QueueDropOldest, nil
+	case "disconnect":
+		return QueueDisconnect, nil
+	case "block":
+		return QueueBlock, nil
+	default:
+		return 0, fmt.Errorf("unknown queue policy: %s", name)
+	}
+}
+
+// QueueStats is a snapshot of the participants' queues
+type QueueStats struct {
+	Participants int    `json:"participants"`
+	Capacity     int    `json:"capacity"`     // Capacity of each queue, which QueueBlock doubles while waiting for room
+	Depth        int    `json:"depth"`        // Events queued across all queues
+	MaxDepth     int    `json:"max_depth"`    // Events queued in the fullest queue
+	Dropped      uint64 `json:"dropped"`      // Events dropped from full queues, since the hub was created
+	Disconnected uint64 `json:"disconnected"` // Participants disconnected as their queue was full, since the hub was created
+}
+
+// QueueStats returns a snapshot of the participants' queues
+func (h *Hub) QueueStats() QueueStats {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	stats := QueueStats{
+		Capacity:     h.queueSize,
+		Dropped:      h.dropped.Load(),
+		Disconnected: h.evicted,
+	}
+	for _, conns := range h.users {
+		for conn := range conns {
+			conn.queueMutex.Lock()
+			depth := conn.depth()
+			conn.queueMutex.Unlock()
+			stats.Participants++
+			stats.Depth += depth
+			stats.MaxDepth = max(stats.MaxDepth, depth)
+		}
+	}
+	return stats
+}
+
+// deliver queues an event for a participant, applying the queue policy if its queue is full
+// The event is sent on the events channel right away if it has room and no event waits before it,
+// otherwise it is left to the participant's goroutine (see pump), so delivering never waits
+// With QueueBlock, the events wait for room in the events channel, up to the queue size again,
+// only the participant's goroutine waiting along
+// The mutex must be held for writing by the caller
+func (h *Hub) deliver(p *Participant, event Event) {
+	if p.closed {
+		return
+	}
+
+	p.queueMutex.Lock()
+	full := p.depth() >= h.queueSize
+	if h.queuePolicy == QueueBlock {
+		full = p.waiting() >= h.queueSize
+	}
+	if full && h.queuePolicy == QueueDropOldest {
+		p.dropOldest()
+		h.dropped.Add(1)
+		full = false
+	}
+	queued := false
+	if !full && p.waiting() == 0 {
+		select {
+		case p.out <- event:
+			queued = true
+		default:
+		}
+	}
+	if !full && !queued {
+		p.pending = append(p.pending, event)
+	}
+	p.queueMutex.Unlock()
+
+	switch {
+	case full:
+		h.evicted++
+		h.remove(p, ErrSlowConsumer)
+	case !queued:
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pump moves the events delivered to a participant to its events channel, in order, until the participant is removed,
+// then closes the channel; it runs on a goroutine of its own, so a participant that does not keep up
+// holds up neither the hub nor the other participants
+func (h *Hub) pump(p *Participant) {
+	defer close(p.out)
+
+	for {
+		select {
+		case <-p.wake:
+		case <-p.done:
+			p.movePending()
+			return
+		}
+
+		// The events channel only stays full with QueueBlock, the other policies keeping the queue within its capacity
+		for {
+			event, ok := p.movePending()
+			if !ok {
+				break
+			}
+			h.wait(p, event)
+		}
+	}
+}
+
+// wait waits for room in the events channel of a participant to send an event, up to the block timeout,
+// disconnecting the participant once it expires
+func (h *Hub) wait(p *Participant, event Event) {
+	timer := time.NewTimer(h.blockTimeout)
+	defer timer.Stop()
+
+	select {
+	case p.out <- event:
+	case <-p.done:
+	case <-timer.C:
+		h.evict(p)
+	}
+
+	p.queueMutex.Lock()
+	p.inFlight = false
+	p.queueMutex.Unlock()
+}
+
+// evict disconnects a participant that does not keep up with its events, unless it was already disconnected
+func (h *Hub) evict(p *Participant) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if p.closed {
+		return
+	}
+	h.evicted++
+	h.remove(p, ErrSlowConsumer)
+}
+
+// movePending moves the pending events to the events channel while it has room, in order
+// If the channel is full, the next event is taken from pending to wait for room, and returned
+func (p *Participant) movePending() (Event, bool) {
+	p.queueMutex.Lock()
+	defer p.queueMutex.Unlock()
+
+	for len(p.pending) > 0 {
+		event := p.pending[0]
+		p.pending[0] = Event{}
+		p.pending = p.pending[1:]
+
+		select {
+		case p.out <- event:
+		default:
+			p.inFlight = true
+			return event, true
+		}
+	}
+	return Event{}, false
+}
+
+// dropOldest drops the oldest queued event, either on the events channel or pending
+// The participant may receive an event in the meantime, making room on its own; the queue mutex must be held by the caller
+func (p *Participant) dropOldest() {
+	select {
+	case <-p.out:
+	default:
+		if len(p.pending) > 0 {
+			p.pending[0] = Event{}
+			p.pending = p.pending[1:]
+		}
+	}
+}
+
+// depth returns the number of events queued for the participant; the queue mutex must be held by the caller
+func (p *Participant) depth() int {
+	return len(p.out) + p.waiting()
+}
+
+// waiting returns the number of events waiting to be moved to the events channel; the queue mutex must be held by the caller
+func (p *Participant) waiting() int {
+	if p.inFlight {
+		return len(p.pending) + 1
+	}
+	return len(p.pending)
+}
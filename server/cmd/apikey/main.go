@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/serbanmarti/go-grpc/server/auth"
+	"github.com/serbanmarti/go-grpc/server/storage"
+)
+
+// Generates a new API key, adding its salted hash to the keyring file read by the server (API_KEYS_FILE)
+// The key itself is only printed, so it must be handed to its client right away
+func main() {
+	name := flag.String("name", "", "name of the key, the subject of the callers presenting it (required)")
+	file := flag.String("file", "", "keyring file to add the key to (the entry is printed if not set)")
+	expiresIn := flag.Duration("expires-in", 0, "validity of the key, which never expires if zero")
+	flag.Parse()
+
+	if *name == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Generate the key along with its keyring entry
+	var expiresAt *time.Time
+	if *expiresIn > 0 {
+		t := time.Now().Add(*expiresIn).UTC().Truncate(time.Second)
+		expiresAt = &t
+	}
+	key, entry, err := auth.NewAPIKey(*name, expiresAt)
+	if err != nil {
+		log.Fatalf("Failed to generate the key: %v\n", err)
+	}
+
+	if *file == "" {
+		data, err := json.MarshalIndent(entry, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to encode the key entry: %v\n", err)
+		}
+		fmt.Printf("Keyring entry:\n%s\n", data)
+	} else if err := addKey(*file, entry); err != nil {
+		log.Fatalf("Failed to add the key to %s: %v\n", *file, err)
+	}
+	fmt.Printf("API key %s: %s\n", *name, key)
+}
+
+// addKey adds an entry to the keyring file, creating it if needed
+// The server picks the change up on its own, as it checks the file for changes
+func addKey(path string, entry auth.APIKey) error {
+	var keyring struct {
+		Keys []auth.APIKey `json:"keys"`
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &keyring); err != nil {
+			return err
+		}
+	}
+
+	for _, existing := range keyring.Keys {
+		if existing.Name == entry.Name {
+			return fmt.Errorf("a key named %s already exists", entry.Name)
+		}
+	}
+	keyring.Keys = append(keyring.Keys, entry)
+
+	data, err = json.MarshalIndent(keyring, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(path, append(data, '\n'))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/serbanmarti/go-grpc/server/audit"
+)
+
+// Verifies the hash chain of the audit log written by the server (AUDIT_LOG_FILE), along with its rotated files,
+// exiting with an error at the first altered, removed or reordered entry
+// The last hash is printed, so it can be kept elsewhere and compared on the next run to detect removed last entries
+func main() {
+	file := flag.String("file", "", "audit log file to verify, along with its rotated files (required)")
+	flag.Parse()
+
+	if *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	files, err := audit.Files(*file)
+	if err != nil {
+		log.Fatalf("Failed to list the audit log files: %v\n", err)
+	}
+	if len(files) == 0 {
+		log.Fatalf("No audit log found at %s\n", *file)
+	}
+
+	summary, err := audit.Verify(files)
+	if err != nil {
+		log.Fatalf("Audit log verification failed after %d entries: %v\n", summary.Entries, err)
+	}
+	if summary.Entries == 0 {
+		fmt.Printf("Audit log verified: no entries in %d files\n", len(files))
+		return
+	}
+	fmt.Printf(
+		"Audit log verified: %d entries in %d files, sequence %d to %d, last hash %s\n",
+		summary.Entries, len(files), summary.FirstSequence, summary.LastSequence, summary.LastHash,
+	)
+}
@@ -5,11 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
 	"connectrpc.com/connect"
 	"connectrpc.com/grpcreflect"
@@ -19,9 +23,18 @@ import (
 
 	"github.com/serbanmarti/go-grpc/env"
 	"github.com/serbanmarti/go-grpc/proto_gen/crud/v1/crudv1connect"
+	"github.com/serbanmarti/go-grpc/proto_gen/files/v1/filesv1connect"
 	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+	"github.com/serbanmarti/go-grpc/server/audit"
+	"github.com/serbanmarti/go-grpc/server/auth"
+	"github.com/serbanmarti/go-grpc/server/broadcast"
+	"github.com/serbanmarti/go-grpc/server/chat"
+	"github.com/serbanmarti/go-grpc/server/crash"
 	"github.com/serbanmarti/go-grpc/server/interceptor"
+	"github.com/serbanmarti/go-grpc/server/metrics"
 	"github.com/serbanmarti/go-grpc/server/service"
+	"github.com/serbanmarti/go-grpc/server/storage"
+	"github.com/serbanmarti/go-grpc/server/tracing"
 )
 
 func main() {
@@ -47,35 +60,317 @@ func main() {
 	}
 	zap.ReplaceGlobals(logger)
 
-	// Instantiate the interceptors
-	interceptors := connect.WithInterceptors(
-		interceptor.NewLoggerInterceptor(),
-		interceptor.NewAuthInterceptor(),
-		interceptor.NewRecoveryInterceptor(),
-	)
+	// Load the API keys, if any, reloaded when their file changes
+	var keyring *auth.Keyring
+	if environment.APIKeysFile != "" {
+		keyring, err = auth.OpenKeyring(environment.APIKeysFile, environment.APIKeysCheckInterval)
+		if err != nil {
+			zap.L().Fatal("Failed to load the API keys", zap.Error(err))
+		}
+		defer keyring.Close()
+	}
 
-	// Create the server mux
-	mux := http.NewServeMux()
+	// Create the metrics registry, along with the statistics of the Go runtime
+	registry := metrics.NewRegistry()
+	registry.RegisterRuntimeMetrics()
+
+	// Create the tracer, exporting the spans only if a collector is configured
+	var exporter tracing.Exporter
+	if environment.TracingOTLPEndpoint != "" {
+		otlpExporter := tracing.NewOTLPExporter(environment.TracingOTLPEndpoint, environment.TracingServiceName)
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := otlpExporter.Shutdown(ctx); err != nil {
+				zap.L().Error("Error exporting the last spans", zap.Error(err))
+			}
+		}()
+		exporter = otlpExporter
+	}
+	tracer := tracing.NewTracer(exporter, environment.TracingSampleRatio)
+
+	// Instantiate the interceptors, measuring, identifying, tracing and bounding the requests in time
+	// before authenticating them, then limiting the rate of each caller, and authorizing them only if a policy is configured
+	procedureDeadlines, err := interceptor.ParseDeadlines(environment.DeadlineProcedures)
+	if err != nil {
+		zap.L().Fatal("Invalid deadlines", zap.Error(err))
+	}
+	deadlineInterceptor, err := interceptor.NewDeadlineInterceptor(interceptor.DeadlineConfig{
+		Unary:      interceptor.Deadline{Default: environment.DeadlineDefault, Max: environment.DeadlineMax},
+		Stream:     interceptor.Deadline{Default: environment.DeadlineStreamDefault, Max: environment.DeadlineStreamMax},
+		Procedures: procedureDeadlines,
+	})
+	if err != nil {
+		zap.L().Fatal("Failed to create the deadline interceptor", zap.Error(err))
+	}
+	authInterceptor, err := interceptor.NewAuthInterceptor(keyring)
+	if err != nil {
+		zap.L().Fatal("Failed to create the auth interceptor", zap.Error(err))
+	}
+	procedureLimits, err := interceptor.ParseRateLimits(environment.RateLimitProcedures)
+	if err != nil {
+		zap.L().Fatal("Invalid rate limits", zap.Error(err))
+	}
+	rateLimitInterceptor, err := interceptor.NewRateLimitInterceptor(interceptor.RateLimitConfig{
+		Caller:      interceptor.RateLimit{Rate: environment.RateLimitRate, Burst: environment.RateLimitBurst},
+		Procedures:  procedureLimits,
+		MaxStreams:  environment.RateLimitMaxStreams,
+		IdleTimeout: environment.RateLimitIdleTimeout,
+	})
+	if err != nil {
+		zap.L().Fatal("Failed to create the rate limit interceptor", zap.Error(err))
+	}
+	defer rateLimitInterceptor.Close()
+	chain := []connect.Interceptor{
+		interceptor.NewMetricsInterceptor(registry),
+		interceptor.NewRequestIDInterceptor(),
+		interceptor.NewTracingInterceptor(tracer),
+		interceptor.NewLoggerInterceptor(interceptor.LoggerConfig{
+			PayloadSampleRate: environment.LogPayloadSampleRate,
+			RedactedFields:    environment.LogRedactedFields,
+		}),
+		deadlineInterceptor,
+		authInterceptor,
+		rateLimitInterceptor,
+	}
+	// The uploads are bounded by default, while the other streams (e.g. the chats) are only bounded if configured
+	receiveLimits, err := interceptor.ParseStreamBytesLimits(environment.StreamMaxReceiveBytesProcedures)
+	if err != nil {
+		zap.L().Fatal("Invalid stream limits", zap.Error(err))
+	}
+	if _, ok := receiveLimits[streamv1connect.StreamServiceUploadFileProcedure]; !ok {
+		receiveLimits[streamv1connect.StreamServiceUploadFileProcedure] = environment.StreamMaxReceiveBytes
+	}
+	chain = append(chain, interceptor.NewStreamBytesInterceptor(receiveLimits))
+	var authzInterceptor *interceptor.AuthzInterceptor
+	if environment.AuthzPolicyFile != "" {
+		authzInterceptor, err = interceptor.NewAuthzInterceptor(environment.AuthzPolicyFile)
+		if err != nil {
+			zap.L().Fatal("Failed to load the authorization policy", zap.Error(err))
+		}
+		chain = append(chain, authzInterceptor)
+	}
+
+	// Recover from the panics of the handlers, writing crash dumps only if a directory is configured
+	var crashReporter crash.Reporter
+	if environment.CrashDumpDir != "" {
+		crashReporter, err = crash.NewDumpReporter(environment.CrashDumpDir)
+		if err != nil {
+			zap.L().Fatal("Failed to create the crash dump directory", zap.Error(err))
+		}
+	}
+	chain = append(chain, interceptor.NewRecoveryInterceptor(registry, crashReporter))
+	interceptors := connect.WithInterceptors(chain...)
+
+	// Load the TLS certificates, if any, reloaded when their files change
+	var certificates *auth.Certificates
+	if environment.TLSCertFile != "" {
+		certificates, err = auth.OpenCertificates(auth.TLSConfig{
+			CertFile:           environment.TLSCertFile,
+			KeyFile:            environment.TLSKeyFile,
+			ClientCAFile:       environment.TLSClientCAFile,
+			ClientCertOptional: environment.TLSClientCertOptional,
+		}, environment.TLSCheckInterval)
+		if err != nil {
+			zap.L().Fatal("Failed to load the TLS certificates", zap.Error(err))
+		}
+		defer certificates.Close()
+	}
+
+	// Reload the API keys, the authorization policy and the TLS certificates on SIGHUP
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if keyring != nil {
+				if err := keyring.Reload(); err != nil {
+					zap.L().Error("Error reloading the API keys", zap.Error(err))
+				} else {
+					zap.L().Info("Reloaded the API keys", zap.String("path", environment.APIKeysFile))
+				}
+			}
+			if authzInterceptor != nil {
+				if err := authzInterceptor.Reload(); err != nil {
+					zap.L().Error("Error reloading the authorization policy", zap.Error(err))
+				} else {
+					zap.L().Info("Reloaded the authorization policy", zap.String("path", environment.AuthzPolicyFile))
+				}
+			}
+			if certificates != nil {
+				if err := certificates.Reload(); err != nil {
+					zap.L().Error("Error reloading the TLS certificates", zap.Error(err))
+				} else {
+					zap.L().Info("Reloaded the TLS certificates", zap.String("path", environment.TLSCertFile))
+				}
+			}
+		}
+	}()
+
+	// Open the file store, where uploaded files are persisted
+	files, err := storage.NewFileStore(filepath.Join(environment.StorageDir, "files"))
+	if err != nil {
+		zap.L().Fatal("Failed to open the file store", zap.Error(err))
+	}
+
+	// Open the audit log, if any, recording the changes made through the services
+	var auditLog *audit.Log
+	if environment.AuditLogFile != "" {
+		auditLog, err = audit.Open(audit.Config{
+			Path:     environment.AuditLogFile,
+			MaxBytes: environment.AuditMaxBytes,
+			MaxFiles: environment.AuditMaxFiles,
+		})
+		if err != nil {
+			zap.L().Fatal("Failed to open the audit log", zap.Error(err))
+		}
+		defer auditLog.Close()
+	}
+
+	// Open the chat inboxes, where direct messages are kept until their recipients acknowledge them
+	inboxes, err := chat.OpenInboxes(filepath.Join(environment.StorageDir, "inboxes"), environment.ChatInboxLimit)
+	if err != nil {
+		zap.L().Fatal("Failed to open the chat inboxes", zap.Error(err))
+	}
+
+	// Open the chat history, persisted unless disabled
+	historyDir := ""
+	if environment.ChatHistoryPersist {
+		historyDir = filepath.Join(environment.StorageDir, "history")
+	}
+	history, err := chat.OpenHistory(historyDir, environment.ChatHistoryLimit)
+	if err != nil {
+		zap.L().Fatal("Failed to open the chat history", zap.Error(err))
+	}
 
-	// Register the proto services
-	mux.Handle(crudv1connect.NewCrudServiceHandler(&service.CrudService{
+	// Create the chat hub, fanning out the messages of the Stream service
+	queuePolicy, err := chat.ParseQueuePolicy(environment.ChatQueuePolicy)
+	if err != nil {
+		zap.L().Fatal("Invalid chat queue policy", zap.Error(err))
+	}
+	hub := chat.NewHub(inboxes, history, chat.Options{
+		RedeliveryTimeout: environment.ChatRedeliveryTimeout,
+		IdleTimeout:       environment.ChatIdleTimeout,
+		QueueSize:         environment.ChatQueueSize,
+		QueuePolicy:       queuePolicy,
+		BlockTimeout:      environment.ChatQueueBlockTimeout,
+	})
+	defer hub.Close()
+
+	// Create the broker, fanning out the announcements of the Stream service
+	broker := broadcast.NewBroker(environment.BroadcastRetention)
+
+	// Create the CRUD service, along with its store
+	crud := &service.CrudService{
 		Data:  make(map[string]string),
 		Mutex: sync.RWMutex{},
-	}, interceptors))
-	mux.Handle(streamv1connect.NewStreamServiceHandler(&service.StreamService{}, interceptors))
+		Audit: auditLog,
+	}
+
+	// Measure the size of the stores, the chat queues and the dropped announcements
+	registry.NewGaugeFunc("crud_records", "Number of records of the CRUD service.", func() float64 {
+		return float64(crud.Len())
+	})
+	registry.NewGaugeFunc("stored_files", "Number of files of the file store.", func() float64 {
+		count, _ := files.Usage()
+		return float64(count)
+	})
+	registry.NewGaugeFunc("stored_files_bytes", "Bytes taken by the contents of the file store.", func() float64 {
+		_, bytes := files.Usage()
+		return float64(bytes)
+	})
+	registry.NewGaugeFunc("chat_participants", "Number of participants connected to the chat hub.", func() float64 {
+		return float64(hub.QueueStats().Participants)
+	})
+	registry.NewGaugeFunc("chat_queue_capacity", "Number of events each chat participant may have queued.", func() float64 {
+		return float64(hub.QueueStats().Capacity)
+	})
+	registry.NewGaugeFunc("chat_queue_depth", "Number of events queued across all chat participants.", func() float64 {
+		return float64(hub.QueueStats().Depth)
+	})
+	registry.NewGaugeFunc("chat_queue_max_depth", "Number of events queued for the chat participant with the fullest queue.", func() float64 {
+		return float64(hub.QueueStats().MaxDepth)
+	})
+	registry.NewCounterFunc("chat_queue_dropped_total", "Number of events dropped from full chat queues.", func() float64 {
+		return float64(hub.QueueStats().Dropped)
+	})
+	registry.NewCounterFunc("chat_queue_disconnected_total", "Number of chat participants disconnected as their queue was full.", func() float64 {
+		return float64(hub.QueueStats().Disconnected)
+	})
+	registry.NewCounterFunc("broadcast_dropped_total", "Number of announcements dropped from full subscriber queues.", func() float64 {
+		return float64(broker.Dropped())
+	})
+
+	// Create the server mux
+	mux := http.NewServeMux()
+
+	// Register the proto services, each one bounding the size of its messages
+	crudLimits := service.MessageLimits{ReadMaxBytes: environment.CrudReadMaxBytes, SendMaxBytes: environment.CrudSendMaxBytes}
+	streamLimits := service.MessageLimits{ReadMaxBytes: environment.StreamReadMaxBytes, SendMaxBytes: environment.StreamSendMaxBytes}
+	filesLimits := service.MessageLimits{ReadMaxBytes: environment.FilesReadMaxBytes, SendMaxBytes: environment.FilesSendMaxBytes}
+	mux.Handle(crudv1connect.NewCrudServiceHandler(crud, append(crudLimits.HandlerOptions(), interceptors)...))
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&service.StreamService{
+		Files:         files,
+		Hub:           hub,
+		Broker:        broker,
+		MaxChunkBytes: environment.MaxChunkBytes,
+		PingInterval:  environment.StreamPingInterval,
+		IdleTimeout:   environment.StreamIdleTimeout,
+		MaxLifetime:   environment.StreamMaxLifetime,
+		Audit:         auditLog,
+	}, append(streamLimits.HandlerOptions(), interceptors)...))
+	mux.Handle(filesv1connect.NewFileServiceHandler(&service.FileService{
+		Store: files,
+		Audit: auditLog,
+	}, append(filesLimits.HandlerOptions(), interceptors)...))
 
 	// Register the reflection service on the server
 	reflector := grpcreflect.NewStaticReflector(
 		crudv1connect.CrudServiceName,
 		streamv1connect.StreamServiceName,
+		filesv1connect.FileServiceName,
 	)
 	mux.Handle(grpcreflect.NewHandlerV1(reflector))
 	mux.Handle(grpcreflect.NewHandlerV1Alpha(reflector))
 
-	// Create the server
+	// Create the server, pinging the silent HTTP/2 connections so dead peers are detected under the streams
+	h2 := &http2.Server{
+		ReadIdleTimeout: environment.HTTP2ReadIdleTimeout,
+		PingTimeout:     environment.HTTP2PingTimeout,
+		IdleTimeout:     environment.HTTP2IdleTimeout,
+	}
 	srv := &http.Server{
-		Addr:    fmt.Sprintf("0.0.0.0:%d", environment.Port),
-		Handler: h2c.NewHandler(mux, &http2.Server{}),
+		Addr: fmt.Sprintf("0.0.0.0:%d", environment.Port),
+	}
+	// Let the stream handlers abort the pending receives of their streams, to stop receiving before they return
+	handler := service.ReceiveAbortHandler(mux)
+	if certificates != nil {
+		// Serve HTTP/2 over TLS, passing the verified client certificates on to the interceptors
+		srv.Handler = auth.PeerCertificateHandler(handler)
+		srv.TLSConfig = certificates.ServerConfig()
+		if err := http2.ConfigureServer(srv, h2); err != nil {
+			zap.L().Fatal("Failed to configure HTTP/2", zap.Error(err))
+		}
+	} else {
+		// Serve HTTP/2 without TLS (h2c)
+		srv.Handler = h2c.NewHandler(handler, h2)
+	}
+
+	// Create the admin server, exposing the metrics on their own port, apart from the services
+	// It is not authenticated, so it only listens on the loopback interface unless configured otherwise
+	var adminSrv *http.Server
+	if environment.MetricsPort != 0 {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/metrics", registry.Handler())
+		adminSrv = &http.Server{
+			Addr:    net.JoinHostPort(environment.MetricsHost, strconv.Itoa(environment.MetricsPort)),
+			Handler: adminMux,
+		}
+		go func() {
+			zap.L().Info(fmt.Sprintf("Starting admin server and listening at %s...", adminSrv.Addr))
+			if err := adminSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				zap.L().Error(fmt.Sprintf("Admin server listen error: %v", err))
+			}
+		}()
 	}
 
 	// Create a channel to listen for OS signals
@@ -92,14 +387,24 @@ func main() {
 			// Error from closing listeners, or context timeout
 			zap.L().Error(fmt.Sprintf("HTTP server shutdown error: %v", err))
 		}
+		if adminSrv != nil {
+			if err := adminSrv.Shutdown(context.Background()); err != nil {
+				zap.L().Error(fmt.Sprintf("Admin server shutdown error: %v", err))
+			}
+		}
 
 		// Signal that open connections are closed
 		close(openConnsClosed)
 	}()
 
 	// Start the server
-	zap.L().Info(fmt.Sprintf("Starting server and listening at %s...", srv.Addr))
-	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+	zap.L().Info(fmt.Sprintf("Starting server and listening at %s (TLS: %t)...", srv.Addr, certificates != nil))
+	if certificates != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
 		// Error starting or closing listener
 		zap.L().Error(fmt.Sprintf("HTTP server listen error: %v", err))
 	} else {
@@ -0,0 +1,107 @@
+// Package crash recovers from the panics of the request handlers, including the ones of the goroutines they start,
+// turning them into internal errors and reporting them along with their stack trace
+package crash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"go.uber.org/zap"
+)
+
+// ErrPanic is the error of the requests whose handler panicked, wrapped in an internal error
+var ErrPanic = errors.New("unexpected server error")
+
+// IsPanic reports whether a request failed because its handler panicked
+func IsPanic(err error) bool {
+	return errors.Is(err, ErrPanic)
+}
+
+// Crash describes a panic of a request handler
+type Crash struct {
+	Time      time.Time
+	Procedure string
+	RequestID string
+	Value     string
+	Stack     []byte
+}
+
+// Reporter sends the crashes somewhere they can be investigated; it must be safe for concurrent use
+type Reporter interface {
+	ReportCrash(crash Crash)
+}
+
+// Handler handles a panic recovered while serving a request, given the recovered value and the stack of the panic
+type Handler func(value any, stack []byte)
+
+type handlerKey struct{}
+
+// NewContext returns a copy of the context carrying the handler of the panics of its request
+func NewContext(ctx context.Context, handler Handler) context.Context {
+	return context.WithValue(ctx, handlerKey{}, handler)
+}
+
+// Guard runs f, recovering from its panics: they are passed to the handler of the context (or logged if there is none),
+// and turned into an internal error wrapping ErrPanic
+// The handlers wrap the goroutines they start with it, so their panics end the request rather than the server
+func Guard(ctx context.Context, f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			// The stack is taken here, while the frames of the panic are still on it
+			stack := debug.Stack()
+			if handler, ok := ctx.Value(handlerKey{}).(Handler); ok {
+				handler(r, stack)
+			} else {
+				zap.L().Error("recovered from panic", zap.Any("panic", r), zap.ByteString("stack", stack))
+			}
+			err = connect.NewError(connect.CodeInternal, ErrPanic)
+		}
+	}()
+	return f()
+}
+
+// DumpReporter writes each crash to a file of its own in a directory
+type DumpReporter struct {
+	dir string
+}
+
+// NewDumpReporter creates the reporter, creating its directory if needed
+func NewDumpReporter(dir string) (*DumpReporter, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &DumpReporter{dir: dir}, nil
+}
+
+// ReportCrash writes the crash to a file named after its time and request ID, logging the failures to write it
+func (r *DumpReporter) ReportCrash(crash Crash) {
+	name := fmt.Sprintf("crash-%s-%s.txt", crash.Time.UTC().Format("20060102T150405.000000000"), sanitize(crash.RequestID))
+
+	var dump strings.Builder
+	fmt.Fprintf(&dump, "time: %s\n", crash.Time.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&dump, "procedure: %s\n", crash.Procedure)
+	fmt.Fprintf(&dump, "request_id: %s\n", crash.RequestID)
+	fmt.Fprintf(&dump, "panic: %s\n\n", crash.Value)
+	dump.Write(crash.Stack)
+
+	if err := os.WriteFile(filepath.Join(r.dir, name), []byte(dump.String()), 0o600); err != nil {
+		zap.L().Error("Error writing crash dump", zap.Error(err), zap.String("request_id", crash.RequestID))
+	}
+}
+
+// sanitize keeps the characters of a request ID that are safe in a file name
+func sanitize(id string) string {
+	return strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+			return c
+		}
+		return '_'
+	}, id)
+}
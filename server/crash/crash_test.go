@@ -0,0 +1,65 @@
+package crash
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuard(t *testing.T) {
+	// Errors are returned as is
+	failure := errors.New("failure")
+	assert.Equal(t, failure, Guard(context.Background(), func() error { return failure }))
+	assert.False(t, IsPanic(failure))
+
+	// Panics are passed to the handler of the context, and turned into internal errors
+	var recovered any
+	var stack []byte
+	ctx := NewContext(context.Background(), func(value any, s []byte) {
+		recovered, stack = value, s
+	})
+	err := Guard(ctx, func() error { panic("boom") })
+	assert.Equal(t, connect.CodeInternal, connect.CodeOf(err))
+	assert.True(t, IsPanic(err))
+	assert.Equal(t, "boom", recovered)
+	assert.Contains(t, string(stack), "crash.TestGuard")
+
+	// Panics are recovered without a handler as well
+	err = Guard(context.Background(), func() error { panic("boom") })
+	assert.True(t, IsPanic(err))
+}
+
+func TestDumpReporter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "crashes")
+	reporter, err := NewDumpReporter(dir)
+	require.NoError(t, err)
+
+	reporter.ReportCrash(Crash{
+		Time:      time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		Procedure: "/crud.v1.CrudService/Read",
+		RequestID: "../request/1",
+		Value:     "boom",
+		Stack:     []byte("goroutine 1 [running]:\n"),
+	})
+
+	// The dump is named after the time and the sanitized request ID, so it stays within the directory
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "crash-20240506T070809.000000000-___request_1.txt", entries[0].Name())
+
+	dump, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+	assert.Equal(t, "time: 2024-05-06T07:08:09Z\n"+
+		"procedure: /crud.v1.CrudService/Read\n"+
+		"request_id: ../request/1\n"+
+		"panic: boom\n\n"+
+		"goroutine 1 [running]:\n", string(dump))
+}
@@ -2,26 +2,139 @@ package interceptor
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
 	"connectrpc.com/connect"
 
 	"github.com/serbanmarti/go-grpc/env"
+	"github.com/serbanmarti/go-grpc/server/auth"
 )
 
 var errNoToken = fmt.Errorf("auth token missing or invalid")
 
+// anonymousSubject is the name of the callers of the shared secret that do not name themselves
+const anonymousSubject = "anonymous"
+
 type AuthInterceptor struct {
-	secret string
-	header string
+	secret         string
+	header         string
+	clientIDHeader string
+	adminSecret    string
+	adminHeader    string
+	verifier       *auth.Verifier // Verifies the JWT bearer tokens, nil if they are not accepted
+	keyring        *auth.Keyring  // Authenticates the API keys, nil if they are not accepted
+	clientCerts    bool           // Whether the verified client certificates authenticate their holders
 }
 
-func NewAuthInterceptor() *AuthInterceptor {
+// NewAuthInterceptor creates the interceptor authenticating the callers with the shared secret (if configured),
+// the API keys of the keyring (if not nil), JWT bearer tokens (if any key is configured to verify them)
+// or their client certificate (if mutual TLS is configured)
+func NewAuthInterceptor(keyring *auth.Keyring) (*AuthInterceptor, error) {
 	environment := env.GetEnvironment()
-	return &AuthInterceptor{
-		secret: environment.TokenSecret,
-		header: environment.TokenHeader,
+	i := &AuthInterceptor{
+		secret:         environment.TokenSecret,
+		header:         environment.TokenHeader,
+		clientIDHeader: environment.ClientIDHeader,
+		adminSecret:    environment.AdminToken,
+		adminHeader:    environment.AdminTokenHeader,
+		keyring:        keyring,
+		clientCerts:    environment.TLSCertFile != "" && environment.TLSClientCAFile != "",
+	}
+
+	// Accept JWT bearer tokens as well, if any key is configured to verify them
+	jwtConfig := auth.JWTConfig{
+		HMACSecret:     []byte(environment.JWTHMACSecret),
+		PublicKeysFile: environment.JWTPublicKeysFile,
+		JWKSFile:       environment.JWTJWKSFile,
+		Issuer:         environment.JWTIssuer,
+		Audience:       environment.JWTAudience,
+		ClockSkew:      environment.JWTClockSkew,
+	}
+	if jwtConfig.Enabled() {
+		verifier, err := auth.NewVerifier(jwtConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error loading the JWT keys: %w", err)
+		}
+		i.verifier = verifier
+	}
+
+	if i.secret == "" && i.keyring == nil && i.verifier == nil && !i.clientCerts {
+		return nil, fmt.Errorf("no authentication configured, set a shared secret, an API keys file, JWT keys or client CAs")
+	}
+	return i, nil
+}
+
+// authenticate establishes the caller identity from its client certificate or the request headers
+// A client certificate verified during the TLS handshake names the caller through its subject,
+// a bearer token is verified as a JWT, naming the caller through its subject,
+// otherwise the token header holds either an API key, naming the caller through its name, or the shared secret
+// The callers of each method are named within its namespace (e.g. key:ops), so no method can claim another method's caller
+func (i *AuthInterceptor) authenticate(ctx context.Context, header http.Header) (*auth.Identity, error) {
+	if cert, ok := auth.PeerCertificateFromContext(ctx); ok && i.clientCerts {
+		subject := auth.CertificateSubject(cert)
+		if subject == "" {
+			return nil, connect.NewError(connect.CodeUnauthenticated, fmt.Errorf("client certificate names no subject"))
+		}
+		return i.identity(header, auth.NewPrincipal(auth.MethodCert, subject)), nil
+	}
+
+	if token, ok := bearerToken(header); ok && i.verifier != nil {
+		claims, err := i.verifier.Verify(token)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeUnauthenticated, fmt.Errorf("invalid bearer token: %w", err))
+		}
+		subject, _ := claims.GetSubject()
+		identity := i.identity(header, auth.NewPrincipal(auth.MethodJWT, subject))
+		identity.Claims = claims
+		return identity, nil
 	}
+
+	token := header.Get(i.header)
+	if i.keyring != nil {
+		name, err := i.keyring.Authenticate(token, time.Now())
+		if err == nil {
+			return i.identity(header, auth.NewPrincipal(auth.MethodKey, name)), nil
+		}
+		if !errors.Is(err, auth.ErrUnknownKey) {
+			return nil, connect.NewError(connect.CodeUnauthenticated, err)
+		}
+	}
+
+	// An empty secret disables the shared secret, rather than accepting requests without token
+	if i.secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(i.secret)) != 1 {
+		return nil, connect.NewError(connect.CodeUnauthenticated, errNoToken)
+	}
+
+	// As all callers share the same secret, they are equally trusted and name themselves through a header,
+	// within the namespace of the shared secret: they all share the same principal, which they cannot change
+	subject := header.Get(i.clientIDHeader)
+	if subject == "" {
+		subject = anonymousSubject
+	}
+	identity := i.identity(header, auth.SharedSecretPrincipal)
+	identity.Subject = auth.NewPrincipal(auth.MethodSecret, subject)
+	return identity, nil
+}
+
+// bearerToken returns the token of the Authorization header, if it holds a bearer token
+func bearerToken(header http.Header) (string, bool) {
+	scheme, token, ok := strings.Cut(header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// identity builds the identity of the caller authenticated as the given principal, which also names it
+// Only the callers also presenting the admin token (if one is configured) are admins
+func (i *AuthInterceptor) identity(header http.Header, principal string) *auth.Identity {
+	admin := i.adminSecret != "" && subtle.ConstantTimeCompare([]byte(header.Get(i.adminHeader)), []byte(i.adminSecret)) == 1
+	return &auth.Identity{Subject: principal, Principal: principal, Admin: admin}
 }
 
 func (i *AuthInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
@@ -29,11 +142,11 @@ func (i *AuthInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
 		ctx context.Context,
 		req connect.AnyRequest,
 	) (connect.AnyResponse, error) {
-		reqToken := req.Header().Get(i.header)
-		if reqToken != i.secret {
-			return nil, connect.NewError(connect.CodeUnauthenticated, errNoToken)
+		identity, err := i.authenticate(ctx, req.Header())
+		if err != nil {
+			return nil, err
 		}
-		return next(ctx, req)
+		return next(auth.NewContext(ctx, identity), req)
 	}
 }
 
@@ -52,10 +165,10 @@ func (i *AuthInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc
 		ctx context.Context,
 		conn connect.StreamingHandlerConn,
 	) error {
-		reqToken := conn.RequestHeader().Get(i.header)
-		if reqToken != i.secret {
-			return connect.NewError(connect.CodeUnauthenticated, errNoToken)
+		identity, err := i.authenticate(ctx, conn.RequestHeader())
+		if err != nil {
+			return err
 		}
-		return next(ctx, conn)
+		return next(auth.NewContext(ctx, identity), conn)
 	}
 }
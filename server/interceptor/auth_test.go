@@ -0,0 +1,67 @@
+package interceptor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
+	"github.com/serbanmarti/go-grpc/server/auth"
+)
+
+const authProcedure = "/test.v1.AuthService/Identity"
+
+// reportIdentity answers with the subject and principal of the caller
+func reportIdentity(ctx context.Context, _ *connect.Request[crudv1.ReadRequest]) (*connect.Response[crudv1.ReadResponse], error) {
+	identity, _ := auth.FromContext(ctx)
+	return connect.NewResponse(&crudv1.ReadResponse{Id: identity.Subject, Name: identity.Principal}), nil
+}
+
+func TestAuthInterceptorSubjects(t *testing.T) {
+	opsKey, ops, err := auth.NewAPIKey("ops", nil)
+	require.NoError(t, err)
+	data, err := json.Marshal(map[string]any{"keys": []auth.APIKey{ops}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	keyring, err := auth.OpenKeyring(path, 0)
+	require.NoError(t, err)
+	defer keyring.Close()
+
+	authn := &AuthInterceptor{secret: "secret", header: "x-auth-token", clientIDHeader: "x-client-id", keyring: keyring}
+	mux := http.NewServeMux()
+	mux.Handle(authProcedure, connect.NewUnaryHandler(authProcedure, reportIdentity, connect.WithInterceptors(authn)))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := connect.NewClient[crudv1.ReadRequest, crudv1.ReadResponse](server.Client(), server.URL+authProcedure)
+
+	// identify returns the subject and principal of a caller presenting the given token and client ID
+	identify := func(token, clientID string) (string, string) {
+		req := connect.NewRequest(&crudv1.ReadRequest{})
+		req.Header().Set("x-auth-token", token)
+		req.Header().Set("x-client-id", clientID)
+		res, err := client.CallUnary(context.Background(), req)
+		require.NoError(t, err)
+		return res.Msg.Id, res.Msg.Name
+	}
+
+	// The API key names its caller within its namespace, whatever client ID it sends
+	subject, principal := identify(opsKey, "alice")
+	assert.Equal(t, "key:ops", subject)
+	assert.Equal(t, "key:ops", principal)
+
+	// The callers of the shared secret name themselves within its own namespace, so they cannot claim the API key's name
+	for clientID, expected := range map[string]string{"ops": "secret:ops", "key:ops": "secret:key:ops", "": "secret:anonymous"} {
+		subject, principal = identify("secret", clientID)
+		assert.Equal(t, expected, subject, clientID)
+		assert.Equal(t, auth.SharedSecretPrincipal, principal, clientID)
+	}
+}
@@ -0,0 +1,101 @@
+package interceptor
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"connectrpc.com/connect"
+	"go.uber.org/zap"
+
+	"github.com/serbanmarti/go-grpc/server/auth"
+)
+
+// AuthzInterceptor authorizes the authenticated callers to invoke procedures, according to the roles of a policy
+// It must run after the AuthInterceptor, which establishes the caller identity
+type AuthzInterceptor struct {
+	path   string
+	policy atomic.Pointer[auth.Policy]
+}
+
+func NewAuthzInterceptor(policyFile string) (*AuthzInterceptor, error) {
+	i := &AuthzInterceptor{path: policyFile}
+	if err := i.Reload(); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Reload loads the policy file again, keeping the current policy if it is invalid
+func (i *AuthzInterceptor) Reload() error {
+	policy, err := auth.LoadPolicy(i.path)
+	if err != nil {
+		return err
+	}
+	i.policy.Store(policy)
+	return nil
+}
+
+// authorize checks the caller may invoke the procedure, returning the context carrying the caller's roles
+func (i *AuthzInterceptor) authorize(ctx context.Context, procedure string) (context.Context, error) {
+	identity, ok := auth.FromContext(ctx)
+	if !ok {
+		zap.L().Warn("Denied request without identity", zap.String("procedure", procedure))
+		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("permission denied"))
+	}
+
+	policy := i.policy.Load()
+	roles := policy.RolesOf(identity)
+	fields := []zap.Field{
+		zap.String("procedure", procedure),
+		zap.String("subject", identity.Subject),
+		zap.String("principal", identity.Principal),
+		zap.Strings("roles", roles),
+	}
+	if !policy.Allows(roles, procedure) {
+		zap.L().Warn("Denied request", fields...)
+		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("permission denied to call %s", procedure))
+	}
+	zap.L().Debug("Allowed request", fields...)
+
+	// Hand a copy of the identity to the handlers, as the one in the context may be shared
+	authorized := *identity
+	authorized.Roles = roles
+	return auth.NewContext(ctx, &authorized), nil
+}
+
+func (i *AuthzInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(
+		ctx context.Context,
+		req connect.AnyRequest,
+	) (connect.AnyResponse, error) {
+		ctx, err := i.authorize(ctx, req.Spec().Procedure)
+		if err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+func (i *AuthzInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	// This is a no-op because we don't care about the client side in the server
+	return func(
+		ctx context.Context,
+		spec connect.Spec,
+	) connect.StreamingClientConn {
+		return next(ctx, spec)
+	}
+}
+
+func (i *AuthzInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(
+		ctx context.Context,
+		conn connect.StreamingHandlerConn,
+	) error {
+		ctx, err := i.authorize(ctx, conn.Spec().Procedure)
+		if err != nil {
+			return err
+		}
+		return next(ctx, conn)
+	}
+}
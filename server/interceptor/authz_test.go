@@ -0,0 +1,138 @@
+package interceptor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+
+	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/crud/v1/crudv1connect"
+	"github.com/serbanmarti/go-grpc/server/auth"
+	"github.com/serbanmarti/go-grpc/server/service"
+)
+
+// subjectInterceptor stands in for the authentication interceptor, naming the caller from a header as if by its API key
+type subjectInterceptor struct{}
+
+func (i *subjectInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		subject := req.Header().Get("x-client-id")
+		return next(auth.NewContext(ctx, &auth.Identity{
+			Subject:   subject,
+			Principal: auth.NewPrincipal(auth.MethodKey, subject),
+		}), req)
+	}
+}
+
+func (i *subjectInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *subjectInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
+
+func TestAuthzInterceptor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(path, []byte(`{
+		"roles": {"reader": ["/crud.v1.CrudService/Read"], "admin": ["*"]},
+		"subjects": {"key:ops": ["admin"]},
+		"default_roles": ["reader"]
+	}`), 0o600)
+	assert.NoError(t, err)
+	authz, err := NewAuthzInterceptor(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(crudv1connect.NewCrudServiceHandler(&service.CrudService{
+		Data: map[string]string{"record": "Test Record"},
+	}, connect.WithInterceptors(&subjectInterceptor{}, authz)))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := crudv1connect.NewCrudServiceClient(server.Client(), server.URL)
+
+	tests := []struct {
+		name     string
+		subject  string
+		delete   bool
+		wantCode connect.Code
+	}{
+		{name: "Reader reads", subject: "alice"},
+		{name: "Reader cannot delete", subject: "alice", delete: true, wantCode: connect.CodePermissionDenied},
+		{name: "Admin deletes", subject: "ops", delete: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.delete {
+				req := connect.NewRequest(&crudv1.DeleteRequest{Id: "record"})
+				req.Header().Set("x-client-id", tt.subject)
+				_, err = client.Delete(context.Background(), req)
+			} else {
+				req := connect.NewRequest(&crudv1.ReadRequest{Id: "record"})
+				req.Header().Set("x-client-id", tt.subject)
+				_, err = client.Read(context.Background(), req)
+			}
+			if tt.wantCode == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, tt.wantCode, connect.CodeOf(err))
+		})
+	}
+
+	// Reloading an invalid policy keeps the current one
+	assert.NoError(t, os.WriteFile(path, []byte(`{"subjects": {"key:ops": ["missing"]}}`), 0o600))
+	assert.Error(t, authz.Reload())
+	req := connect.NewRequest(&crudv1.ReadRequest{Id: "missing"})
+	req.Header().Set("x-client-id", "alice")
+	_, err = client.Read(context.Background(), req)
+	assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+}
+
+func TestAuthzInterceptorSharedSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(path, []byte(`{
+		"roles": {"reader": ["/crud.v1.CrudService/Read"], "admin": ["*"]},
+		"subjects": {"key:ops": ["admin"], "cert:ops": ["admin"], "jwt:ops": ["admin"]},
+		"default_roles": ["reader"]
+	}`), 0o600)
+	assert.NoError(t, err)
+	authz, err := NewAuthzInterceptor(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	authn := &AuthInterceptor{secret: "secret", header: "x-auth-token", clientIDHeader: "x-client-id"}
+	mux := http.NewServeMux()
+	mux.Handle(crudv1connect.NewCrudServiceHandler(&service.CrudService{
+		Data: map[string]string{"record": "Test Record"},
+	}, connect.WithInterceptors(authn, authz)))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := crudv1connect.NewCrudServiceClient(server.Client(), server.URL)
+
+	// The callers presenting the shared secret get the roles of its principal, whatever name they claim
+	for _, clientID := range []string{"", "ops", "key:ops", "cert:ops"} {
+		req := connect.NewRequest(&crudv1.ReadRequest{Id: "record"})
+		req.Header().Set("x-auth-token", "secret")
+		req.Header().Set("x-client-id", clientID)
+		_, err = client.Read(context.Background(), req)
+		assert.NoError(t, err, clientID)
+
+		del := connect.NewRequest(&crudv1.DeleteRequest{Id: "record"})
+		del.Header().Set("x-auth-token", "secret")
+		del.Header().Set("x-client-id", clientID)
+		_, err = client.Delete(context.Background(), del)
+		assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err), clientID)
+	}
+}
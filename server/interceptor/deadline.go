@@ -0,0 +1,168 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+)
+
+// Deadline bounds the time a request may take: Default is applied when the caller sets no deadline,
+// and Max caps the one set by the caller (e.g. with grpc-timeout); zero leaves either unbounded
+type Deadline struct {
+	Default time.Duration
+	Max     time.Duration
+}
+
+// DeadlineConfig configures the deadlines of the unary and streaming procedures, and of specific procedures
+type DeadlineConfig struct {
+	Unary      Deadline
+	Stream     Deadline
+	Procedures map[string]Deadline
+}
+
+// ParseDeadlines parses the per-procedure deadlines, given as a comma-separated list of procedure=default:max,
+// e.g. "/crud.v1.CrudService/Read=1s:5s,/stream.v1.StreamService/Subscribe=0:1h"
+func ParseDeadlines(value string) (map[string]Deadline, error) {
+	deadlines := make(map[string]Deadline)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		procedure, deadline, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(procedure, "/") {
+			return nil, fmt.Errorf("invalid deadline %q, expecting procedure=default:max", entry)
+		}
+		def, maximum, ok := strings.Cut(deadline, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid deadline %q, expecting procedure=default:max", entry)
+		}
+		d, err := time.ParseDuration(def)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default deadline in %q: %w", entry, err)
+		}
+		m, err := time.ParseDuration(maximum)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maximum deadline in %q: %w", entry, err)
+		}
+		deadlines[procedure] = Deadline{Default: d, Max: m}
+	}
+	return deadlines, nil
+}
+
+// validateDeadline checks that a deadline is usable, naming it in the error
+func validateDeadline(name string, d Deadline) error {
+	if d.Default < 0 || d.Max < 0 {
+		return fmt.Errorf("the %s deadline must not be negative", name)
+	}
+	if d.Default > 0 && d.Max > 0 && d.Default > d.Max {
+		return fmt.Errorf("the default %s deadline must not exceed its maximum", name)
+	}
+	return nil
+}
+
+// DeadlineInterceptor bounds the time the handlers run for, cancelling their context once the deadline of the request
+// (the one set by the caller, capped by the maximum, or else the default one) is exceeded,
+// and failing the request with DeadlineExceeded if the handler fails
+// The handlers must observe the cancellation of their context to end in time
+// It should run after the MetricsInterceptor and the LoggerInterceptor, so the requests ended by their deadline
+// are counted and logged as such, but before the authentication, so its time is bounded as well
+type DeadlineInterceptor struct {
+	config DeadlineConfig
+}
+
+func NewDeadlineInterceptor(config DeadlineConfig) (*DeadlineInterceptor, error) {
+	if err := validateDeadline("unary", config.Unary); err != nil {
+		return nil, err
+	}
+	if err := validateDeadline("stream", config.Stream); err != nil {
+		return nil, err
+	}
+	for procedure, d := range config.Procedures {
+		if err := validateDeadline(procedure, d); err != nil {
+			return nil, err
+		}
+	}
+	return &DeadlineInterceptor{config: config}, nil
+}
+
+// deadline returns the deadline configured for a procedure
+func (i *DeadlineInterceptor) deadline(spec connect.Spec) Deadline {
+	if d, ok := i.config.Procedures[spec.Procedure]; ok {
+		return d
+	}
+	if spec.StreamType == connect.StreamTypeUnary {
+		return i.config.Unary
+	}
+	return i.config.Stream
+}
+
+// withDeadline returns a copy of the context bounded by the deadline of the request, if any
+func (i *DeadlineInterceptor) withDeadline(ctx context.Context, spec connect.Spec) (context.Context, context.CancelFunc) {
+	d := i.deadline(spec)
+	now := time.Now()
+
+	// The caller's deadline, already applied by connect, is kept unless past the maximum
+	deadline, ok := ctx.Deadline()
+	if !ok && d.Default > 0 {
+		deadline, ok = now.Add(d.Default), true
+	}
+	if d.Max > 0 && (!ok || deadline.After(now.Add(d.Max))) {
+		deadline, ok = now.Add(d.Max), true
+	}
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// deadlineError returns the error of a request whose handler failed after its deadline, the given error otherwise
+// A handler succeeding after the deadline keeps its result, as its changes are applied already
+func deadlineError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && connect.CodeOf(err) != connect.CodeDeadlineExceeded {
+		return connect.NewError(connect.CodeDeadlineExceeded, fmt.Errorf("deadline exceeded"))
+	}
+	return err
+}
+
+func (i *DeadlineInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(
+		ctx context.Context,
+		req connect.AnyRequest,
+	) (connect.AnyResponse, error) {
+		ctx, cancel := i.withDeadline(ctx, req.Spec())
+		defer cancel()
+
+		res, err := next(ctx, req)
+		if err = deadlineError(ctx, err); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+}
+
+func (i *DeadlineInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	// This is a no-op because we don't care about the client side in the server
+	return func(
+		ctx context.Context,
+		spec connect.Spec,
+	) connect.StreamingClientConn {
+		return next(ctx, spec)
+	}
+}
+
+func (i *DeadlineInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(
+		ctx context.Context,
+		conn connect.StreamingHandlerConn,
+	) error {
+		ctx, cancel := i.withDeadline(ctx, conn.Spec())
+		defer cancel()
+
+		return deadlineError(ctx, next(ctx, conn))
+	}
+}
@@ -0,0 +1,156 @@
+package interceptor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+	"github.com/serbanmarti/go-grpc/server/broadcast"
+	"github.com/serbanmarti/go-grpc/server/service"
+)
+
+const (
+	deadlineProcedure     = "/test.v1.DeadlineService/Deadline"
+	deadlineWaitProcedure = "/test.v1.DeadlineService/Wait"
+	deadlineLateProcedure = "/test.v1.DeadlineService/Late"
+)
+
+// reportDeadline answers with the time left before the deadline of the request, if any
+func reportDeadline(ctx context.Context, _ *connect.Request[crudv1.ReadRequest]) (*connect.Response[crudv1.ReadResponse], error) {
+	res := &crudv1.ReadResponse{}
+	if deadline, ok := ctx.Deadline(); ok {
+		res.Name = time.Until(deadline).String()
+	}
+	return connect.NewResponse(res), nil
+}
+
+// waitDeadline waits for the request to end, as a handler observing its context does
+func waitDeadline(ctx context.Context, _ *connect.Request[crudv1.ReadRequest]) (*connect.Response[crudv1.ReadResponse], error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(5 * time.Second):
+		return connect.NewResponse(&crudv1.ReadResponse{}), nil
+	}
+}
+
+// succeedLate succeeds after the deadline of the request, as a handler whose change is applied once its deadline passed
+func succeedLate(ctx context.Context, _ *connect.Request[crudv1.ReadRequest]) (*connect.Response[crudv1.ReadResponse], error) {
+	<-ctx.Done()
+	return connect.NewResponse(&crudv1.ReadResponse{Name: "applied"}), nil
+}
+
+func TestParseDeadlines(t *testing.T) {
+	deadlines, err := ParseDeadlines(" /crud.v1.CrudService/Read=1s:5s, /stream.v1.StreamService/Subscribe=0:1h ,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Deadline{
+		"/crud.v1.CrudService/Read":          {Default: time.Second, Max: 5 * time.Second},
+		"/stream.v1.StreamService/Subscribe": {Max: time.Hour},
+	}, deadlines)
+
+	for _, value := range []string{"Read=1s:5s", "/crud.v1.CrudService/Read=1s", "/crud.v1.CrudService/Read=1:5s", "/crud.v1.CrudService/Read=1s:x"} {
+		_, err := ParseDeadlines(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestNewDeadlineInterceptor(t *testing.T) {
+	_, err := NewDeadlineInterceptor(DeadlineConfig{Unary: Deadline{Default: time.Minute, Max: time.Second}})
+	assert.Error(t, err)
+	_, err = NewDeadlineInterceptor(DeadlineConfig{Stream: Deadline{Max: -time.Second}})
+	assert.Error(t, err)
+	_, err = NewDeadlineInterceptor(DeadlineConfig{Procedures: map[string]Deadline{deadlineProcedure: {Default: time.Minute, Max: time.Second}}})
+	assert.Error(t, err)
+	_, err = NewDeadlineInterceptor(DeadlineConfig{Unary: Deadline{Default: time.Second}, Stream: Deadline{Max: time.Hour}})
+	assert.NoError(t, err)
+}
+
+func TestDeadlineInterceptor(t *testing.T) {
+	broker := broadcast.NewBroker(1)
+	_, _, err := broker.Publish("admin", "news", "Hello")
+	require.NoError(t, err)
+
+	deadlines, err := NewDeadlineInterceptor(DeadlineConfig{
+		Unary:  Deadline{Default: time.Minute, Max: 10 * time.Minute},
+		Stream: Deadline{Default: 100 * time.Millisecond},
+		Procedures: map[string]Deadline{
+			deadlineWaitProcedure: {Default: 100 * time.Millisecond, Max: 200 * time.Millisecond},
+			deadlineLateProcedure: {Default: 100 * time.Millisecond},
+		},
+	})
+	require.NoError(t, err)
+	interceptors := connect.WithInterceptors(deadlines)
+
+	mux := http.NewServeMux()
+	mux.Handle(deadlineProcedure, connect.NewUnaryHandler(deadlineProcedure, reportDeadline, interceptors))
+	mux.Handle(deadlineWaitProcedure, connect.NewUnaryHandler(deadlineWaitProcedure, waitDeadline, interceptors))
+	mux.Handle(deadlineLateProcedure, connect.NewUnaryHandler(deadlineLateProcedure, succeedLate, interceptors))
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&service.StreamService{
+		Broker:      broker,
+		MaxLifetime: time.Minute,
+	}, interceptors))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// remaining returns the time left to the handler of a request sent with the given timeout (none if zero)
+	client := connect.NewClient[crudv1.ReadRequest, crudv1.ReadResponse](server.Client(), server.URL+deadlineProcedure)
+	remaining := func(timeout time.Duration) time.Duration {
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		res, err := client.CallUnary(ctx, connect.NewRequest(&crudv1.ReadRequest{}))
+		require.NoError(t, err)
+		left, err := time.ParseDuration(res.Msg.Name)
+		require.NoError(t, err)
+		return left
+	}
+
+	// Without a timeout from the caller, the default deadline applies
+	assert.InDelta(t, time.Minute, remaining(0), float64(5*time.Second))
+	// A shorter timeout from the caller is kept
+	assert.InDelta(t, 20*time.Second, remaining(20*time.Second), float64(5*time.Second))
+	// A longer one is capped to the maximum
+	assert.InDelta(t, 10*time.Minute, remaining(time.Hour), float64(5*time.Second))
+
+	// The handlers observing their context end with DeadlineExceeded once the deadline is exceeded,
+	// the timeout of the caller being capped
+	wait := connect.NewClient[crudv1.ReadRequest, crudv1.ReadResponse](server.Client(), server.URL+deadlineWaitProcedure)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	start := time.Now()
+	_, err = wait.CallUnary(ctx, connect.NewRequest(&crudv1.ReadRequest{}))
+	assert.Equal(t, connect.CodeDeadlineExceeded, connect.CodeOf(err))
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// The handlers succeeding after the deadline keep their result, as their changes are applied
+	late := connect.NewClient[crudv1.ReadRequest, crudv1.ReadResponse](server.Client(), server.URL+deadlineLateProcedure)
+	res, err := late.CallUnary(context.Background(), connect.NewRequest(&crudv1.ReadRequest{}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "applied", res.Msg.Name)
+	}
+
+	// Streams are ended as well, here by the default stream deadline
+	stream, err := streamv1connect.NewStreamServiceClient(server.Client(), server.URL).Subscribe(
+		context.Background(),
+		connect.NewRequest(&streamv1.SubscribeRequest{Topics: []string{"news"}}),
+	)
+	require.NoError(t, err)
+	start = time.Now()
+	assert.True(t, stream.Receive())
+	assert.False(t, stream.Receive())
+	assert.Equal(t, connect.CodeDeadlineExceeded, connect.CodeOf(stream.Err()))
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.NoError(t, stream.Close())
+}
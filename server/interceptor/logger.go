@@ -2,16 +2,124 @@ package interceptor
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+	"time"
 
 	"connectrpc.com/connect"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/serbanmarti/go-grpc/server/tracing"
 )
 
-type LoggerInterceptor struct{}
+// redactedValue replaces the values of the redacted fields in the logged payloads
+const redactedValue = "[REDACTED]"
+
+// LoggerConfig configures the payloads logged by the LoggerInterceptor
+type LoggerConfig struct {
+	// PayloadSampleRate is the ratio of the messages whose payload is logged at the debug level, 0 disabling it
+	PayloadSampleRate float64
+	// RedactedFields are the names of the message fields (as written in the .proto files) whose values are hidden
+	// in the logged payloads, at any depth
+	RedactedFields []string
+}
+
+// LoggerInterceptor logs the start and end of each request, along with its duration, its peer and protocol,
+// and for streams the number and size of the messages exchanged
+// It can also log a sample of the message payloads, with the sensitive fields redacted
+type LoggerInterceptor struct {
+	sampleRate float64
+	redacted   map[string]bool
+}
+
+func NewLoggerInterceptor(config LoggerConfig) *LoggerInterceptor {
+	redacted := make(map[string]bool, len(config.RedactedFields))
+	for _, field := range config.RedactedFields {
+		redacted[field] = true
+	}
+	return &LoggerInterceptor{sampleRate: config.PayloadSampleRate, redacted: redacted}
+}
+
+// requestFields returns the log fields common to all the logs of a request:
+// the procedure, the request ID and the trace ID (if traced)
+func requestFields(ctx context.Context, procedure string) []zap.Field {
+	f := []zap.Field{
+		zap.String("procedure", procedure),
+		zap.String("request_id", RequestIDFromContext(ctx)),
+	}
+	if traceID := tracing.TraceIDFromContext(ctx); traceID != "" {
+		f = append(f, zap.String("trace_id", traceID))
+	}
+	return f
+}
+
+// messageSize returns the encoded size of a message, or 0 if it is not a protobuf message
+func messageSize(msg any) int {
+	if m, ok := msg.(proto.Message); ok {
+		return proto.Size(m)
+	}
+	return 0
+}
+
+// logPayload logs the payload of a sample of the messages at the debug level, with the redacted fields hidden
+func (i *LoggerInterceptor) logPayload(fields []zap.Field, direction string, msg any) {
+	if i.sampleRate <= 0 || (i.sampleRate < 1 && rand.Float64() >= i.sampleRate) {
+		return
+	}
+	ce := zap.L().Check(zap.DebugLevel, fmt.Sprintf("%s message", direction))
+	if ce == nil {
+		return
+	}
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return
+	}
+	// The fields are shared by the messages of a stream, sent and received concurrently, so they are copied on append
+	fields = slices.Clip(fields)
+
+	payload, err := i.redact(m)
+	if err != nil {
+		zap.L().Error("Error encoding the message payload", append(fields, zap.Error(err))...)
+		return
+	}
+	ce.Write(append(fields, zap.String("direction", direction), zap.Any("payload", payload))...)
+}
+
+// redact returns the JSON form of a message, with the values of the redacted fields replaced
+func (i *LoggerInterceptor) redact(msg proto.Message) (any, error) {
+	encoded, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var payload any
+	if err := json.Unmarshal(encoded, &payload); err != nil {
+		return nil, err
+	}
+	i.redactValue(payload)
+	return payload, nil
+}
 
-func NewLoggerInterceptor() *LoggerInterceptor {
-	return &LoggerInterceptor{}
+// redactValue replaces in place the values of the redacted fields of a decoded JSON value
+func (i *LoggerInterceptor) redactValue(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if i.redacted[key] {
+				v[key] = redactedValue
+			} else {
+				i.redactValue(field)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			i.redactValue(item)
+		}
+	}
 }
 
 func (i *LoggerInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
@@ -20,15 +128,22 @@ func (i *LoggerInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc
 		req connect.AnyRequest,
 	) (res connect.AnyResponse, err error) {
 		// Log the start and end of the request
-
-		// Add the procedure to the log fields
 		proc := req.Spec().Procedure
-		f := []zap.Field{
-			zap.String("procedure", proc),
-		}
+		f := requestFields(ctx, proc)
+		start := time.Now()
 
 		zap.L().Info(fmt.Sprintf("started unary request: %s", proc), f...)
+		i.logPayload(f, "received", req.Any())
 		res, err = next(ctx, req)
+		if err == nil {
+			i.logPayload(f, "sent", res.Any())
+		}
+
+		f = append(f,
+			zap.Duration("duration", time.Since(start)),
+			zap.String("peer_address", req.Peer().Addr),
+			zap.String("protocol", req.Peer().Protocol),
+		)
 		if err != nil {
 			f = append(f, zap.Error(err), zap.Any("response_code", connect.CodeOf(err)))
 		}
@@ -53,16 +168,24 @@ func (i *LoggerInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFu
 		ctx context.Context,
 		conn connect.StreamingHandlerConn,
 	) (err error) {
-		// Log the start and end of the request
-
-		// Add the procedure to the log fields
+		// Log the start and end of the request, with the messages exchanged in between
 		proc := conn.Spec().Procedure
-		f := []zap.Field{
-			zap.String("procedure", proc),
-		}
+		f := requestFields(ctx, proc)
+		start := time.Now()
 
 		zap.L().Info(fmt.Sprintf("started stream request: %s", proc), f...)
-		err = next(ctx, conn)
+		logged := &loggedHandlerConn{StreamingHandlerConn: conn, interceptor: i, fields: f}
+		err = next(ctx, logged)
+
+		f = append(f,
+			zap.Duration("duration", time.Since(start)),
+			zap.String("peer_address", conn.Peer().Addr),
+			zap.String("protocol", conn.Peer().Protocol),
+			zap.Int64("messages_received", logged.messagesReceived.Load()),
+			zap.Int64("messages_sent", logged.messagesSent.Load()),
+			zap.Int64("bytes_received", logged.bytesReceived.Load()),
+			zap.Int64("bytes_sent", logged.bytesSent.Load()),
+		)
 		if err != nil {
 			f = append(f, zap.Error(err), zap.Any("response_code", connect.CodeOf(err)))
 		}
@@ -71,3 +194,37 @@ func (i *LoggerInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFu
 		return
 	}
 }
+
+// loggedHandlerConn counts the messages received and sent successfully on a stream, along with their encoded size,
+// logging a sample of their payloads
+// The messages may be received and sent from different goroutines, hence the atomic counters
+type loggedHandlerConn struct {
+	connect.StreamingHandlerConn
+	interceptor *LoggerInterceptor
+	fields      []zap.Field
+
+	messagesReceived atomic.Int64
+	messagesSent     atomic.Int64
+	bytesReceived    atomic.Int64
+	bytesSent        atomic.Int64
+}
+
+func (c *loggedHandlerConn) Receive(msg any) error {
+	err := c.StreamingHandlerConn.Receive(msg)
+	if err == nil {
+		c.messagesReceived.Add(1)
+		c.bytesReceived.Add(int64(messageSize(msg)))
+		c.interceptor.logPayload(c.fields, "received", msg)
+	}
+	return err
+}
+
+func (c *loggedHandlerConn) Send(msg any) error {
+	err := c.StreamingHandlerConn.Send(msg)
+	if err == nil {
+		c.messagesSent.Add(1)
+		c.bytesSent.Add(int64(messageSize(msg)))
+		c.interceptor.logPayload(c.fields, "sent", msg)
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package interceptor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/protobuf/proto"
+
+	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/crud/v1/crudv1connect"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+	"github.com/serbanmarti/go-grpc/server/broadcast"
+	"github.com/serbanmarti/go-grpc/server/service"
+)
+
+func TestLoggerInterceptor(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	broker := broadcast.NewBroker(1)
+	_, _, err := broker.Publish("admin", "news", "Hello")
+	assert.NoError(t, err)
+
+	interceptors := connect.WithInterceptors(NewLoggerInterceptor(LoggerConfig{
+		PayloadSampleRate: 1,
+		RedactedFields:    []string{"name", "message"},
+	}))
+	mux := http.NewServeMux()
+	mux.Handle(crudv1connect.NewCrudServiceHandler(&service.CrudService{
+		Data: map[string]string{"record": "Test Record"},
+	}, interceptors))
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&service.StreamService{
+		Broker:      broker,
+		MaxLifetime: time.Minute,
+	}, interceptors))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Unary requests are logged with their duration, peer and protocol, and their payloads redacted
+	crud := crudv1connect.NewCrudServiceClient(server.Client(), server.URL)
+	_, err = crud.Read(context.Background(), connect.NewRequest(&crudv1.ReadRequest{Id: "record"}))
+	assert.NoError(t, err)
+
+	finished := logs.FilterMessage("finished unary request: /crud.v1.CrudService/Read").All()
+	if assert.Len(t, finished, 1) {
+		fields := finished[0].ContextMap()
+		assert.Equal(t, "connect", fields["protocol"])
+		assert.NotEmpty(t, fields["peer_address"])
+		assert.Contains(t, fields, "duration")
+	}
+	sent := logs.FilterMessage("sent message").All()
+	if assert.Len(t, sent, 1) {
+		assert.Equal(t, map[string]any{"id": "record", "name": redactedValue}, sent[0].ContextMap()["payload"])
+	}
+
+	// Streams are logged with the number and size of their messages
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := streamv1connect.NewStreamServiceClient(server.Client(), server.URL).Subscribe(
+		ctx,
+		connect.NewRequest(&streamv1.SubscribeRequest{Topics: []string{"news"}}),
+	)
+	assert.NoError(t, err)
+	assert.True(t, stream.Receive())
+	announcement := stream.Msg()
+
+	cancel()
+	_ = stream.Close()
+	assert.Eventually(t, func() bool {
+		return logs.FilterMessage("finished stream request: /stream.v1.StreamService/Subscribe").Len() == 1
+	}, 2*time.Second, 20*time.Millisecond)
+
+	fields := logs.FilterMessage("finished stream request: /stream.v1.StreamService/Subscribe").All()[0].ContextMap()
+	assert.Equal(t, int64(1), fields["messages_received"])
+	assert.Equal(t, int64(1), fields["messages_sent"])
+	assert.Equal(t, int64(proto.Size(&streamv1.SubscribeRequest{Topics: []string{"news"}})), fields["bytes_received"])
+	assert.Equal(t, int64(proto.Size(announcement)), fields["bytes_sent"])
+	assert.Equal(t, "canceled", fields["response_code"])
+
+	payloads := logs.FilterMessage("sent message").FilterField(zap.String("procedure", "/stream.v1.StreamService/Subscribe")).All()
+	if assert.Len(t, payloads, 1) {
+		payload := payloads[0].ContextMap()["payload"].(map[string]any)
+		assert.Equal(t, redactedValue, payload["message"])
+		assert.Equal(t, "news", payload["topic"])
+	}
+}
+
+func TestLoggerInterceptorPayloadsDisabled(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	mux := http.NewServeMux()
+	mux.Handle(crudv1connect.NewCrudServiceHandler(&service.CrudService{
+		Data: map[string]string{"record": "Test Record"},
+	}, connect.WithInterceptors(NewLoggerInterceptor(LoggerConfig{}))))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	crud := crudv1connect.NewCrudServiceClient(server.Client(), server.URL)
+	_, err := crud.Read(context.Background(), connect.NewRequest(&crudv1.ReadRequest{Id: "record"}))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, logs.Len())
+	assert.Zero(t, logs.FilterMessage("received message").Len()+logs.FilterMessage("sent message").Len())
+}
@@ -0,0 +1,122 @@
+package interceptor
+
+import (
+	"context"
+	"time"
+
+	"connectrpc.com/connect"
+
+	"github.com/serbanmarti/go-grpc/server/metrics"
+)
+
+// MetricsInterceptor records the requests of each procedure: their number and result code, their latency,
+// and for streams the messages exchanged and the ones currently open
+// It should run first, so the requests refused by the other interceptors are recorded as well
+type MetricsInterceptor struct {
+	requests  *metrics.CounterVec
+	durations *metrics.HistogramVec
+	messages  *metrics.CounterVec
+	streams   *metrics.GaugeVec
+}
+
+// NewMetricsInterceptor creates the interceptor, registering its metrics in the registry
+func NewMetricsInterceptor(registry *metrics.Registry) *MetricsInterceptor {
+	return &MetricsInterceptor{
+		requests: registry.NewCounterVec(
+			"rpc_requests_total",
+			"Number of requests handled, by procedure and result code.",
+			"procedure", "code",
+		),
+		durations: registry.NewHistogramVec(
+			"rpc_request_duration_seconds",
+			"Time taken to handle the requests, until the end of the stream for streaming procedures.",
+			nil,
+			"procedure",
+		),
+		messages: registry.NewCounterVec(
+			"rpc_stream_messages_total",
+			"Number of stream messages received from or sent to the clients, by procedure.",
+			"procedure", "direction",
+		),
+		streams: registry.NewGaugeVec(
+			"rpc_streams_in_flight",
+			"Number of streams currently open, by procedure.",
+			"procedure",
+		),
+	}
+}
+
+// record records the end of a request
+func (i *MetricsInterceptor) record(procedure string, start time.Time, err error) {
+	code := "ok"
+	if err != nil {
+		code = connect.CodeOf(err).String()
+	}
+	i.requests.Inc(procedure, code)
+	i.durations.Observe(time.Since(start).Seconds(), procedure)
+}
+
+func (i *MetricsInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(
+		ctx context.Context,
+		req connect.AnyRequest,
+	) (connect.AnyResponse, error) {
+		start := time.Now()
+		res, err := next(ctx, req)
+		i.record(req.Spec().Procedure, start, err)
+		return res, err
+	}
+}
+
+func (i *MetricsInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	// This is a no-op because we don't care about the client side in the server
+	return func(
+		ctx context.Context,
+		spec connect.Spec,
+	) connect.StreamingClientConn {
+		return next(ctx, spec)
+	}
+}
+
+func (i *MetricsInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(
+		ctx context.Context,
+		conn connect.StreamingHandlerConn,
+	) error {
+		procedure := conn.Spec().Procedure
+		start := time.Now()
+		i.streams.Add(1, procedure)
+		defer i.streams.Add(-1, procedure)
+
+		err := next(ctx, &countingHandlerConn{
+			StreamingHandlerConn: conn,
+			received:             func() { i.messages.Inc(procedure, "received") },
+			sent:                 func() { i.messages.Inc(procedure, "sent") },
+		})
+		i.record(procedure, start, err)
+		return err
+	}
+}
+
+// countingHandlerConn calls back for every message received or sent successfully on the stream
+type countingHandlerConn struct {
+	connect.StreamingHandlerConn
+	received func()
+	sent     func()
+}
+
+func (c *countingHandlerConn) Receive(msg any) error {
+	err := c.StreamingHandlerConn.Receive(msg)
+	if err == nil {
+		c.received()
+	}
+	return err
+}
+
+func (c *countingHandlerConn) Send(msg any) error {
+	err := c.StreamingHandlerConn.Send(msg)
+	if err == nil {
+		c.sent()
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package interceptor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+
+	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/crud/v1/crudv1connect"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+	"github.com/serbanmarti/go-grpc/server/broadcast"
+	"github.com/serbanmarti/go-grpc/server/metrics"
+	"github.com/serbanmarti/go-grpc/server/service"
+)
+
+func TestMetricsInterceptor(t *testing.T) {
+	registry := metrics.NewRegistry()
+	broker := broadcast.NewBroker(1)
+	_, _, err := broker.Publish("admin", "news", "Hello")
+	assert.NoError(t, err)
+
+	interceptors := connect.WithInterceptors(NewMetricsInterceptor(registry))
+	mux := http.NewServeMux()
+	mux.Handle(crudv1connect.NewCrudServiceHandler(&service.CrudService{
+		Data: map[string]string{"record": "Test Record"},
+	}, interceptors))
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&service.StreamService{
+		Broker:      broker,
+		MaxLifetime: time.Minute,
+	}, interceptors))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	scrape := func() string {
+		var out strings.Builder
+		assert.NoError(t, registry.WriteText(&out))
+		return out.String()
+	}
+
+	// Unary requests are counted by result code
+	crud := crudv1connect.NewCrudServiceClient(server.Client(), server.URL)
+	for _, id := range []string{"record", "record", "missing"} {
+		_, _ = crud.Read(context.Background(), connect.NewRequest(&crudv1.ReadRequest{Id: id}))
+	}
+	out := scrape()
+	assert.Contains(t, out, `rpc_requests_total{procedure="/crud.v1.CrudService/Read",code="ok"} 2`)
+	assert.Contains(t, out, `rpc_requests_total{procedure="/crud.v1.CrudService/Read",code="not_found"} 1`)
+	assert.Contains(t, out, `rpc_request_duration_seconds_count{procedure="/crud.v1.CrudService/Read"} 3`)
+
+	// Open streams are counted, along with the messages they send
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := streamv1connect.NewStreamServiceClient(server.Client(), server.URL).Subscribe(
+		ctx,
+		connect.NewRequest(&streamv1.SubscribeRequest{Topics: []string{"news"}}),
+	)
+	assert.NoError(t, err)
+	assert.True(t, stream.Receive())
+	out = scrape()
+	assert.Contains(t, out, `rpc_streams_in_flight{procedure="/stream.v1.StreamService/Subscribe"} 1`)
+	assert.Contains(t, out, `rpc_stream_messages_total{procedure="/stream.v1.StreamService/Subscribe",direction="sent"} 1`)
+
+	cancel()
+	_ = stream.Close()
+	assert.Eventually(t, func() bool {
+		out := scrape()
+		return strings.Contains(out, `rpc_streams_in_flight{procedure="/stream.v1.StreamService/Subscribe"} 0`) &&
+			strings.Contains(out, `rpc_requests_total{procedure="/stream.v1.StreamService/Subscribe",code="canceled"} 1`)
+	}, 2*time.Second, 20*time.Millisecond)
+}
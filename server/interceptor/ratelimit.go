@@ -0,0 +1,310 @@
+package interceptor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"go.uber.org/zap"
+
+	"github.com/serbanmarti/go-grpc/server/auth"
+)
+
+// retryAfterHeader is the metadata key of rate-limited responses, holding the seconds to wait before retrying
+const retryAfterHeader = "retry-after"
+
+// RateLimit is the rate of a token bucket, refilled with Rate tokens per second up to Burst tokens
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitConfig configures the RateLimitInterceptor
+type RateLimitConfig struct {
+	// Caller limits the requests of each caller, across all procedures (no limit if the rate is zero)
+	Caller RateLimit
+	// Procedures limits the requests of each caller to the given procedures
+	Procedures map[string]RateLimit
+	// MaxStreams limits the streams each caller has open at once (no limit if zero)
+	MaxStreams int
+	// IdleTimeout is how long the bucket of a caller is kept once refilled, without requests
+	IdleTimeout time.Duration
+}
+
+// ParseRateLimits parses the per-procedure limits, given as a comma-separated list of procedure=rate:burst
+// e.g. "/crud.v1.CrudService/Create=1:5,/crud.v1.CrudService/Delete=0.5:2"
+func ParseRateLimits(value string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		procedure, limit, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(procedure, "/") {
+			return nil, fmt.Errorf("invalid rate limit %q, expecting procedure=rate:burst", entry)
+		}
+		rate, burst, ok := strings.Cut(limit, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, expecting procedure=rate:burst", entry)
+		}
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate in %q: %w", entry, err)
+		}
+		b, err := strconv.Atoi(burst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid burst in %q: %w", entry, err)
+		}
+		limits[procedure] = RateLimit{Rate: r, Burst: b}
+	}
+	return limits, nil
+}
+
+// tokenBucket holds the tokens left to a caller, as of the last time it was refilled
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// bucketKey identifies the bucket of a caller, by its principal, for a procedure, or across all procedures if empty
+type bucketKey struct {
+	principal string
+	procedure string
+}
+
+// RateLimitInterceptor limits the rate of the requests of each caller, with token buckets,
+// along with the number of streams each caller has open at once
+// The callers are told apart by their principal, so that they cannot escape the limits by naming themselves differently,
+// all the callers of the shared secret sharing the same limits
+// It must run after the AuthInterceptor, which establishes the caller identity
+type RateLimitInterceptor struct {
+	config RateLimitConfig
+	now    func() time.Time
+
+	mutex   sync.Mutex
+	buckets map[bucketKey]*tokenBucket
+	streams map[string]int
+
+	stop chan struct{}
+}
+
+func NewRateLimitInterceptor(config RateLimitConfig) (*RateLimitInterceptor, error) {
+	limits := map[string]RateLimit{"caller": config.Caller}
+	for procedure, limit := range config.Procedures {
+		limits[procedure] = limit
+	}
+	for name, limit := range limits {
+		if limit.Rate < 0 || (limit.Rate > 0 && limit.Burst < 1) {
+			return nil, fmt.Errorf("invalid rate limit for %s: the rate must not be negative, and the burst at least 1", name)
+		}
+	}
+	if config.MaxStreams < 0 {
+		return nil, fmt.Errorf("invalid maximum of streams: %d", config.MaxStreams)
+	}
+
+	i := &RateLimitInterceptor{
+		config:  config,
+		now:     time.Now,
+		buckets: make(map[bucketKey]*tokenBucket),
+		streams: make(map[string]int),
+		stop:    make(chan struct{}),
+	}
+	if config.IdleTimeout > 0 {
+		go i.evictIdleBuckets()
+	}
+	return i, nil
+}
+
+// Close stops evicting the idle buckets
+func (i *RateLimitInterceptor) Close() {
+	close(i.stop)
+}
+
+// allow takes a token from the buckets of the caller for the procedure,
+// or returns how long to wait for one if any of them is empty, taking none
+func (i *RateLimitInterceptor) allow(principal, procedure string) (time.Duration, bool) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	now := i.now()
+	var buckets []*tokenBucket
+	var wait time.Duration
+	check := func(key bucketKey, limit RateLimit) {
+		if limit.Rate <= 0 {
+			return
+		}
+		bucket := i.refill(key, limit, now)
+		if bucket.tokens < 1 {
+			wait = max(wait, time.Duration((1-bucket.tokens)/limit.Rate*float64(time.Second)))
+		}
+		buckets = append(buckets, bucket)
+	}
+	check(bucketKey{principal: principal}, i.config.Caller)
+	check(bucketKey{principal: principal, procedure: procedure}, i.config.Procedures[procedure])
+
+	if wait > 0 {
+		return wait, false
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return 0, true
+}
+
+// refill returns the bucket, created full if needed, with the tokens accrued since it was last refilled
+// The mutex must be held
+func (i *RateLimitInterceptor) refill(key bucketKey, limit RateLimit, now time.Time) *tokenBucket {
+	bucket, ok := i.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		i.buckets[key] = bucket
+		return bucket
+	}
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+elapsed*limit.Rate)
+	bucket.last = now
+	return bucket
+}
+
+// limit returns the limit of the bucket
+func (i *RateLimitInterceptor) limit(key bucketKey) RateLimit {
+	if key.procedure == "" {
+		return i.config.Caller
+	}
+	return i.config.Procedures[key.procedure]
+}
+
+// evictIdleBuckets periodically removes the buckets unused for the idle timeout,
+// once refilled so that evicting them does not grant the callers more tokens
+func (i *RateLimitInterceptor) evictIdleBuckets() {
+	ticker := time.NewTicker(i.config.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-i.stop:
+			return
+		case <-ticker.C:
+			i.evict()
+		}
+	}
+}
+
+// evict removes the idle buckets, returning how many were removed
+func (i *RateLimitInterceptor) evict() int {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	now := i.now()
+	evicted := 0
+	for key, bucket := range i.buckets {
+		limit := i.limit(key)
+		idle := now.Sub(bucket.last)
+		if idle >= i.config.IdleTimeout && bucket.tokens+idle.Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(i.buckets, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// openStream counts a stream of the caller, unless it has too many open already
+func (i *RateLimitInterceptor) openStream(principal string) bool {
+	if i.config.MaxStreams == 0 {
+		return true
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.streams[principal] >= i.config.MaxStreams {
+		return false
+	}
+	i.streams[principal]++
+	return true
+}
+
+// closeStream stops counting a stream of the caller
+func (i *RateLimitInterceptor) closeStream(principal string) {
+	if i.config.MaxStreams == 0 {
+		return
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.streams[principal]--; i.streams[principal] <= 0 {
+		delete(i.streams, principal)
+	}
+}
+
+// check takes a token for the request of the caller, returning a ResourceExhausted error if it must wait
+func (i *RateLimitInterceptor) check(ctx context.Context, procedure string) error {
+	principal := auth.PrincipalFromContext(ctx)
+	wait, ok := i.allow(principal, procedure)
+	if ok {
+		return nil
+	}
+
+	zap.L().Warn("Rate limited request",
+		zap.String("procedure", procedure),
+		zap.String("principal", principal),
+		zap.Duration("retry_after", wait),
+	)
+	err := connect.NewError(connect.CodeResourceExhausted, fmt.Errorf("rate limit exceeded for %s, retry in %s", procedure, wait.Round(time.Millisecond)))
+	err.Meta().Set(retryAfterHeader, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return err
+}
+
+func (i *RateLimitInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(
+		ctx context.Context,
+		req connect.AnyRequest,
+	) (connect.AnyResponse, error) {
+		if err := i.check(ctx, req.Spec().Procedure); err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+func (i *RateLimitInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	// This is a no-op because we don't care about the client side in the server
+	return func(
+		ctx context.Context,
+		spec connect.Spec,
+	) connect.StreamingClientConn {
+		return next(ctx, spec)
+	}
+}
+
+func (i *RateLimitInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(
+		ctx context.Context,
+		conn connect.StreamingHandlerConn,
+	) error {
+		// Opening a stream counts as a request, and the stream against the open streams of the caller
+		procedure := conn.Spec().Procedure
+		if err := i.check(ctx, procedure); err != nil {
+			return err
+		}
+		principal := auth.PrincipalFromContext(ctx)
+		if !i.openStream(principal) {
+			zap.L().Warn("Refused stream over the limit",
+				zap.String("procedure", procedure),
+				zap.String("principal", principal),
+				zap.Int("max_streams", i.config.MaxStreams),
+			)
+			return connect.NewError(connect.CodeResourceExhausted, fmt.Errorf("too many open streams, at most %d are allowed", i.config.MaxStreams))
+		}
+		defer i.closeStream(principal)
+
+		return next(ctx, conn)
+	}
+}
@@ -0,0 +1,261 @@
+package interceptor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+
+	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/crud/v1/crudv1connect"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+	"github.com/serbanmarti/go-grpc/server/broadcast"
+	"github.com/serbanmarti/go-grpc/server/service"
+)
+
+// testClock is a manually advanced clock
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits("/crud.v1.CrudService/Create=1:5, /crud.v1.CrudService/Delete=0.5:2,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]RateLimit{
+		"/crud.v1.CrudService/Create": {Rate: 1, Burst: 5},
+		"/crud.v1.CrudService/Delete": {Rate: 0.5, Burst: 2},
+	}, limits)
+
+	limits, err = ParseRateLimits("")
+	assert.NoError(t, err)
+	assert.Empty(t, limits)
+
+	for _, value := range []string{"Create=1:5", "/crud.v1.CrudService/Create=1", "/crud.v1.CrudService/Create=fast:5", "/crud.v1.CrudService/Create=1:many"} {
+		_, err = ParseRateLimits(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestRateLimitInterceptor_Allow(t *testing.T) {
+	i, err := NewRateLimitInterceptor(RateLimitConfig{
+		Caller: RateLimit{Rate: 10, Burst: 5},
+		Procedures: map[string]RateLimit{
+			"/crud.v1.CrudService/Create": {Rate: 1, Burst: 2},
+		},
+		IdleTimeout: time.Minute,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer i.Close()
+	clock := &testClock{now: time.Now()}
+	i.now = clock.Now
+
+	// The procedure bucket runs out first, without taking tokens from the caller bucket when refusing
+	for n := 0; n < 2; n++ {
+		_, ok := i.allow("alice", "/crud.v1.CrudService/Create")
+		assert.True(t, ok)
+	}
+	wait, ok := i.allow("alice", "/crud.v1.CrudService/Create")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	// Then the caller bucket, shared by all procedures
+	for n := 0; n < 3; n++ {
+		_, ok := i.allow("alice", "/crud.v1.CrudService/Read")
+		assert.True(t, ok)
+	}
+	wait, ok = i.allow("alice", "/crud.v1.CrudService/Read")
+	assert.False(t, ok)
+	assert.Equal(t, 100*time.Millisecond, wait)
+
+	// Other callers have their own buckets
+	_, ok = i.allow("bob", "/crud.v1.CrudService/Create")
+	assert.True(t, ok)
+
+	// The buckets refill over time
+	clock.now = clock.now.Add(time.Second)
+	_, ok = i.allow("alice", "/crud.v1.CrudService/Create")
+	assert.True(t, ok)
+
+	// Idle buckets are evicted once refilled
+	assert.Equal(t, 0, i.evict())
+	clock.now = clock.now.Add(time.Minute)
+	assert.Equal(t, 4, i.evict())
+	assert.Empty(t, i.buckets)
+
+	_, err = NewRateLimitInterceptor(RateLimitConfig{Caller: RateLimit{Rate: 1}})
+	assert.Error(t, err)
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	rateLimit, err := NewRateLimitInterceptor(RateLimitConfig{
+		Procedures: map[string]RateLimit{
+			"/crud.v1.CrudService/Create": {Rate: 0.5, Burst: 1},
+		},
+		MaxStreams: 1,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer rateLimit.Close()
+
+	broker := broadcast.NewBroker(1)
+	_, _, err = broker.Publish("admin", "news", "Hello")
+	assert.NoError(t, err)
+
+	interceptors := connect.WithInterceptors(&subjectInterceptor{}, rateLimit)
+	mux := http.NewServeMux()
+	mux.Handle(crudv1connect.NewCrudServiceHandler(&service.CrudService{
+		Data: make(map[string]string),
+	}, interceptors))
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&service.StreamService{
+		Broker:      broker,
+		MaxLifetime: time.Minute,
+	}, interceptors))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Run("Unary", func(t *testing.T) {
+		client := crudv1connect.NewCrudServiceClient(server.Client(), server.URL)
+		create := func(subject string) error {
+			req := connect.NewRequest(&crudv1.CreateRequest{Name: "Test Record"})
+			req.Header().Set("x-client-id", subject)
+			_, err := client.Create(context.Background(), req)
+			return err
+		}
+
+		assert.NoError(t, create("alice"))
+		err := create("alice")
+		assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+		var connectErr *connect.Error
+		if assert.ErrorAs(t, err, &connectErr) {
+			assert.Equal(t, "2", connectErr.Meta().Get(retryAfterHeader))
+		}
+
+		// Other callers are not limited by alice's requests
+		assert.NoError(t, create("bob"))
+	})
+
+	t.Run("Streams", func(t *testing.T) {
+		client := streamv1connect.NewStreamServiceClient(server.Client(), server.URL)
+		subscribe := func(ctx context.Context) (*connect.ServerStreamForClient[streamv1.Announcement], error) {
+			req := connect.NewRequest(&streamv1.SubscribeRequest{Topics: []string{"news"}})
+			req.Header().Set("x-client-id", "alice")
+			stream, err := client.Subscribe(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			// The retained announcement tells the stream is open
+			if !stream.Receive() {
+				return nil, stream.Err()
+			}
+			return stream, nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		first, err := subscribe(ctx)
+		if !assert.NoError(t, err) {
+			cancel()
+			t.FailNow()
+		}
+
+		_, err = subscribe(context.Background())
+		assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+
+		// Once the first stream is closed, another one may be opened
+		cancel()
+		_ = first.Close()
+		assert.Eventually(t, func() bool {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			_, err := subscribe(ctx)
+			return err == nil
+		}, 2*time.Second, 20*time.Millisecond)
+	})
+}
+
+func TestRateLimitInterceptorSharedSecret(t *testing.T) {
+	rateLimit, err := NewRateLimitInterceptor(RateLimitConfig{
+		Procedures: map[string]RateLimit{
+			"/crud.v1.CrudService/Create": {Rate: 0.5, Burst: 1},
+		},
+		MaxStreams: 1,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer rateLimit.Close()
+
+	broker := broadcast.NewBroker(1)
+	_, _, err = broker.Publish("admin", "news", "Hello")
+	assert.NoError(t, err)
+
+	authn := &AuthInterceptor{secret: "secret", header: "x-auth-token", clientIDHeader: "x-client-id"}
+	interceptors := connect.WithInterceptors(authn, rateLimit)
+	mux := http.NewServeMux()
+	mux.Handle(crudv1connect.NewCrudServiceHandler(&service.CrudService{
+		Data: make(map[string]string),
+	}, interceptors))
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&service.StreamService{
+		Broker:      broker,
+		MaxLifetime: time.Minute,
+	}, interceptors))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// The callers of the shared secret share the same bucket, whatever name they give
+	crud := crudv1connect.NewCrudServiceClient(server.Client(), server.URL)
+	create := func(clientID string) error {
+		req := connect.NewRequest(&crudv1.CreateRequest{Name: "Test Record"})
+		req.Header().Set("x-auth-token", "secret")
+		req.Header().Set("x-client-id", clientID)
+		_, err := crud.Create(context.Background(), req)
+		return err
+	}
+	assert.NoError(t, create("alice"))
+	for _, clientID := range []string{"bob", "carol", "key:alice"} {
+		assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(create(clientID)), clientID)
+	}
+	rateLimit.mutex.Lock()
+	assert.Len(t, rateLimit.buckets, 1)
+	rateLimit.mutex.Unlock()
+
+	// And the same open streams
+	stream := streamv1connect.NewStreamServiceClient(server.Client(), server.URL)
+	subscribe := func(ctx context.Context, clientID string) (*connect.ServerStreamForClient[streamv1.Announcement], error) {
+		req := connect.NewRequest(&streamv1.SubscribeRequest{Topics: []string{"news"}})
+		req.Header().Set("x-auth-token", "secret")
+		req.Header().Set("x-client-id", clientID)
+		s, err := stream.Subscribe(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		// The retained announcement tells the stream is open
+		if !s.Receive() {
+			return nil, s.Err()
+		}
+		return s, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first, err := subscribe(ctx, "alice")
+	if !assert.NoError(t, err) {
+		cancel()
+		t.FailNow()
+	}
+	_, err = subscribe(context.Background(), "bob")
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+
+	cancel()
+	_ = first.Close()
+}
@@ -3,15 +3,59 @@ package interceptor
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"connectrpc.com/connect"
 	"go.uber.org/zap"
+
+	"github.com/serbanmarti/go-grpc/server/crash"
+	"github.com/serbanmarti/go-grpc/server/metrics"
 )
 
-type RecoveryInterceptor struct{}
+// RecoveryInterceptor recovers from the panics of the handlers, and of the goroutines they start through crash.Guard,
+// failing their request with an internal error
+// The panics are logged with their stack trace, the request ID and the procedure, counted,
+// and passed to the crash reporter (if any)
+type RecoveryInterceptor struct {
+	reporter crash.Reporter
+	panics   *metrics.CounterVec
+}
+
+// NewRecoveryInterceptor creates the interceptor, registering its metrics in the registry
+// The reporter may be nil, the panics being only logged and counted
+func NewRecoveryInterceptor(registry *metrics.Registry, reporter crash.Reporter) *RecoveryInterceptor {
+	return &RecoveryInterceptor{
+		reporter: reporter,
+		panics: registry.NewCounterVec(
+			"rpc_panics_total",
+			"Number of panics recovered from the handlers, by procedure.",
+			"procedure",
+		),
+	}
+}
 
-func NewRecoveryInterceptor() *RecoveryInterceptor {
-	return &RecoveryInterceptor{}
+// withHandler returns a copy of the context carrying the handler of the panics of the request
+func (i *RecoveryInterceptor) withHandler(ctx context.Context, procedure string) context.Context {
+	return crash.NewContext(ctx, func(value any, stack []byte) {
+		requestID := RequestIDFromContext(ctx)
+		zap.L().Error(
+			"recovered from panic",
+			zap.Any("panic", value),
+			zap.String("procedure", procedure),
+			zap.String("request_id", requestID),
+			zap.ByteString("stack", stack),
+		)
+		i.panics.Inc(procedure)
+		if i.reporter != nil {
+			i.reporter.ReportCrash(crash.Crash{
+				Time:      time.Now(),
+				Procedure: procedure,
+				RequestID: requestID,
+				Value:     fmt.Sprint(value),
+				Stack:     stack,
+			})
+		}
+	})
 }
 
 func (i *RecoveryInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
@@ -19,14 +63,16 @@ func (i *RecoveryInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFun
 		ctx context.Context,
 		req connect.AnyRequest,
 	) (res connect.AnyResponse, err error) {
-		defer func() {
-			// Recover from any panics and return an internal server error
-			if r := recover(); r != nil {
-				zap.L().Error("recovered from panic", zap.Any("panic", r))
-				err = connect.NewError(connect.CodeInternal, fmt.Errorf("unexpected server error"))
-			}
-		}()
-		return next(ctx, req)
+		// Recover from any panics and return an internal server error
+		ctx = i.withHandler(ctx, req.Spec().Procedure)
+		err = crash.Guard(ctx, func() error {
+			res, err = next(ctx, req)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
 	}
 }
 
@@ -44,14 +90,11 @@ func (i *RecoveryInterceptor) WrapStreamingHandler(next connect.StreamingHandler
 	return func(
 		ctx context.Context,
 		conn connect.StreamingHandlerConn,
-	) (err error) {
-		defer func() {
-			// Recover from any panics and return an internal server error
-			if r := recover(); r != nil {
-				zap.L().Error("recovered from panic", zap.Any("panic", r))
-				err = connect.NewError(connect.CodeInternal, fmt.Errorf("unexpected server error"))
-			}
-		}()
-		return next(ctx, conn)
+	) error {
+		// Recover from any panics and return an internal server error
+		ctx = i.withHandler(ctx, conn.Spec().Procedure)
+		return crash.Guard(ctx, func() error {
+			return next(ctx, conn)
+		})
 	}
 }
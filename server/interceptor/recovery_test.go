@@ -0,0 +1,134 @@
+package interceptor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
+	"github.com/serbanmarti/go-grpc/server/crash"
+	"github.com/serbanmarti/go-grpc/server/metrics"
+)
+
+const (
+	panicUnaryProcedure     = "/test.v1.PanicService/Unary"
+	panicStreamProcedure    = "/test.v1.PanicService/Stream"
+	panicGoroutineProcedure = "/test.v1.PanicService/Goroutine"
+)
+
+// crashRecorder keeps the crashes reported
+type crashRecorder struct {
+	mutex   sync.Mutex
+	crashes []crash.Crash
+}
+
+func (r *crashRecorder) ReportCrash(c crash.Crash) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.crashes = append(r.crashes, c)
+}
+
+func (r *crashRecorder) all() []crash.Crash {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]crash.Crash(nil), r.crashes...)
+}
+
+// panicUnary panics while handling a unary request
+func panicUnary(context.Context, *connect.Request[crudv1.ReadRequest]) (*connect.Response[crudv1.ReadResponse], error) {
+	panic("unary boom")
+}
+
+// panicStream panics after sending a first message of a stream
+func panicStream(_ context.Context, _ *connect.Request[crudv1.ReadRequest], stream *connect.ServerStream[crudv1.ReadResponse]) error {
+	if err := stream.Send(&crudv1.ReadResponse{Id: "first"}); err != nil {
+		return err
+	}
+	panic("stream boom")
+}
+
+// panicGoroutine panics in a goroutine started by the handler, guarded as the services do
+func panicGoroutine(ctx context.Context, _ *connect.Request[crudv1.ReadRequest], _ *connect.ServerStream[crudv1.ReadResponse]) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- crash.Guard(ctx, func() error {
+			panic("goroutine boom")
+		})
+	}()
+	return <-done
+}
+
+func TestRecoveryInterceptor(t *testing.T) {
+	registry := metrics.NewRegistry()
+	reporter := &crashRecorder{}
+	interceptors := connect.WithInterceptors(NewRequestIDInterceptor(), NewRecoveryInterceptor(registry, reporter))
+
+	mux := http.NewServeMux()
+	mux.Handle(panicUnaryProcedure, connect.NewUnaryHandler(panicUnaryProcedure, panicUnary, interceptors))
+	mux.Handle(panicStreamProcedure, connect.NewServerStreamHandler(panicStreamProcedure, panicStream, interceptors))
+	mux.Handle(panicGoroutineProcedure, connect.NewServerStreamHandler(panicGoroutineProcedure, panicGoroutine, interceptors))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// A panic of a unary handler fails the request with an internal error
+	unary := connect.NewClient[crudv1.ReadRequest, crudv1.ReadResponse](server.Client(), server.URL+panicUnaryProcedure)
+	req := connect.NewRequest(&crudv1.ReadRequest{Id: "record"})
+	req.Header().Set(RequestIDHeader, "unary-request")
+	_, err := unary.CallUnary(context.Background(), req)
+	assert.Equal(t, connect.CodeInternal, connect.CodeOf(err))
+	assert.Contains(t, err.Error(), "unexpected server error")
+
+	// A panic of a streaming handler ends the stream with an internal error, after the messages already sent
+	stream := connect.NewClient[crudv1.ReadRequest, crudv1.ReadResponse](server.Client(), server.URL+panicStreamProcedure)
+	req = connect.NewRequest(&crudv1.ReadRequest{Id: "record"})
+	req.Header().Set(RequestIDHeader, "stream-request")
+	res, err := stream.CallServerStream(context.Background(), req)
+	require.NoError(t, err)
+	assert.True(t, res.Receive())
+	assert.Equal(t, "first", res.Msg().Id)
+	assert.False(t, res.Receive())
+	assert.Equal(t, connect.CodeInternal, connect.CodeOf(res.Err()))
+	assert.NoError(t, res.Close())
+
+	// A panic of a goroutine started by the handler is recovered as well, rather than crashing the server
+	goroutine := connect.NewClient[crudv1.ReadRequest, crudv1.ReadResponse](server.Client(), server.URL+panicGoroutineProcedure)
+	req = connect.NewRequest(&crudv1.ReadRequest{Id: "record"})
+	req.Header().Set(RequestIDHeader, "goroutine-request")
+	res, err = goroutine.CallServerStream(context.Background(), req)
+	require.NoError(t, err)
+	assert.False(t, res.Receive())
+	assert.Equal(t, connect.CodeInternal, connect.CodeOf(res.Err()))
+	assert.NoError(t, res.Close())
+
+	// Each panic is reported with its request ID, its procedure and the stack of the panic
+	crashes := reporter.all()
+	require.Len(t, crashes, 3)
+	expected := []struct {
+		procedure, requestID, value, function string
+	}{
+		{panicUnaryProcedure, "unary-request", "unary boom", "interceptor.panicUnary"},
+		{panicStreamProcedure, "stream-request", "stream boom", "interceptor.panicStream"},
+		{panicGoroutineProcedure, "goroutine-request", "goroutine boom", "interceptor.panicGoroutine"},
+	}
+	for i, c := range crashes {
+		assert.Equal(t, expected[i].procedure, c.Procedure)
+		assert.Equal(t, expected[i].requestID, c.RequestID)
+		assert.Equal(t, expected[i].value, c.Value)
+		assert.Contains(t, string(c.Stack), expected[i].function)
+		assert.False(t, c.Time.IsZero())
+	}
+
+	// The panics are counted by procedure
+	var out strings.Builder
+	require.NoError(t, registry.WriteText(&out))
+	for _, procedure := range []string{panicUnaryProcedure, panicStreamProcedure, panicGoroutineProcedure} {
+		assert.Contains(t, out.String(), `rpc_panics_total{procedure="`+procedure+`"} 1`)
+	}
+}
@@ -0,0 +1,104 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+
+	"connectrpc.com/connect"
+	"github.com/segmentio/ksuid"
+)
+
+// RequestIDHeader is the header carrying the request ID, sent back in the response headers and trailers
+const RequestIDHeader = "x-request-id"
+
+// maxRequestIDLength is the length of the longest request ID accepted from the callers
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID of the request stored in the context, or an empty string if there is none
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDInterceptor identifies each request, with the ID given by the caller or a generated one,
+// so the caller can report it and the logs of the request can be found
+// It must run before the LoggerInterceptor and the RecoveryInterceptor, which log the request ID
+type RequestIDInterceptor struct{}
+
+func NewRequestIDInterceptor() *RequestIDInterceptor {
+	return &RequestIDInterceptor{}
+}
+
+// requestID returns the ID given by the caller if it is valid, a new one otherwise
+func requestID(given string) string {
+	if validRequestID(given) {
+		return given
+	}
+	return ksuid.New().String()
+}
+
+// validRequestID reports whether a request ID is safe to log and send back: short, and made of printable ASCII
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID adds the request ID to the metadata of an error, sent back in the headers and trailers of unary requests
+func withRequestID(err error, id string) error {
+	var connectErr *connect.Error
+	if !errors.As(err, &connectErr) {
+		connectErr = connect.NewError(connect.CodeUnknown, err)
+		err = connectErr
+	}
+	connectErr.Meta().Set(RequestIDHeader, id)
+	return err
+}
+
+func (i *RequestIDInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(
+		ctx context.Context,
+		req connect.AnyRequest,
+	) (connect.AnyResponse, error) {
+		id := requestID(req.Header().Get(RequestIDHeader))
+		res, err := next(context.WithValue(ctx, requestIDKey{}, id), req)
+		if err != nil {
+			return nil, withRequestID(err, id)
+		}
+		res.Header().Set(RequestIDHeader, id)
+		res.Trailer().Set(RequestIDHeader, id)
+		return res, nil
+	}
+}
+
+func (i *RequestIDInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	// This is a no-op because we don't care about the client side in the server
+	return func(
+		ctx context.Context,
+		spec connect.Spec,
+	) connect.StreamingClientConn {
+		return next(ctx, spec)
+	}
+}
+
+func (i *RequestIDInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(
+		ctx context.Context,
+		conn connect.StreamingHandlerConn,
+	) error {
+		// The headers are sent along with the first message, so they must be set before running the handler,
+		// and they are sent along with the trailers (which carry the errors) if the stream fails before
+		id := requestID(conn.RequestHeader().Get(RequestIDHeader))
+		conn.ResponseHeader().Set(RequestIDHeader, id)
+		conn.ResponseTrailer().Set(RequestIDHeader, id)
+		return next(context.WithValue(ctx, requestIDKey{}, id), conn)
+	}
+}
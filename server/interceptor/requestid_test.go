@@ -0,0 +1,99 @@
+package interceptor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+
+	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/crud/v1/crudv1connect"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+	"github.com/serbanmarti/go-grpc/server/broadcast"
+	"github.com/serbanmarti/go-grpc/server/service"
+)
+
+func TestRequestIDInterceptor(t *testing.T) {
+	broker := broadcast.NewBroker(1)
+	_, _, err := broker.Publish("admin", "news", "Hello")
+	assert.NoError(t, err)
+
+	interceptors := connect.WithInterceptors(NewRequestIDInterceptor())
+	mux := http.NewServeMux()
+	mux.Handle(crudv1connect.NewCrudServiceHandler(&service.CrudService{
+		Data: map[string]string{"record": "Test Record"},
+	}, interceptors))
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&service.StreamService{
+		Broker:      broker,
+		MaxLifetime: time.Minute,
+	}, interceptors))
+	// gRPC needs HTTP/2
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	for _, protocol := range []struct {
+		name   string
+		option connect.ClientOption
+	}{
+		{name: "Connect", option: connect.WithProtoJSON()},
+		{name: "gRPC", option: connect.WithGRPC()},
+	} {
+		t.Run(protocol.name, func(t *testing.T) {
+			crud := crudv1connect.NewCrudServiceClient(server.Client(), server.URL, protocol.option)
+			read := func(id, requestID string) (*connect.Response[crudv1.ReadResponse], error) {
+				req := connect.NewRequest(&crudv1.ReadRequest{Id: id})
+				if requestID != "" {
+					req.Header().Set(RequestIDHeader, requestID)
+				}
+				return crud.Read(context.Background(), req)
+			}
+
+			// A new ID is generated for the requests without one, and sent back in the headers and trailers
+			res, err := read("record", "")
+			if assert.NoError(t, err) {
+				assert.NotEmpty(t, res.Header().Get(RequestIDHeader))
+				assert.Equal(t, res.Header().Get(RequestIDHeader), res.Trailer().Get(RequestIDHeader))
+			}
+
+			// The ID given by the caller is kept, and sent back with the errors as well
+			_, err = read("missing", "report-me-42")
+			var connectErr *connect.Error
+			if assert.ErrorAs(t, err, &connectErr) {
+				assert.Equal(t, connect.CodeNotFound, connectErr.Code())
+				assert.Equal(t, "report-me-42", connectErr.Meta().Get(RequestIDHeader))
+			}
+
+			// Invalid IDs are replaced
+			res, err = read("record", strings.Repeat("x", maxRequestIDLength+1))
+			if assert.NoError(t, err) {
+				assert.Len(t, res.Header().Get(RequestIDHeader), 27)
+			}
+
+			// Streams send the ID in their headers and trailers
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream, err := streamv1connect.NewStreamServiceClient(server.Client(), server.URL, protocol.option).Subscribe(
+				ctx,
+				connect.NewRequest(&streamv1.SubscribeRequest{Topics: []string{"news"}}),
+			)
+			assert.NoError(t, err)
+			assert.True(t, stream.Receive())
+			assert.NotEmpty(t, stream.ResponseHeader().Get(RequestIDHeader))
+			cancel()
+			_ = stream.Close()
+		})
+	}
+
+	for _, id := range []string{"", "has space", "tab\there", "ünicode", strings.Repeat("x", maxRequestIDLength+1)} {
+		assert.False(t, validRequestID(id), id)
+	}
+	assert.True(t, validRequestID("0a1b2c3d-trace:42"))
+}
@@ -0,0 +1,103 @@
+package interceptor
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"connectrpc.com/connect"
+)
+
+// StreamBytesInterceptor bounds the total size of the messages received on the streams of the given procedures,
+// once decoded, failing the next receive with ResourceExhausted once it is exceeded
+// The size of each message is bounded by the read limit of its service, this bounds the stream as a whole
+// (e.g. the size of an uploaded file); the streams of other procedures (e.g. long-lived chats) are not bounded
+type StreamBytesInterceptor struct {
+	limits map[string]int64
+}
+
+// NewStreamBytesInterceptor bounds the streams of each procedure to its maximum number of bytes, zero leaving it unbounded
+func NewStreamBytesInterceptor(limits map[string]int64) *StreamBytesInterceptor {
+	return &StreamBytesInterceptor{limits: limits}
+}
+
+// ParseStreamBytesLimits parses the per-procedure limits, given as a comma-separated list of procedure=bytes,
+// e.g. "/stream.v1.StreamService/UploadFile=1073741824,/stream.v1.StreamService/DirectMessage=0"
+func ParseStreamBytesLimits(value string) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		procedure, limit, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(procedure, "/") {
+			return nil, fmt.Errorf("invalid stream limit %q, expecting procedure=bytes", entry)
+		}
+		maxBytes, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bytes in %q: %w", entry, err)
+		}
+		if maxBytes < 0 {
+			return nil, fmt.Errorf("invalid bytes in %q, must not be negative", entry)
+		}
+		limits[procedure] = maxBytes
+	}
+	return limits, nil
+}
+
+func (i *StreamBytesInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	// This is a no-op because unary requests are bounded by the read limit of their service
+	return func(
+		ctx context.Context,
+		req connect.AnyRequest,
+	) (connect.AnyResponse, error) {
+		return next(ctx, req)
+	}
+}
+
+func (i *StreamBytesInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	// This is a no-op because we don't care about the client side in the server
+	return func(
+		ctx context.Context,
+		spec connect.Spec,
+	) connect.StreamingClientConn {
+		return next(ctx, spec)
+	}
+}
+
+func (i *StreamBytesInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(
+		ctx context.Context,
+		conn connect.StreamingHandlerConn,
+	) error {
+		maxBytes := i.limits[conn.Spec().Procedure]
+		if maxBytes <= 0 {
+			return next(ctx, conn)
+		}
+		return next(ctx, &boundedHandlerConn{StreamingHandlerConn: conn, maxBytes: maxBytes})
+	}
+}
+
+// boundedHandlerConn fails the receives once the messages received add up to more than the maximum
+// A stream is only received from a single goroutine, so the count needs no lock
+type boundedHandlerConn struct {
+	connect.StreamingHandlerConn
+	maxBytes int64
+	received int64
+}
+
+func (c *boundedHandlerConn) Receive(msg any) error {
+	if err := c.StreamingHandlerConn.Receive(msg); err != nil {
+		return err
+	}
+	c.received += int64(messageSize(msg))
+	if c.received > c.maxBytes {
+		return connect.NewError(
+			connect.CodeResourceExhausted,
+			fmt.Errorf("stream exceeds the maximum of %d bytes received (%d bytes so far)", c.maxBytes, c.received),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package interceptor
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+	"github.com/serbanmarti/go-grpc/server/service"
+	"github.com/serbanmarti/go-grpc/server/storage"
+)
+
+// streamBytesProcedure is a client stream not bounded by the interceptor
+const streamBytesProcedure = "/test.v1.StreamBytesService/Receive"
+
+// countReceived answers with the number of bytes received on the stream
+func countReceived(ctx context.Context, stream *connect.ClientStream[streamv1.UploadFileRequest]) (*connect.Response[streamv1.UploadFileResponse], error) {
+	var size uint64
+	for stream.Receive() {
+		size += uint64(len(stream.Msg().GetChunk()))
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&streamv1.UploadFileResponse{Size: size}), nil
+}
+
+func TestParseStreamBytesLimits(t *testing.T) {
+	limits, err := ParseStreamBytesLimits(" /stream.v1.StreamService/UploadFile=1000, /stream.v1.StreamService/DirectMessage=0 ,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{
+		"/stream.v1.StreamService/UploadFile":    1000,
+		"/stream.v1.StreamService/DirectMessage": 0,
+	}, limits)
+
+	for _, value := range []string{"UploadFile=1000", "/stream.v1.StreamService/UploadFile", "/stream.v1.StreamService/UploadFile=1k", "/stream.v1.StreamService/UploadFile=-1"} {
+		_, err := ParseStreamBytesLimits(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestStreamBytesInterceptor(t *testing.T) {
+	files, err := storage.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	interceptors := connect.WithInterceptors(NewStreamBytesInterceptor(map[string]int64{
+		streamv1connect.StreamServiceUploadFileProcedure: 1000,
+	}))
+	mux := http.NewServeMux()
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&service.StreamService{Files: files}, interceptors))
+	mux.Handle(streamBytesProcedure, connect.NewClientStreamHandler(streamBytesProcedure, countReceived, interceptors))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	client := streamv1connect.NewStreamServiceClient(server.Client(), server.URL)
+
+	// upload uploads a file in chunks of the given sizes
+	upload := func(name string, sizes ...int) (*connect.Response[streamv1.UploadFileResponse], error) {
+		stream := client.UploadFile(context.Background())
+		for _, size := range sizes {
+			err := stream.Send(&streamv1.UploadFileRequest{FileName: name, Chunk: bytes.Repeat([]byte("x"), size)})
+			if err != nil {
+				break
+			}
+		}
+		return stream.CloseAndReceive()
+	}
+
+	// Streams within the limit go through
+	res, err := upload("small.txt", 400, 400)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(800), res.Msg.Size)
+
+	// Larger ones fail once the limit is exceeded, even though each of their messages is small
+	_, err = upload("large.txt", 400, 400, 400)
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+	assert.Contains(t, err.Error(), "stream exceeds the maximum of 1000 bytes received")
+
+	// Nothing is stored from the failed upload
+	count, _ := files.Usage()
+	assert.Equal(t, 1, count)
+
+	// The streams of other procedures are not bounded
+	other := connect.NewClient[streamv1.UploadFileRequest, streamv1.UploadFileResponse](server.Client(), server.URL+streamBytesProcedure).CallClientStream(context.Background())
+	for range 3 {
+		require.NoError(t, other.Send(&streamv1.UploadFileRequest{Chunk: bytes.Repeat([]byte("x"), 400)}))
+	}
+	res, err = other.CloseAndReceive()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1200), res.Msg.Size)
+}
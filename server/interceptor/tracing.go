@@ -0,0 +1,210 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+
+	"github.com/serbanmarti/go-grpc/server/tracing"
+)
+
+const (
+	// messageBatchSize and messageBatchInterval bound the stream messages recorded by a single span
+	messageBatchSize     = 100
+	messageBatchInterval = time.Second
+)
+
+// TracingInterceptor records a span for each request, continuing the trace propagated by the caller in its
+// traceparent and tracestate headers, along with spans for the batches of messages of the streams
+// On the client side, it propagates the trace of the context (or a new one) to the server
+// It must run before the LoggerInterceptor, which logs the trace ID of the requests
+type TracingInterceptor struct {
+	tracer *tracing.Tracer
+}
+
+func NewTracingInterceptor(tracer *tracing.Tracer) *TracingInterceptor {
+	return &TracingInterceptor{tracer: tracer}
+}
+
+// rpcAttributes describes the procedure and the peer of a request
+func rpcAttributes(spec connect.Spec, peer connect.Peer) []tracing.Attribute {
+	service, method, _ := strings.Cut(strings.TrimPrefix(spec.Procedure, "/"), "/")
+	peerKey := "client.address"
+	if spec.IsClient {
+		peerKey = "server.address"
+	}
+	return []tracing.Attribute{
+		{Key: "rpc.system", Value: "connect_rpc"},
+		{Key: "rpc.service", Value: service},
+		{Key: "rpc.method", Value: method},
+		{Key: "rpc.protocol", Value: peer.Protocol},
+		{Key: peerKey, Value: peer.Addr},
+	}
+}
+
+// endSpan ends the span of a request, with the error it failed with, if any
+func endSpan(span *tracing.Span, err error) {
+	if err != nil {
+		span.SetAttributes(tracing.Attribute{Key: "rpc.connect_rpc.error_code", Value: connect.CodeOf(err).String()})
+		span.SetStatus(tracing.StatusError, err.Error())
+	}
+	span.End()
+}
+
+// startSpan starts the span of a request: a server span continuing the trace of the headers,
+// or a client span continuing the trace of the context, propagated in the headers
+func (i *TracingInterceptor) startSpan(ctx context.Context, spec connect.Spec, peer connect.Peer, header http.Header) (context.Context, *tracing.Span) {
+	var span *tracing.Span
+	if spec.IsClient {
+		var parent tracing.SpanContext
+		if current, ok := tracing.SpanFromContext(ctx); ok {
+			parent = current.Context
+		}
+		span = i.tracer.Start(spec.Procedure, tracing.SpanKindClient, parent)
+		tracing.Inject(header, span.Context)
+	} else {
+		parent, _ := tracing.Extract(header)
+		span = i.tracer.Start(spec.Procedure, tracing.SpanKindServer, parent)
+	}
+	span.SetAttributes(rpcAttributes(spec, peer)...)
+	return tracing.ContextWithSpan(ctx, span), span
+}
+
+func (i *TracingInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(
+		ctx context.Context,
+		req connect.AnyRequest,
+	) (connect.AnyResponse, error) {
+		ctx, span := i.startSpan(ctx, req.Spec(), req.Peer(), req.Header())
+		res, err := next(ctx, req)
+		endSpan(span, err)
+		return res, err
+	}
+}
+
+func (i *TracingInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(
+		ctx context.Context,
+		spec connect.Spec,
+	) connect.StreamingClientConn {
+		conn := next(ctx, spec)
+		_, span := i.startSpan(ctx, spec, conn.Peer(), conn.RequestHeader())
+		return &tracedClientConn{StreamingClientConn: conn, span: span}
+	}
+}
+
+func (i *TracingInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(
+		ctx context.Context,
+		conn connect.StreamingHandlerConn,
+	) error {
+		ctx, span := i.startSpan(ctx, conn.Spec(), conn.Peer(), conn.RequestHeader())
+		traced := &tracedHandlerConn{StreamingHandlerConn: conn, tracer: i.tracer, stream: span}
+		err := next(ctx, traced)
+		traced.endBatch()
+		endSpan(span, err)
+		return err
+	}
+}
+
+// tracedHandlerConn records the messages of a stream in batch spans, children of the span of the stream
+// A batch ends once it holds messageBatchSize messages, once a message comes messageBatchInterval after it started,
+// or with the stream
+type tracedHandlerConn struct {
+	connect.StreamingHandlerConn
+	tracer *tracing.Tracer
+	stream *tracing.Span
+
+	mutex    sync.Mutex
+	batch    *tracing.Span
+	received int
+	sent     int
+}
+
+// message records a message in the current batch, starting one if needed
+func (c *tracedHandlerConn) message(received bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.batch == nil {
+		c.batch = c.tracer.Start(c.stream.Name+" messages", tracing.SpanKindInternal, c.stream.Context)
+	}
+	if received {
+		c.received++
+	} else {
+		c.sent++
+	}
+	if c.received+c.sent >= messageBatchSize || time.Since(c.batch.StartTime) >= messageBatchInterval {
+		c.endBatchLocked()
+	}
+}
+
+// endBatch ends the current batch, if any
+func (c *tracedHandlerConn) endBatch() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.endBatchLocked()
+}
+
+// endBatchLocked ends the current batch, if any; the mutex must be held
+func (c *tracedHandlerConn) endBatchLocked() {
+	if c.batch == nil {
+		return
+	}
+	c.batch.SetAttributes(
+		tracing.Attribute{Key: "messages.received", Value: c.received},
+		tracing.Attribute{Key: "messages.sent", Value: c.sent},
+	)
+	c.batch.End()
+	c.batch, c.received, c.sent = nil, 0, 0
+}
+
+func (c *tracedHandlerConn) Receive(msg any) error {
+	err := c.StreamingHandlerConn.Receive(msg)
+	if err == nil {
+		c.message(true)
+	}
+	return err
+}
+
+func (c *tracedHandlerConn) Send(msg any) error {
+	err := c.StreamingHandlerConn.Send(msg)
+	if err == nil {
+		c.message(false)
+	}
+	return err
+}
+
+// tracedClientConn ends the span of a client stream once its response is closed
+type tracedClientConn struct {
+	connect.StreamingClientConn
+	span *tracing.Span
+
+	mutex sync.Mutex
+	err   error
+}
+
+func (c *tracedClientConn) Receive(msg any) error {
+	err := c.StreamingClientConn.Receive(msg)
+	if err != nil && !errors.Is(err, io.EOF) {
+		c.mutex.Lock()
+		c.err = err
+		c.mutex.Unlock()
+	}
+	return err
+}
+
+func (c *tracedClientConn) CloseResponse() error {
+	err := c.StreamingClientConn.CloseResponse()
+	c.mutex.Lock()
+	endSpan(c.span, c.err)
+	c.mutex.Unlock()
+	return err
+}
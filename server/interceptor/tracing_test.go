@@ -0,0 +1,127 @@
+package interceptor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+
+	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/crud/v1/crudv1connect"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+	"github.com/serbanmarti/go-grpc/server/broadcast"
+	"github.com/serbanmarti/go-grpc/server/service"
+	"github.com/serbanmarti/go-grpc/server/tracing"
+)
+
+// spanRecorder keeps the ended spans in memory
+type spanRecorder struct {
+	mutex sync.Mutex
+	spans []*tracing.Span
+}
+
+func (r *spanRecorder) ExportSpan(span *tracing.Span) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+// find returns the ended spans with the given name and kind
+func (r *spanRecorder) find(name string, kind tracing.SpanKind) []*tracing.Span {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var spans []*tracing.Span
+	for _, span := range r.spans {
+		if span.Name == name && span.Kind == kind {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func TestTracingInterceptor(t *testing.T) {
+	recorder := &spanRecorder{}
+	tracer := tracing.NewTracer(recorder, 1)
+	broker := broadcast.NewBroker(1)
+	_, _, err := broker.Publish("admin", "news", "Hello")
+	assert.NoError(t, err)
+
+	interceptors := connect.WithInterceptors(NewTracingInterceptor(tracer))
+	mux := http.NewServeMux()
+	mux.Handle(crudv1connect.NewCrudServiceHandler(&service.CrudService{
+		Data: map[string]string{"record": "Test Record"},
+	}, interceptors))
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&service.StreamService{
+		Broker:      broker,
+		MaxLifetime: time.Minute,
+	}, interceptors))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Run("Continues the trace of the caller", func(t *testing.T) {
+		client := crudv1connect.NewCrudServiceClient(server.Client(), server.URL)
+		req := connect.NewRequest(&crudv1.ReadRequest{Id: "missing"})
+		req.Header().Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		req.Header().Set(tracing.TracestateHeader, "vendor=value")
+		_, err := client.Read(context.Background(), req)
+		assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+		spans := recorder.find("/crud.v1.CrudService/Read", tracing.SpanKindServer)
+		if !assert.Len(t, spans, 1) {
+			return
+		}
+		span := spans[0]
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Context.TraceID.String())
+		assert.Equal(t, "00f067aa0ba902b7", span.ParentSpanID.String())
+		assert.Equal(t, "vendor=value", span.Context.TraceState)
+		assert.Equal(t, tracing.StatusError, span.Status)
+		assert.Contains(t, span.Attributes, tracing.Attribute{Key: "rpc.service", Value: "crud.v1.CrudService"})
+		assert.Contains(t, span.Attributes, tracing.Attribute{Key: "rpc.connect_rpc.error_code", Value: "not_found"})
+	})
+
+	t.Run("Propagates the trace of the client", func(t *testing.T) {
+		client := crudv1connect.NewCrudServiceClient(server.Client(), server.URL, interceptors)
+		_, err := client.Update(context.Background(), connect.NewRequest(&crudv1.UpdateRequest{Id: "record", UpdatedName: "Updated"}))
+		assert.NoError(t, err)
+
+		clientSpans := recorder.find("/crud.v1.CrudService/Update", tracing.SpanKindClient)
+		serverSpans := recorder.find("/crud.v1.CrudService/Update", tracing.SpanKindServer)
+		if !assert.Len(t, clientSpans, 1) || !assert.Len(t, serverSpans, 1) {
+			return
+		}
+		assert.Equal(t, clientSpans[0].Context.TraceID, serverSpans[0].Context.TraceID)
+		assert.Equal(t, clientSpans[0].Context.SpanID, serverSpans[0].ParentSpanID)
+		assert.Equal(t, tracing.StatusUnset, serverSpans[0].Status)
+	})
+
+	t.Run("Records the messages of the streams", func(t *testing.T) {
+		client := streamv1connect.NewStreamServiceClient(server.Client(), server.URL, interceptors)
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := client.Subscribe(ctx, connect.NewRequest(&streamv1.SubscribeRequest{Topics: []string{"news"}}))
+		assert.NoError(t, err)
+		assert.True(t, stream.Receive())
+		cancel()
+		_ = stream.Close()
+
+		assert.Eventually(t, func() bool {
+			return len(recorder.find("/stream.v1.StreamService/Subscribe", tracing.SpanKindServer)) == 1
+		}, 2*time.Second, 20*time.Millisecond)
+		clientSpans := recorder.find("/stream.v1.StreamService/Subscribe", tracing.SpanKindClient)
+		streamSpan := recorder.find("/stream.v1.StreamService/Subscribe", tracing.SpanKindServer)[0]
+		batches := recorder.find("/stream.v1.StreamService/Subscribe messages", tracing.SpanKindInternal)
+		if !assert.Len(t, clientSpans, 1) || !assert.Len(t, batches, 1) {
+			return
+		}
+		assert.Equal(t, clientSpans[0].Context.SpanID, streamSpan.ParentSpanID)
+		assert.Equal(t, streamSpan.Context.SpanID, batches[0].ParentSpanID)
+		assert.Contains(t, batches[0].Attributes, tracing.Attribute{Key: "messages.sent", Value: 1})
+		assert.Contains(t, batches[0].Attributes, tracing.Attribute{Key: "messages.received", Value: 1})
+	})
+}
@@ -0,0 +1,329 @@
+// Package metrics records counters, gauges and histograms, exposed in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of the histogram buckets measuring latencies, in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	// helpEscaper and labelEscaper escape the help texts and the label values, as the text format expects
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// collector writes the samples of a metric family
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics exposed together, in the order they were registered
+// Registering a metric twice, or recording a sample with the wrong number of labels, panics
+type Registry struct {
+	mutex      sync.Mutex
+	collectors []collector
+	names      map[string]struct{}
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]struct{})}
+}
+
+// register adds a collector under the given metric names
+func (r *Registry) register(c collector, names ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, name := range names {
+		if _, ok := r.names[name]; ok {
+			panic(fmt.Sprintf("metric %s registered twice", name))
+		}
+		r.names[name] = struct{}{}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText writes all the metrics in the Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mutex.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	return buf.Flush()
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WriteText(w)
+	})
+}
+
+// desc describes a metric family
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, helpEscaper.Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+// writeSample writes a sample line, the extra label (e.g. the le of histogram buckets) being appended if not empty
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, labelEscaper.Replace(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatValue(value))
+	w.WriteByte('\n')
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// seriesKey joins label values into a map key
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys returns the keys of the series, sorted so the output is stable
+func sortedKeys[T any](series map[string]T) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// value is the current value of a counter or gauge series
+type value struct {
+	labels []string
+	value  float64
+}
+
+// vec holds the series of a counter or gauge family
+type vec struct {
+	desc
+	mutex  sync.Mutex
+	series map[string]*value
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+	v.update(labelValues, func(s *value) { s.value += delta })
+}
+
+func (v *vec) update(labelValues []string, update func(s *value)) {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s expects %d labels, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	key := seriesKey(labelValues)
+	s, ok := v.series[key]
+	if !ok {
+		s = &value{labels: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	update(s)
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.writeHeader(w)
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		writeSample(w, v.name, v.labels, s.labels, "", "", s.value)
+	}
+}
+
+// CounterVec is a family of counters, partitioned by their labels
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec registers a family of counters
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec{desc: desc{name: name, help: help, typ: "counter", labels: labels}, series: make(map[string]*value)}}
+	r.register(c, name)
+	return c
+}
+
+// Inc increments the counter with the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+// Add adds a non-negative delta to the counter with the given label values
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.name))
+	}
+	c.add(delta, labelValues)
+}
+
+// GaugeVec is a family of gauges, partitioned by their labels
+type GaugeVec struct {
+	vec
+}
+
+// NewGaugeVec registers a family of gauges
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec{desc: desc{name: name, help: help, typ: "gauge", labels: labels}, series: make(map[string]*value)}}
+	r.register(g, name)
+	return g
+}
+
+// Set sets the gauge with the given label values
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.update(labelValues, func(s *value) { s.value = v })
+}
+
+// Add adds a delta, possibly negative, to the gauge with the given label values
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.add(delta, labelValues)
+}
+
+// funcMetric is a metric without labels, whose value is read when the metrics are written
+type funcMetric struct {
+	desc
+	fn func() float64
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	writeSample(w, f.name, nil, nil, "", "", f.fn())
+}
+
+// NewGaugeFunc registers a gauge whose value is read from the function when the metrics are written
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{desc: desc{name: name, help: help, typ: "gauge"}, fn: fn}, name)
+}
+
+// NewCounterFunc registers a counter whose value is read from the function when the metrics are written
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{desc: desc{name: name, help: help, typ: "counter"}, fn: fn}, name)
+}
+
+// histogram is the state of a histogram series
+type histogram struct {
+	labels []string
+	counts []uint64 // Number of observations in each bucket, not cumulated
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec is a family of histograms, partitioned by their labels
+type HistogramVec struct {
+	desc
+	buckets []float64
+
+	mutex  sync.Mutex
+	series map[string]*histogram
+}
+
+// NewHistogramVec registers a family of histograms, with the given bucket upper bounds (DefaultBuckets if nil)
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("buckets of histogram %s are not sorted", name))
+	}
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	r.register(h, name, name+"_bucket", name+"_sum", name+"_count")
+	return h
+}
+
+// Observe records an observation in the histogram with the given label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metric %s expects %d labels, got %d", h.name, len(h.labels), len(labelValues)))
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key := seriesKey(labelValues)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{
+			labels: append([]string(nil), labelValues...),
+			counts: make([]uint64, len(h.buckets)+1),
+		}
+		h.series[key] = s
+	}
+	// The last count is the one of the +Inf bucket
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.writeHeader(w)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, s.labels, "le", formatValue(bound), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, s.labels, "le", "+Inf", float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, s.labels, "", "", s.sum)
+		writeSample(w, h.name+"_count", h.labels, s.labels, "", "", float64(s.count))
+	}
+}
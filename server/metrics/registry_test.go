@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("requests_total", "Number of requests.", "procedure", "code")
+	streams := registry.NewGaugeVec("streams", "Open streams.", "procedure")
+	durations := registry.NewHistogramVec("duration_seconds", "Request durations.", []float64{0.1, 1}, "procedure")
+	registry.NewGaugeFunc("records", "Number of records.", func() float64 { return 3 })
+
+	requests.Inc("/svc/B", "ok")
+	requests.Inc("/svc/A", "ok")
+	requests.Add(2, "/svc/A", "not_found")
+	requests.Inc("/svc/\"quoted\"\n", "ok")
+	streams.Add(1, "/svc/A")
+	streams.Add(1, "/svc/A")
+	streams.Add(-1, "/svc/A")
+	durations.Observe(0.05, "/svc/A")
+	durations.Observe(0.1, "/svc/A")
+	durations.Observe(0.5, "/svc/A")
+	durations.Observe(5, "/svc/A")
+
+	var out strings.Builder
+	assert.NoError(t, registry.WriteText(&out))
+	assert.Equal(t, `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{procedure="/svc/\"quoted\"\n",code="ok"} 1
+requests_total{procedure="/svc/A",code="not_found"} 2
+requests_total{procedure="/svc/A",code="ok"} 1
+requests_total{procedure="/svc/B",code="ok"} 1
+# HELP streams Open streams.
+# TYPE streams gauge
+streams{procedure="/svc/A"} 1
+# HELP duration_seconds Request durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{procedure="/svc/A",le="0.1"} 2
+duration_seconds_bucket{procedure="/svc/A",le="1"} 3
+duration_seconds_bucket{procedure="/svc/A",le="+Inf"} 4
+duration_seconds_sum{procedure="/svc/A"} 5.65
+duration_seconds_count{procedure="/svc/A"} 4
+# HELP records Number of records.
+# TYPE records gauge
+records 3
+`, out.String())
+
+	// Metrics are registered once, and recorded with all their labels
+	assert.Panics(t, func() { registry.NewGaugeFunc("records", "Again.", func() float64 { return 0 }) })
+	assert.Panics(t, func() { registry.NewCounterVec("duration_seconds_count", "Clash.") })
+	assert.Panics(t, func() { requests.Inc("/svc/A") })
+	assert.Panics(t, func() { requests.Add(-1, "/svc/A", "ok") })
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	registry := NewRegistry()
+	registry.RegisterRuntimeMetrics()
+
+	rec := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "# TYPE go_goroutines gauge\ngo_goroutines ")
+	assert.Contains(t, rec.Body.String(), "# TYPE go_gc_cycles_total counter\n")
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bufio"
+	"runtime"
+)
+
+// runtimeCollector writes the statistics of the Go runtime, reading them once per scrape
+type runtimeCollector struct{}
+
+// RegisterRuntimeMetrics registers the statistics of the Go runtime: goroutines, memory and garbage collections
+func (r *Registry) RegisterRuntimeMetrics() {
+	r.register(runtimeCollector{},
+		"go_goroutines",
+		"go_threads",
+		"go_memstats_alloc_bytes",
+		"go_memstats_heap_inuse_bytes",
+		"go_memstats_sys_bytes",
+		"go_memstats_mallocs_total",
+		"go_gc_cycles_total",
+		"go_gc_pause_seconds_total",
+	)
+}
+
+func (runtimeCollector) write(w *bufio.Writer) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	threads, _ := runtime.ThreadCreateProfile(nil)
+
+	for _, sample := range []struct {
+		desc
+		value float64
+	}{
+		{desc{name: "go_goroutines", help: "Number of goroutines.", typ: "gauge"}, float64(runtime.NumGoroutine())},
+		{desc{name: "go_threads", help: "Number of OS threads created.", typ: "gauge"}, float64(threads)},
+		{desc{name: "go_memstats_alloc_bytes", help: "Bytes of allocated heap objects.", typ: "gauge"}, float64(stats.Alloc)},
+		{desc{name: "go_memstats_heap_inuse_bytes", help: "Bytes in in-use heap spans.", typ: "gauge"}, float64(stats.HeapInuse)},
+		{desc{name: "go_memstats_sys_bytes", help: "Bytes of memory obtained from the OS.", typ: "gauge"}, float64(stats.Sys)},
+		{desc{name: "go_memstats_mallocs_total", help: "Number of heap objects allocated.", typ: "counter"}, float64(stats.Mallocs)},
+		{desc{name: "go_gc_cycles_total", help: "Number of completed GC cycles.", typ: "counter"}, float64(stats.NumGC)},
+		{desc{name: "go_gc_pause_seconds_total", help: "Total time the GC stopped the world, in seconds.", typ: "counter"}, float64(stats.PauseTotalNs) / 1e9},
+	} {
+		sample.writeHeader(w)
+		writeSample(w, sample.name, nil, nil, "", "", sample.value)
+	}
+}
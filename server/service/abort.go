@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type responseControllerKey struct{}
+
+// ReceiveAbortHandler lets the stream handlers abort the pending receive of their stream (see abortReceive),
+// so they can stop receiving, and wait for the goroutines receiving, before they return
+// The services with client or bidi streams must be served behind it
+func ReceiveAbortHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), responseControllerKey{}, http.NewResponseController(w))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// abortReceive fails the pending and next receives of the stream of the request, by expiring the read deadline
+// of its body, the stream being of no use afterwards
+// It is a no-op if the request is not served behind the ReceiveAbortHandler
+func abortReceive(ctx context.Context) {
+	if controller, ok := ctx.Value(responseControllerKey{}).(*http.ResponseController); ok {
+		// A deadline in the past ends the receives right away
+		_ = controller.SetReadDeadline(time.Unix(1, 0))
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"connectrpc.com/connect"
+	"go.uber.org/zap"
+
+	"github.com/serbanmarti/go-grpc/server/audit"
+	"github.com/serbanmarti/go-grpc/server/auth"
+)
+
+// recordChange records a change made by the caller, or a failed attempt, in the audit log (if any),
+// returning the error the request must fail with: the error of the attempt, or an internal error
+// if the change could not be recorded
+func recordChange(ctx context.Context, log *audit.Log, procedure, id string, before, after any, err error) error {
+	event := audit.Event{
+		Caller:    auth.PrincipalFromContext(ctx),
+		Subject:   auth.SubjectFromContext(ctx),
+		Procedure: procedure,
+		RecordID:  id,
+		Before:    before,
+		After:     after,
+		Outcome:   audit.Outcome(err),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	if recordErr := log.Record(event); recordErr != nil {
+		zap.L().Error("Error recording audit event", zap.Error(recordErr), zap.String("procedure", procedure), zap.String("id", id))
+		if err == nil {
+			return connect.NewError(connect.CodeInternal, fmt.Errorf("error recording change"))
+		}
+	}
+	return err
+}
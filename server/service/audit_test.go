@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/crud/v1/crudv1connect"
+	filesv1 "github.com/serbanmarti/go-grpc/proto_gen/files/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/files/v1/filesv1connect"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+	"github.com/serbanmarti/go-grpc/server/audit"
+	"github.com/serbanmarti/go-grpc/server/auth"
+	"github.com/serbanmarti/go-grpc/server/storage"
+)
+
+// auditEntries returns the entries of the test audit log about the given record
+func auditEntries(t *testing.T, id string) []audit.Entry {
+	t.Helper()
+
+	file, err := os.Open(testAuditPath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var entries []audit.Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line struct {
+			Entry audit.Entry `json:"entry"`
+		}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		if line.Entry.RecordID == id {
+			entries = append(entries, line.Entry)
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return entries
+}
+
+func TestAuditCrud(t *testing.T) {
+	client := crudv1connect.NewCrudServiceClient(
+		newInsecureClient(),
+		"http://127.0.0.1:8080",
+		connect.WithGRPC(),
+	)
+	ctx := context.Background()
+	withCaller := func(req connect.AnyRequest) {
+		req.Header().Set(testClientIDHeader, "auditor")
+	}
+
+	// Create, update and delete a record, then try to update it once deleted
+	create := connect.NewRequest(&crudv1.CreateRequest{Name: "Audited"})
+	withCaller(create)
+	created, err := client.Create(ctx, create)
+	require.NoError(t, err)
+	id := created.Msg.Id
+
+	update := connect.NewRequest(&crudv1.UpdateRequest{Id: id, UpdatedName: "Audited Again"})
+	withCaller(update)
+	_, err = client.Update(ctx, update)
+	require.NoError(t, err)
+
+	del := connect.NewRequest(&crudv1.DeleteRequest{Id: id})
+	withCaller(del)
+	_, err = client.Delete(ctx, del)
+	require.NoError(t, err)
+
+	update = connect.NewRequest(&crudv1.UpdateRequest{Id: id, UpdatedName: "Too Late"})
+	withCaller(update)
+	_, err = client.Update(ctx, update)
+	assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+	entries := auditEntries(t, id)
+	require.Len(t, entries, 4)
+	expected := []audit.Event{
+		{Caller: auth.SharedSecretPrincipal, Subject: "auditor", Procedure: crudv1connect.CrudServiceCreateProcedure, RecordID: id, After: "Audited", Outcome: "ok"},
+		{Caller: auth.SharedSecretPrincipal, Subject: "auditor", Procedure: crudv1connect.CrudServiceUpdateProcedure, RecordID: id, Before: "Audited", After: "Audited Again", Outcome: "ok"},
+		{Caller: auth.SharedSecretPrincipal, Subject: "auditor", Procedure: crudv1connect.CrudServiceDeleteProcedure, RecordID: id, Before: "Audited Again", Outcome: "ok"},
+		{Caller: auth.SharedSecretPrincipal, Subject: "auditor", Procedure: crudv1connect.CrudServiceUpdateProcedure, RecordID: id, After: "Too Late", Outcome: "not_found", Error: "not_found: record not found"},
+	}
+	for i, entry := range entries {
+		assert.Equal(t, expected[i], entry.Event)
+	}
+
+	// The entries written by all the tests so far form a valid chain
+	_, err = audit.Verify([]string{testAuditPath})
+	assert.NoError(t, err)
+}
+
+func TestAuditFiles(t *testing.T) {
+	id := uploadTestFile(t, "auditor", "audited.txt", []byte("audited content"))
+
+	client := filesv1connect.NewFileServiceClient(
+		newInsecureClient(),
+		"http://127.0.0.1:8080",
+		connect.WithGRPC(),
+	)
+	req := connect.NewRequest(&filesv1.DeleteFileRequest{Id: id})
+	req.Header().Set(testClientIDHeader, "auditor")
+	_, err := client.DeleteFile(context.Background(), req)
+	require.NoError(t, err)
+
+	// The upload is recorded with the stored file, and the deletion with the deleted one
+	entries := auditEntries(t, id)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "/stream.v1.StreamService/UploadFile", entries[0].Procedure)
+	assert.Equal(t, auth.SharedSecretPrincipal, entries[0].Caller)
+	assert.Equal(t, "auditor", entries[0].Subject)
+	assert.Equal(t, "audited.txt", entries[0].After.(map[string]any)["name"])
+	assert.Equal(t, "/files.v1.FileService/DeleteFile", entries[1].Procedure)
+	assert.Equal(t, "audited.txt", entries[1].Before.(map[string]any)["name"])
+	assert.Equal(t, "auditor", entries[1].Before.(map[string]any)["owner"])
+}
+
+func TestAuditUploadFailure(t *testing.T) {
+	dir := t.TempDir()
+	files, err := storage.NewFileStore(filepath.Join(dir, "files"))
+	require.NoError(t, err)
+	auditLog, err := audit.Open(audit.Config{Path: filepath.Join(dir, "audit.log")})
+	require.NoError(t, err)
+
+	// A closed log fails to record anything
+	require.NoError(t, auditLog.Close())
+
+	mux := http.NewServeMux()
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&StreamService{Files: files, Audit: auditLog}))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	client := streamv1connect.NewStreamServiceClient(server.Client(), server.URL)
+
+	stream := client.UploadFile(context.Background())
+	require.NoError(t, stream.Send(&streamv1.UploadFileRequest{FileName: "unaudited.txt", Chunk: []byte("unaudited content")}))
+	_, err = stream.CloseAndReceive()
+	assert.Equal(t, connect.CodeInternal, connect.CodeOf(err))
+
+	// The upload is rolled back, so no file is stored without a trace
+	count, size := files.Usage()
+	assert.Zero(t, count)
+	assert.Zero(t, size)
+}
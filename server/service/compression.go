@@ -0,0 +1,53 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+)
+
+// DefaultMaxChunkBytes is the maximum size of a decompressed upload chunk, when none is configured
+const DefaultMaxChunkBytes = 4 << 20
+
+var (
+	errChunkTooLarge          = errors.New("chunk too large")
+	errInvalidChunk           = errors.New("invalid compressed chunk")
+	errUnsupportedCompression = errors.New("unsupported chunk compression")
+)
+
+// decompressChunk returns the decompressed content of an upload chunk,
+// refusing to decompress more than limit bytes so a small chunk cannot expand into a huge one
+func decompressChunk(chunk []byte, compression streamv1.Compression, limit int64) ([]byte, error) {
+	var reader io.Reader
+	switch compression {
+	case streamv1.Compression_COMPRESSION_UNSPECIFIED:
+		if int64(len(chunk)) > limit {
+			return nil, errChunkTooLarge
+		}
+		return chunk, nil
+	case streamv1.Compression_COMPRESSION_GZIP:
+		gz, err := gzip.NewReader(bytes.NewReader(chunk))
+		if err != nil {
+			return nil, errInvalidChunk
+		}
+		defer gz.Close()
+		reader = gz
+	default:
+		return nil, errUnsupportedCompression
+	}
+
+	// Read one byte past the limit, to tell a chunk exactly at the limit from a larger one
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidChunk, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, errChunkTooLarge
+	}
+
+	return data, nil
+}
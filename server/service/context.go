@@ -0,0 +1,18 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"connectrpc.com/connect"
+)
+
+// contextError returns the error to end a request whose context is done with: its deadline was exceeded,
+// or it was canceled, by the client or by the handler returning
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return connect.NewError(connect.CodeDeadlineExceeded, fmt.Errorf("deadline exceeded"))
+	}
+	return connect.NewError(connect.CodeCanceled, fmt.Errorf("request canceled"))
+}
@@ -9,22 +9,32 @@ import (
 	"github.com/segmentio/ksuid"
 
 	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
+	"github.com/serbanmarti/go-grpc/server/audit"
 )
 
 type CrudService struct {
 	Mutex sync.RWMutex
 	Data  map[string]string
+
+	// Audit records the changes, if set; they are recorded before being applied, so none is left unrecorded
+	Audit *audit.Log
 }
 
 func (s *CrudService) Create(ctx context.Context, req *connect.Request[crudv1.CreateRequest]) (*connect.Response[crudv1.CreateResponse], error) {
 	// Create an ID for the new record
 	id := ksuid.New().String()
 
-	// Lock the mutex to ensure thread safety
+	// Lock the mutex to ensure thread safety, giving up if the request ended while waiting for it
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
+	if ctx.Err() != nil {
+		return nil, contextError(ctx)
+	}
 
-	// Store the record in memory
+	// Record the change, then store the record in memory
+	if err := recordChange(ctx, s.Audit, req.Spec().Procedure, id, nil, req.Msg.Name, nil); err != nil {
+		return nil, err
+	}
 	s.Data[id] = req.Msg.Name
 
 	return connect.NewResponse(&crudv1.CreateResponse{
@@ -33,9 +43,12 @@ func (s *CrudService) Create(ctx context.Context, req *connect.Request[crudv1.Cr
 }
 
 func (s *CrudService) Read(ctx context.Context, req *connect.Request[crudv1.ReadRequest]) (*connect.Response[crudv1.ReadResponse], error) {
-	// Read lock the mutex to allow multiple readers
+	// Read lock the mutex to allow multiple readers, giving up if the request ended while waiting for it
 	s.Mutex.RLock()
 	defer s.Mutex.RUnlock()
+	if ctx.Err() != nil {
+		return nil, contextError(ctx)
+	}
 
 	// Grab the record, if it exists
 	name, ok := s.Data[req.Msg.Id]
@@ -50,17 +63,24 @@ func (s *CrudService) Read(ctx context.Context, req *connect.Request[crudv1.Read
 }
 
 func (s *CrudService) Update(ctx context.Context, req *connect.Request[crudv1.UpdateRequest]) (*connect.Response[crudv1.UpdateResponse], error) {
-	// Lock the mutex to ensure thread safety
+	// Lock the mutex to ensure thread safety, giving up if the request ended while waiting for it
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
+	if ctx.Err() != nil {
+		return nil, contextError(ctx)
+	}
 
 	// Check if the record exists
-	_, ok := s.Data[req.Msg.Id]
+	before, ok := s.Data[req.Msg.Id]
 	if !ok {
-		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("record not found"))
+		err := connect.NewError(connect.CodeNotFound, fmt.Errorf("record not found"))
+		return nil, recordChange(ctx, s.Audit, req.Spec().Procedure, req.Msg.Id, nil, req.Msg.UpdatedName, err)
 	}
 
-	// Update the record
+	// Record the change, then update the record
+	if err := recordChange(ctx, s.Audit, req.Spec().Procedure, req.Msg.Id, before, req.Msg.UpdatedName, nil); err != nil {
+		return nil, err
+	}
 	s.Data[req.Msg.Id] = req.Msg.UpdatedName
 
 	return connect.NewResponse(&crudv1.UpdateResponse{
@@ -70,20 +90,36 @@ func (s *CrudService) Update(ctx context.Context, req *connect.Request[crudv1.Up
 }
 
 func (s *CrudService) Delete(ctx context.Context, req *connect.Request[crudv1.DeleteRequest]) (*connect.Response[crudv1.DeleteResponse], error) {
-	// Lock the mutex to ensure thread safety
+	// Lock the mutex to ensure thread safety, giving up if the request ended while waiting for it
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
+	if ctx.Err() != nil {
+		return nil, contextError(ctx)
+	}
 
 	// Check if the record exists
-	_, ok := s.Data[req.Msg.Id]
+	before, ok := s.Data[req.Msg.Id]
 	if !ok {
-		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("record not found"))
+		err := connect.NewError(connect.CodeNotFound, fmt.Errorf("record not found"))
+		return nil, recordChange(ctx, s.Audit, req.Spec().Procedure, req.Msg.Id, nil, nil, err)
 	}
 
-	// Delete the record
+	// Record the change, then delete the record
+	if err := recordChange(ctx, s.Audit, req.Spec().Procedure, req.Msg.Id, before, nil, nil); err != nil {
+		return nil, err
+	}
 	delete(s.Data, req.Msg.Id)
 
 	return connect.NewResponse(&crudv1.DeleteResponse{
 		Id: req.Msg.Id,
 	}), nil
 }
+
+// Len returns the number of records stored
+func (s *CrudService) Len() int {
+	// Lock the mutex to ensure thread safety
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	return len(s.Data)
+}
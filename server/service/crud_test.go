@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"connectrpc.com/connect"
 	"github.com/google/go-cmp/cmp"
@@ -272,3 +273,20 @@ func TestCrudService_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestCrudContextDone(t *testing.T) {
+	s := &CrudService{Data: map[string]string{"record": "Test Record"}}
+
+	// A request whose deadline passed while waiting for the lock gives up without changing anything
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err := s.Update(ctx, connect.NewRequest(&crudv1.UpdateRequest{Id: "record", UpdatedName: "Changed"}))
+	assert.Equal(t, connect.CodeDeadlineExceeded, connect.CodeOf(err))
+	assert.Equal(t, "Test Record", s.Data["record"])
+
+	// A canceled one as well
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = s.Read(ctx, connect.NewRequest(&crudv1.ReadRequest{Id: "record"}))
+	assert.Equal(t, connect.CodeCanceled, connect.CodeOf(err))
+}
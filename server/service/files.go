@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"connectrpc.com/connect"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	filesv1 "github.com/serbanmarti/go-grpc/proto_gen/files/v1"
+	"github.com/serbanmarti/go-grpc/server/audit"
+	"github.com/serbanmarti/go-grpc/server/auth"
+	"github.com/serbanmarti/go-grpc/server/storage"
+)
+
+// FileService lets the callers manage the files they uploaded, and the admins those of every owner
+// The files of other owners are reported as not found, so the callers cannot learn whether they exist
+type FileService struct {
+	Store *storage.FileStore
+	// Audit records the deletions, if set
+	Audit *audit.Log
+}
+
+func (s *FileService) ListFiles(ctx context.Context, req *connect.Request[filesv1.ListFilesRequest]) (*connect.Response[filesv1.ListFilesResponse], error) {
+	if req.Msg.PageSize < 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("page size must not be negative"))
+	}
+
+	// Only the admins may list the files of other owners, the files of the caller being listed by default
+	owner := req.Msg.Owner
+	if !auth.IsAdmin(ctx) {
+		if owner != "" && owner != auth.SubjectFromContext(ctx) {
+			return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("listing the files of other owners requires the admin token"))
+		}
+		owner = auth.SubjectFromContext(ctx)
+	}
+
+	// Grab the requested page of files
+	files, nextPageToken, err := s.Store.List(storage.ListOptions{
+		NamePrefix: req.Msg.NamePrefix,
+		Owner:      owner,
+		PageSize:   int(req.Msg.PageSize),
+		PageToken:  req.Msg.PageToken,
+	})
+	if errors.Is(err, storage.ErrInvalidPageToken) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid page token"))
+	}
+	if err != nil {
+		zap.L().Error("Error listing files", zap.Error(err))
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("error listing files"))
+	}
+
+	res := &filesv1.ListFilesResponse{
+		Files:         make([]*filesv1.FileInfo, 0, len(files)),
+		NextPageToken: nextPageToken,
+	}
+	for _, f := range files {
+		res.Files = append(res.Files, toFileInfo(f))
+	}
+
+	return connect.NewResponse(res), nil
+}
+
+func (s *FileService) StatFile(ctx context.Context, req *connect.Request[filesv1.StatFileRequest]) (*connect.Response[filesv1.StatFileResponse], error) {
+	// Grab the file metadata, if the file exists and the caller may access it
+	info, err := s.stat(ctx, req.Msg.Id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("file not found"))
+	}
+	if err != nil {
+		zap.L().Error("Error reading file metadata", zap.Error(err))
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("error reading file metadata"))
+	}
+
+	return connect.NewResponse(&filesv1.StatFileResponse{
+		File: toFileInfo(info),
+	}), nil
+}
+
+func (s *FileService) DeleteFile(ctx context.Context, req *connect.Request[filesv1.DeleteFileRequest]) (*connect.Response[filesv1.DeleteFileResponse], error) {
+	// Delete the file, if it exists and the caller may access it
+	// The owner of a file never changes, so it can be checked before deleting it
+	_, err := s.stat(ctx, req.Msg.Id)
+	var info storage.FileInfo
+	if err == nil {
+		info, err = s.Store.Delete(req.Msg.Id)
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		err = connect.NewError(connect.CodeNotFound, fmt.Errorf("file not found"))
+	} else if err != nil {
+		zap.L().Error("Error deleting file", zap.Error(err))
+		err = connect.NewError(connect.CodeInternal, fmt.Errorf("error deleting file"))
+	}
+
+	// Record the deletion, or the failed attempt
+	var before any
+	if err == nil {
+		before = info
+	}
+	if err := recordChange(ctx, s.Audit, req.Spec().Procedure, req.Msg.Id, before, nil, err); err != nil {
+		return nil, err
+	}
+
+	return connect.NewResponse(&filesv1.DeleteFileResponse{
+		Id: req.Msg.Id,
+	}), nil
+}
+
+// stat returns the metadata of a file, reporting the files of other owners as not found unless the caller is an admin
+func (s *FileService) stat(ctx context.Context, id string) (storage.FileInfo, error) {
+	info, err := s.Store.Stat(id)
+	if err != nil {
+		return storage.FileInfo{}, err
+	}
+	if info.Owner != auth.SubjectFromContext(ctx) && !auth.IsAdmin(ctx) {
+		return storage.FileInfo{}, storage.ErrNotFound
+	}
+	return info, nil
+}
+
+// toFileInfo converts the stored file metadata to its proto representation
+func toFileInfo(info storage.FileInfo) *filesv1.FileInfo {
+	return &filesv1.FileInfo{
+		Id:          info.ID,
+		FileName:    info.Name,
+		Size:        uint64(info.Size),
+		Sha256:      info.Digest,
+		ContentType: info.ContentType,
+		UploadedAt:  timestamppb.New(info.UploadedAt),
+		Owner:       info.Owner,
+	}
+}
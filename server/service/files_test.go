@@ -0,0 +1,376 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/stretchr/testify/assert"
+
+	filesv1 "github.com/serbanmarti/go-grpc/proto_gen/files/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/files/v1/filesv1connect"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+)
+
+// uploadTestFile uploads a file as the given owner, returning its ID
+func uploadTestFile(t *testing.T, owner, name string, content []byte) string {
+	t.Helper()
+
+	client := streamv1connect.NewStreamServiceClient(
+		newInsecureClient(),
+		"http://127.0.0.1:8080",
+		connect.WithGRPC(),
+	)
+
+	stream := client.UploadFile(context.Background())
+	stream.RequestHeader().Set(testClientIDHeader, owner)
+
+	err := stream.Send(&streamv1.UploadFileRequest{
+		FileName: name,
+		Chunk:    content,
+	})
+	assert.NoError(t, err)
+
+	res, err := stream.CloseAndReceive()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return res.Msg.Id
+}
+
+func newFileServiceClient() filesv1connect.FileServiceClient {
+	return filesv1connect.NewFileServiceClient(
+		newInsecureClient(),
+		"http://127.0.0.1:8080",
+		connect.WithGRPC(),
+	)
+}
+
+// newCallerRequest builds a request sent by the given caller, as an admin if set
+func newCallerRequest[T any](msg *T, caller string, admin bool) *connect.Request[T] {
+	req := connect.NewRequest(msg)
+	req.Header().Set(testClientIDHeader, caller)
+	if admin {
+		req.Header().Set(testAdminHeader, "true")
+	}
+	return req
+}
+
+func TestFileService_ListFiles(t *testing.T) {
+	// Upload the files to be listed, under owners not used by other tests
+	uploadTestFile(t, "list-owner-1", "report-a.txt", []byte("Report A"))
+	uploadTestFile(t, "list-owner-1", "report-b.txt", []byte("Report B"))
+	uploadTestFile(t, "list-owner-1", "image.png", []byte("Not really an image"))
+	uploadTestFile(t, "list-owner-2", "report-c.txt", []byte("Report C"))
+
+	tests := []struct {
+		name        string
+		caller      string
+		admin       bool
+		reqData     *filesv1.ListFilesRequest
+		fileNames   [][]string // File names expected on each page
+		expectedErr error
+	}{
+		{
+			name:      "Test list own files",
+			caller:    "list-owner-1",
+			reqData:   &filesv1.ListFilesRequest{},
+			fileNames: [][]string{{"report-a.txt", "report-b.txt", "image.png"}},
+		},
+		{
+			name:   "Test list files by owner",
+			caller: "list-owner-1",
+			reqData: &filesv1.ListFilesRequest{
+				Owner: "list-owner-1",
+			},
+			fileNames: [][]string{{"report-a.txt", "report-b.txt", "image.png"}},
+		},
+		{
+			name:   "Test list own files by name prefix",
+			caller: "list-owner-1",
+			reqData: &filesv1.ListFilesRequest{
+				NamePrefix: "report-",
+			},
+			fileNames: [][]string{{"report-a.txt", "report-b.txt"}},
+		},
+		{
+			name:   "Test list files of all owners by name prefix as admin",
+			caller: "list-admin",
+			admin:  true,
+			reqData: &filesv1.ListFilesRequest{
+				NamePrefix: "report-",
+			},
+			fileNames: [][]string{{"report-a.txt", "report-b.txt", "report-c.txt"}},
+		},
+		{
+			name:   "Test list files of another owner as admin",
+			caller: "list-admin",
+			admin:  true,
+			reqData: &filesv1.ListFilesRequest{
+				Owner:      "list-owner-1",
+				NamePrefix: "report-",
+			},
+			fileNames: [][]string{{"report-a.txt", "report-b.txt"}},
+		},
+		{
+			name:   "Test list files paginated",
+			caller: "list-owner-1",
+			reqData: &filesv1.ListFilesRequest{
+				PageSize: 2,
+			},
+			fileNames: [][]string{{"report-a.txt", "report-b.txt"}, {"image.png"}},
+		},
+		{
+			name:   "Test list files of unknown owner as admin",
+			caller: "list-admin",
+			admin:  true,
+			reqData: &filesv1.ListFilesRequest{
+				Owner: "list-owner-unknown",
+			},
+			fileNames: [][]string{{}},
+		},
+		{
+			name:   "Test list files of another owner",
+			caller: "list-owner-2",
+			reqData: &filesv1.ListFilesRequest{
+				Owner: "list-owner-1",
+			},
+			expectedErr: connect.NewError(connect.CodePermissionDenied, fmt.Errorf("listing the files of other owners requires the admin token")),
+		},
+		{
+			name: "Test list files with negative page size",
+			reqData: &filesv1.ListFilesRequest{
+				PageSize: -1,
+			},
+			expectedErr: connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("page size must not be negative")),
+		},
+		{
+			name: "Test list files with invalid page token",
+			reqData: &filesv1.ListFilesRequest{
+				PageToken: "not-a-token",
+			},
+			expectedErr: connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid page token")),
+		},
+	}
+
+	client := newFileServiceClient()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if tt.expectedErr != nil {
+				_, err := client.ListFiles(ctx, newCallerRequest(tt.reqData, tt.caller, tt.admin))
+				if !cmp.Equal(
+					tt.expectedErr.Error(), err.Error(),
+				) {
+					t.Errorf("want[-], got[+]\n%v", cmp.Diff(
+						tt.expectedErr.Error(), err.Error(),
+					))
+				}
+				return
+			}
+
+			// Walk through all the pages, following the page tokens
+			var pages [][]string
+			for {
+				res, err := client.ListFiles(ctx, newCallerRequest(tt.reqData, tt.caller, tt.admin))
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				names := []string{}
+				for _, f := range res.Msg.Files {
+					names = append(names, f.FileName)
+				}
+				pages = append(pages, names)
+
+				if res.Msg.NextPageToken == "" {
+					break
+				}
+				tt.reqData.PageToken = res.Msg.NextPageToken
+			}
+
+			if !cmp.Equal(tt.fileNames, pages) {
+				t.Errorf("want[-], got[+]\n%v", cmp.Diff(tt.fileNames, pages))
+			}
+		})
+	}
+}
+
+func TestFileService_StatFile(t *testing.T) {
+	content := []byte("Hello, World!")
+	digest := sha256.Sum256(content)
+	id := uploadTestFile(t, "stat-owner", "hello.txt", content)
+
+	tests := []struct {
+		name        string
+		caller      string
+		admin       bool
+		reqData     *filesv1.StatFileRequest
+		resData     *filesv1.FileInfo
+		expectedErr error
+	}{
+		{
+			name:   "Test stat file",
+			caller: "stat-owner",
+			reqData: &filesv1.StatFileRequest{
+				Id: id,
+			},
+			resData: &filesv1.FileInfo{
+				Id:          id,
+				FileName:    "hello.txt",
+				Size:        uint64(len(content)),
+				Sha256:      hex.EncodeToString(digest[:]),
+				ContentType: "text/plain; charset=utf-8",
+				Owner:       "stat-owner",
+			},
+		},
+		{
+			name:   "Test stat file of another owner as admin",
+			caller: "stat-admin",
+			admin:  true,
+			reqData: &filesv1.StatFileRequest{
+				Id: id,
+			},
+			resData: &filesv1.FileInfo{
+				Id:          id,
+				FileName:    "hello.txt",
+				Size:        uint64(len(content)),
+				Sha256:      hex.EncodeToString(digest[:]),
+				ContentType: "text/plain; charset=utf-8",
+				Owner:       "stat-owner",
+			},
+		},
+		{
+			name:   "Test stat file of another owner",
+			caller: "stat-other",
+			reqData: &filesv1.StatFileRequest{
+				Id: id,
+			},
+			expectedErr: connect.NewError(connect.CodeNotFound, fmt.Errorf("file not found")),
+		},
+		{
+			name:   "Test stat non-existent file",
+			caller: "stat-owner",
+			reqData: &filesv1.StatFileRequest{
+				Id: "2imgqwcM6MabAQBULm8VtXvfF86",
+			},
+			expectedErr: connect.NewError(connect.CodeNotFound, fmt.Errorf("file not found")),
+		},
+	}
+
+	client := newFileServiceClient()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			res, err := client.StatFile(ctx, newCallerRequest(tt.reqData, tt.caller, tt.admin))
+
+			if tt.expectedErr == nil {
+				assert.NoError(t, err)
+
+				if !cmp.Equal(
+					tt.resData, res.Msg.File,
+					cmpopts.IgnoreUnexported(filesv1.FileInfo{}),
+					cmpopts.IgnoreFields(filesv1.FileInfo{}, "UploadedAt"),
+				) {
+					t.Errorf("want[-], got[+]\n%v", cmp.Diff(
+						tt.resData, res.Msg.File,
+						cmpopts.IgnoreUnexported(filesv1.FileInfo{}),
+						cmpopts.IgnoreFields(filesv1.FileInfo{}, "UploadedAt"),
+					))
+				}
+
+				assert.NotNil(t, res.Msg.File.UploadedAt)
+			} else {
+				if !cmp.Equal(
+					tt.expectedErr.Error(), err.Error(),
+				) {
+					t.Errorf("want[-], got[+]\n%v", cmp.Diff(
+						tt.expectedErr.Error(), err.Error(),
+					))
+				}
+			}
+		})
+	}
+}
+
+func TestFileService_DeleteFile(t *testing.T) {
+	id := uploadTestFile(t, "delete-owner", "to-delete.txt", []byte("Delete me"))
+	otherID := uploadTestFile(t, "delete-owner", "to-delete-by-admin.txt", []byte("Delete me too"))
+
+	tests := []struct {
+		name        string
+		caller      string
+		admin       bool
+		reqData     *filesv1.DeleteFileRequest
+		expectedErr error
+	}{
+		{
+			name:   "Test delete file of another owner",
+			caller: "delete-other",
+			reqData: &filesv1.DeleteFileRequest{
+				Id: id,
+			},
+			expectedErr: connect.NewError(connect.CodeNotFound, fmt.Errorf("file not found")),
+		},
+		{
+			name:   "Test delete file",
+			caller: "delete-owner",
+			reqData: &filesv1.DeleteFileRequest{
+				Id: id,
+			},
+		},
+		{
+			name:   "Test delete already deleted file",
+			caller: "delete-owner",
+			reqData: &filesv1.DeleteFileRequest{
+				Id: id,
+			},
+			expectedErr: connect.NewError(connect.CodeNotFound, fmt.Errorf("file not found")),
+		},
+		{
+			name:   "Test delete file of another owner as admin",
+			caller: "delete-admin",
+			admin:  true,
+			reqData: &filesv1.DeleteFileRequest{
+				Id: otherID,
+			},
+		},
+	}
+
+	client := newFileServiceClient()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			res, err := client.DeleteFile(ctx, newCallerRequest(tt.reqData, tt.caller, tt.admin))
+
+			if tt.expectedErr == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.reqData.Id, res.Msg.Id)
+
+				// The file must be gone from the catalog
+				_, err := client.StatFile(ctx, newCallerRequest(&filesv1.StatFileRequest{Id: tt.reqData.Id}, "", true))
+				assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+			} else {
+				if !cmp.Equal(
+					tt.expectedErr.Error(), err.Error(),
+				) {
+					t.Errorf("want[-], got[+]\n%v", cmp.Diff(
+						tt.expectedErr.Error(), err.Error(),
+					))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package service
+
+import (
+	"connectrpc.com/connect"
+)
+
+// MessageLimits bounds the size of the messages a service receives and sends, in bytes; zero allows any size
+// Larger messages fail the request with ResourceExhausted, before reaching the handler for the received ones
+type MessageLimits struct {
+	ReadMaxBytes int
+	SendMaxBytes int
+}
+
+// HandlerOptions returns the options applying the limits to the handler of a service
+func (l MessageLimits) HandlerOptions() []connect.HandlerOption {
+	return []connect.HandlerOption{
+		connect.WithReadMaxBytes(l.ReadMaxBytes),
+		connect.WithSendMaxBytes(l.SendMaxBytes),
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+
+	crudv1 "github.com/serbanmarti/go-grpc/proto_gen/crud/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/crud/v1/crudv1connect"
+	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+)
+
+func TestMessageLimits_Crud(t *testing.T) {
+	client := crudv1connect.NewCrudServiceClient(
+		newInsecureClient(),
+		"http://127.0.0.1:8080",
+		connect.WithGRPC(),
+	)
+
+	// A name within the limit is stored
+	_, err := client.Create(context.Background(), connect.NewRequest(&crudv1.CreateRequest{
+		Name: strings.Repeat("x", testCrudLimits.ReadMaxBytes/2),
+	}))
+	assert.NoError(t, err)
+
+	// A larger one is refused before reaching the service
+	_, err = client.Create(context.Background(), connect.NewRequest(&crudv1.CreateRequest{
+		Name: strings.Repeat("x", testCrudLimits.ReadMaxBytes+1),
+	}))
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+	assert.Contains(t, err.Error(), "larger than configured max")
+}
+
+func TestMessageLimits_UploadFile(t *testing.T) {
+	client := streamv1connect.NewStreamServiceClient(
+		newInsecureClient(),
+		"http://127.0.0.1:8080",
+		connect.WithGRPC(),
+	)
+
+	// An oversized chunk fails the upload with the reason, rather than with an internal error
+	stream := client.UploadFile(context.Background())
+	stream.RequestHeader().Set(testClientIDHeader, "limited")
+	_ = stream.Send(&streamv1.UploadFileRequest{
+		FileName: "oversized.bin",
+		Chunk:    make([]byte, testStreamLimits.ReadMaxBytes+1),
+	})
+	_, err := stream.CloseAndReceive()
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+	assert.Contains(t, err.Error(), "larger than configured max")
+}
@@ -3,39 +3,112 @@ package service
 import (
 	"context"
 	"crypto/tls"
+	"log"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sync"
+	"testing"
 	"time"
 
+	"connectrpc.com/connect"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 
 	"github.com/serbanmarti/go-grpc/proto_gen/crud/v1/crudv1connect"
+	"github.com/serbanmarti/go-grpc/proto_gen/files/v1/filesv1connect"
 	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+	"github.com/serbanmarti/go-grpc/server/audit"
+	"github.com/serbanmarti/go-grpc/server/auth"
+	"github.com/serbanmarti/go-grpc/server/broadcast"
+	"github.com/serbanmarti/go-grpc/server/chat"
+	"github.com/serbanmarti/go-grpc/server/storage"
 )
 
-func init() {
+const (
+	// testClientIDHeader is the header used by tests to name the caller
+	testClientIDHeader = "x-client-id"
+	// testAdminHeader is the header used by tests to make the caller an admin
+	testAdminHeader = "x-test-admin"
+)
+
+// testAuditPath is the audit log of the services under test
+var testAuditPath string
+
+// testCrudLimits and testStreamLimits bound the size of the messages of the services under test
+var (
+	testCrudLimits   = MessageLimits{ReadMaxBytes: 64 << 10, SendMaxBytes: 64 << 10}
+	testStreamLimits = MessageLimits{ReadMaxBytes: 1 << 20, SendMaxBytes: 1 << 20}
+)
+
+func TestMain(m *testing.M) {
 	// Create the mock data store
 	data := make(map[string]string)
 	data["2imgNBCejbjXehOazVerssNsgcz"] = "Test Record 1"
 	data["2imgN7lkpYjE16akMMn52Uvkgln"] = "Test Record 2"
 
+	// Create a temporary file store
+	storageDir, err := os.MkdirTemp("", "go-grpc-test-*")
+	if err != nil {
+		log.Fatalf("Failed to create storage directory: %v", err)
+	}
+	files, err := storage.NewFileStore(filepath.Join(storageDir, "files"))
+	if err != nil {
+		log.Fatalf("Failed to create file store: %v", err)
+	}
+	inboxes, err := chat.OpenInboxes(filepath.Join(storageDir, "inboxes"), 0)
+	if err != nil {
+		log.Fatalf("Failed to open chat inboxes: %v", err)
+	}
+	history, err := chat.OpenHistory(filepath.Join(storageDir, "history"), 0)
+	if err != nil {
+		log.Fatalf("Failed to open chat history: %v", err)
+	}
+
+	testAuditPath = filepath.Join(storageDir, "audit.log")
+	auditLog, err := audit.Open(audit.Config{Path: testAuditPath})
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+
 	// Create the server mux & register the services we want to test
+	interceptors := connect.WithInterceptors(&identityInterceptor{})
 	mux := http.NewServeMux()
 	mux.Handle(crudv1connect.NewCrudServiceHandler(&CrudService{
 		Data:  data,
 		Mutex: sync.RWMutex{},
-	}))
-	mux.Handle(streamv1connect.NewStreamServiceHandler(&StreamService{}))
+		Audit: auditLog,
+	}, append(testCrudLimits.HandlerOptions(), interceptors)...))
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&StreamService{
+		Files:  files,
+		Hub:    chat.NewHub(inboxes, history, chat.Options{}),
+		Broker: broadcast.NewBroker(2),
+		Audit:  auditLog,
+	}, append(testStreamLimits.HandlerOptions(), interceptors)...))
+	mux.Handle(filesv1connect.NewFileServiceHandler(&FileService{
+		Store: files,
+		Audit: auditLog,
+	}, interceptors))
 
+	// Listen before running the tests, so the server is ready to accept their connections
+	listener, err := net.Listen("tcp", "0.0.0.0:8080")
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
 	go func() {
-		http.ListenAndServe(
-			"0.0.0.0:8080",
+		http.Serve(
+			listener,
 			// Use h2c so we can serve HTTP/2 without TLS.
-			h2c.NewHandler(mux, &http2.Server{}),
+			h2c.NewHandler(ReceiveAbortHandler(mux), &http2.Server{}),
 		)
 	}()
+
+	code := m.Run()
+
+	listener.Close()
+	os.RemoveAll(storageDir)
+	os.Exit(code)
 }
 
 func newInsecureClient() *http.Client {
@@ -49,3 +122,33 @@ func newInsecureClient() *http.Client {
 		Timeout: 5 * time.Second,
 	}
 }
+
+// identityInterceptor stands in for the authentication interceptor,
+// setting the caller identity from the client ID and admin headers
+type identityInterceptor struct{}
+
+// testIdentity builds the caller identity from the request headers,
+// the callers authenticating with the shared secret and naming themselves with the client ID
+func testIdentity(header http.Header) *auth.Identity {
+	return &auth.Identity{
+		Subject:   header.Get(testClientIDHeader),
+		Principal: auth.SharedSecretPrincipal,
+		Admin:     header.Get(testAdminHeader) != "",
+	}
+}
+
+func (i *identityInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		return next(auth.NewContext(ctx, testIdentity(req.Header())), req)
+	}
+}
+
+func (i *identityInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *identityInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		return next(auth.NewContext(ctx, testIdentity(conn.RequestHeader())), conn)
+	}
+}
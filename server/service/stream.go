@@ -2,66 +2,578 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"time"
 
 	"connectrpc.com/connect"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
+	"github.com/serbanmarti/go-grpc/server/audit"
+	"github.com/serbanmarti/go-grpc/server/auth"
+	"github.com/serbanmarti/go-grpc/server/broadcast"
+	"github.com/serbanmarti/go-grpc/server/chat"
+	"github.com/serbanmarti/go-grpc/server/crash"
+	"github.com/serbanmarti/go-grpc/server/storage"
 )
 
-type StreamService struct{}
+type StreamService struct {
+	Files *storage.FileStore
+	Hub   *chat.Hub
+	// Broker fans out the announcements to their subscribers
+	Broker *broadcast.Broker
+	// MaxChunkBytes limits the decompressed size of each uploaded chunk (DefaultMaxChunkBytes if zero)
+	MaxChunkBytes int64
+	// PingInterval is how often chat streams are pinged, expecting a pong back (never if zero)
+	PingInterval time.Duration
+	// IdleTimeout closes the streams receiving nothing for that long (never if zero)
+	IdleTimeout time.Duration
+	// MaxLifetime closes the streams open for that long (never if zero)
+	MaxLifetime time.Duration
+	// Audit records the uploads, if set
+	Audit *audit.Log
+}
 
 func (s *StreamService) UploadFile(ctx context.Context, stream *connect.ClientStream[streamv1.UploadFileRequest]) (*connect.Response[streamv1.UploadFileResponse], error) {
+	// Close the stream once idle, or open, for too long
+	watchdog := startWatchdog(s.IdleTimeout, s.MaxLifetime)
+	defer watchdog.stop()
+
+	// Receive the upload from another goroutine, so the stream can be closed without waiting for the next chunk
+	type result struct {
+		res *connect.Response[streamv1.UploadFileResponse]
+		err error
+	}
+	receiveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	received := make(chan result, 1)
+	go func() {
+		var res *connect.Response[streamv1.UploadFileResponse]
+		err := crash.Guard(receiveCtx, func() (err error) {
+			res, err = s.receiveUpload(receiveCtx, stream, watchdog)
+			return err
+		})
+		received <- result{res: res, err: err}
+	}()
+
+	// stop ends the pending receive and waits for the upload to be aborted, as the stream must not be used
+	// once the handler returned, failing with the given error
+	// The upload may have been committed meanwhile, in which case it succeeded after all
+	stop := func(err error) result {
+		cancel()
+		abortReceive(ctx)
+		r := <-received
+		if r.err != nil {
+			r.err = err
+		}
+		return r
+	}
+
+	var r result
+	select {
+	case r = <-received:
+	case err := <-watchdog.expired():
+		zap.L().Warn("Closing upload stream", zap.String("user", auth.SubjectFromContext(ctx)), zap.Error(err))
+		r = stop(err)
+	case <-ctx.Done():
+		// Likewise once the client went away or the deadline of the request is exceeded
+		r = stop(contextError(ctx))
+	}
+	res, err := r.res, r.err
+
+	// Record the upload, or the failed attempt
+	// The file is already stored once the upload completes, so it is deleted again if the upload cannot be recorded
+	var id string
+	var after any
+	if res != nil {
+		id = res.Msg.Id
+		after = map[string]any{
+			"name":         res.Msg.FileName,
+			"size":         res.Msg.Size,
+			"sha256":       res.Msg.Sha256,
+			"deduplicated": res.Msg.Deduplicated,
+		}
+	}
+	if err := recordChange(ctx, s.Audit, stream.Spec().Procedure, id, nil, after, err); err != nil {
+		if res != nil {
+			if _, err := s.Files.Delete(id); err != nil {
+				zap.L().Error("Error rolling back upload", zap.Error(err), zap.String("id", id))
+			}
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// receiveUpload stores the file received from the client until it closes the stream
+func (s *StreamService) receiveUpload(ctx context.Context, stream *connect.ClientStream[streamv1.UploadFileRequest], watchdog *watchdog) (*connect.Response[streamv1.UploadFileResponse], error) {
+	// Stage the received chunks in the file store until the stream completes
+	upload, err := s.Files.NewUpload()
+	if err != nil {
+		zap.L().Error("Error creating upload", zap.Error(err))
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("error storing file"))
+	}
+	defer upload.Abort()
+
 	// Initialize variables for data we care about from the stream
 	fileName := ""
-	fileSize := 0
+	declaredDigest := ""
+	first := true
+	owner := auth.SubjectFromContext(ctx)
+	wireSize := uint64(0)
+
+	maxChunkBytes := s.MaxChunkBytes
+	if maxChunkBytes <= 0 {
+		maxChunkBytes = DefaultMaxChunkBytes
+	}
 
 	// Receive data from client
 	for stream.Receive() {
+		watchdog.touch()
+		if ctx.Err() != nil {
+			return nil, contextError(ctx)
+		}
+
 		// Use only the first file name received
 		if fileName == "" && stream.Msg().GetFileName() != "" {
 			fileName = stream.Msg().GetFileName()
 		}
 
-		// Calculate the total size of the file
-		chunk := stream.Msg().GetChunk()
-		fileSize += len(chunk)
+		// The digest of the file can only be declared in the first message
+		if first && stream.Msg().GetSha256() != "" {
+			declaredDigest = strings.ToLower(stream.Msg().GetSha256())
+			if !isSHA256(declaredDigest) {
+				return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid sha256 digest"))
+			}
+
+			// Skip receiving the content if it is already stored
+			info, err := s.Files.Link(declaredDigest, fileName, owner)
+			if err == nil {
+				return newUploadFileResponse(info, true, wireSize), nil
+			}
+			if !errors.Is(err, storage.ErrNotFound) {
+				zap.L().Error("Error linking upload", zap.Error(err))
+				return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("error storing file"))
+			}
+		}
+		first = false
+
+		// Decompress the chunk, as negotiated by the client for this chunk
+		wireSize += uint64(len(stream.Msg().GetChunk()))
+		chunk, err := decompressChunk(stream.Msg().GetChunk(), stream.Msg().GetCompression(), maxChunkBytes)
+		switch {
+		case errors.Is(err, errChunkTooLarge):
+			return nil, connect.NewError(connect.CodeResourceExhausted, fmt.Errorf("decompressed chunk exceeds the maximum of %d bytes", maxChunkBytes))
+		case errors.Is(err, errUnsupportedCompression):
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unsupported chunk compression: %s", stream.Msg().GetCompression()))
+		case err != nil:
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid compressed chunk"))
+		}
+
+		// Append the chunk to the stored file
+		if _, err := upload.Write(chunk); err != nil {
+			zap.L().Error("Error writing upload", zap.Error(err))
+			return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("error storing file"))
+		}
 	}
 
-	// Check for any errors during the stream
-	if err := stream.Err(); err != nil {
+	// Check for any errors during the stream, the messages or the stream being too large being the client's fault
+	if err := stream.Err(); err != nil && ctx.Err() != nil { // The receive was aborted, as the upload was stopped
+		return nil, contextError(ctx)
+	} else if connect.CodeOf(err) == connect.CodeResourceExhausted {
+		return nil, err
+	} else if err != nil {
 		zap.L().Error("Error receiving stream", zap.Error(err))
 		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("error receiving stream"))
 	}
 
-	return connect.NewResponse(&streamv1.UploadFileResponse{
-		FileName: fileName,
-		Size:     uint32(fileSize),
-	}), nil
+	// Refuse to store content that does not match what the client declared
+	if declaredDigest != "" && upload.Digest() != declaredDigest {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("uploaded content does not match the declared sha256 digest"))
+	}
+
+	// Make the file visible in the store, owned by the caller, unless the upload was stopped meanwhile
+	if ctx.Err() != nil {
+		return nil, contextError(ctx)
+	}
+	info, deduplicated, err := upload.Commit(fileName, owner)
+	if err != nil {
+		zap.L().Error("Error committing upload", zap.Error(err))
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("error storing file"))
+	}
+
+	return newUploadFileResponse(info, deduplicated, wireSize), nil
 }
 
 func (s *StreamService) DirectMessage(ctx context.Context, stream *connect.BidiStream[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse]) error {
+	// Connect the caller to the hub for the lifetime of the stream
+	participant := s.Hub.Connect(auth.SubjectFromContext(ctx))
+
+	// Close the stream once idle, or open, for too long
+	watchdog := startWatchdog(s.IdleTimeout, s.MaxLifetime)
+	defer watchdog.stop()
+
+	// Forward the messages fanned out by the hub from a single goroutine, as sends on a stream must not be concurrent
+	forwarded := make(chan error, 1)
+	go func() {
+		forwarded <- crash.Guard(ctx, func() error {
+			return forwardMessages(participant, stream, s.PingInterval)
+		})
+	}()
+
+	// Receive the requests from another goroutine, so the stream can end without waiting for the next one
+	receiveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	received := make(chan error, 1)
+	go func() {
+		received <- crash.Guard(receiveCtx, func() error {
+			return s.receiveMessages(receiveCtx, participant, stream, watchdog)
+		})
+	}()
+
+	// stopReceiving ends the pending receive and waits for the receiving to end,
+	// as the stream must not be used once the handler returned
+	stopReceiving := func() {
+		cancel()
+		abortReceive(ctx)
+		<-received
+	}
+
+	select {
+	case err := <-received:
+		// Disconnecting closes the participant's events, so the forwarding ends once the pending ones are sent
+		s.Hub.Disconnect(participant)
+		if sendErr := <-forwarded; sendErr != nil && err == nil {
+			zap.L().Error("Error sending stream", zap.Error(sendErr))
+			err = connect.NewError(connect.CodeInternal, fmt.Errorf("error sending stream"))
+		}
+		return err
+	case err := <-forwarded:
+		s.Hub.Disconnect(participant)
+		stopReceiving()
+		if crash.IsPanic(err) {
+			return err
+		}
+
+		// Otherwise, the forwarding only ends first when the hub disconnected the participant, as it did not keep up
+		zap.L().Warn("Disconnecting slow chat stream", zap.String("user", participant.User), zap.Error(participant.Err()))
+		return connect.NewError(connect.CodeResourceExhausted, fmt.Errorf("stream disconnected as it does not keep up with its messages"))
+	case err := <-watchdog.expired():
+		// Both the forwarding and the receiving must end before returning
+		zap.L().Warn("Closing chat stream", zap.String("user", participant.User), zap.Error(err))
+		s.Hub.Disconnect(participant)
+		<-forwarded
+		stopReceiving()
+		return err
+	case <-ctx.Done():
+		// Likewise once the client went away or the deadline of the request is exceeded
+		err := contextError(ctx)
+		s.Hub.Disconnect(participant)
+		<-forwarded
+		stopReceiving()
+		return err
+	}
+}
+
+// receiveMessages handles the requests received from the client until it closes the stream
+func (s *StreamService) receiveMessages(ctx context.Context, participant *chat.Participant, stream *connect.BidiStream[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse], watchdog *watchdog) error {
 	for {
 		// Receive data from client
 		req, err := stream.Receive()
 		if errors.Is(err, io.EOF) { // Client closed stream, we need to return nil to indicate success
 			return nil
 		}
+		if err != nil && ctx.Err() != nil { // The stream ended, canceled by the client, by the handler returning or by its deadline
+			return contextError(ctx)
+		}
+		if connect.CodeOf(err) == connect.CodeResourceExhausted { // The message, or the stream, is too large
+			return err
+		}
 		if err != nil {
 			zap.L().Error("Error receiving stream", zap.Error(err))
 			return connect.NewError(connect.CodeInternal, fmt.Errorf("error receiving stream"))
 		}
 
-		// Send data to client
-		err = stream.Send(&streamv1.DirectMessageResponse{
-			Message: fmt.Sprintf("Received message: %s", req.GetMessage()),
-		})
+		// Every request keeps the stream open, but pongs are answered by the client on its own
+		watchdog.touch()
+		if _, ok := req.Kind.(*streamv1.DirectMessageRequest_Pong); ok {
+			continue
+		}
+
+		// Every other request marks the caller as active
+		s.Hub.Heartbeat(participant)
+
+		switch kind := req.Kind.(type) {
+		case *streamv1.DirectMessageRequest_Heartbeat:
+			// Nothing else to do, the caller was marked active above
+		case *streamv1.DirectMessageRequest_Typing:
+			err = s.Hub.Typing(participant, kind.Typing.GetRoom(), kind.Typing.GetRecipient())
+		case *streamv1.DirectMessageRequest_Join:
+			err = s.Hub.Join(participant, kind.Join.GetRoom())
+		case *streamv1.DirectMessageRequest_Leave:
+			s.Hub.Leave(participant, kind.Leave.GetRoom())
+		case *streamv1.DirectMessageRequest_Ack:
+			err = s.Hub.Ack(participant, kind.Ack.GetId())
+		default:
+			_, err = s.Hub.Send(participant, req.GetRoom(), req.GetRecipient(), req.GetMessage())
+		}
+		if isChatRequestError(err) {
+			return connect.NewError(connect.CodeInvalidArgument, err)
+		}
+		if errors.Is(err, chat.ErrInboxFull) {
+			return connect.NewError(connect.CodeResourceExhausted, err)
+		}
 		if err != nil {
+			zap.L().Error("Error handling chat request", zap.Error(err))
+			return connect.NewError(connect.CodeInternal, fmt.Errorf("error handling chat request"))
+		}
+	}
+}
+
+// forwardMessages sends the events delivered to the participant to the client, until the participant disconnects
+// The backlog from the participant's inbox goes first, then the events fanned out by the hub, along with the pings
+// After a failed send, the remaining events are drained so the hub never blocks on this participant
+func forwardMessages(participant *chat.Participant, stream *connect.BidiStream[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse], pingInterval time.Duration) error {
+	var sendErr error
+	for _, msg := range participant.Backlog() {
+		if sendErr = stream.Send(newDirectMessageResponse(participant, msg)); sendErr != nil {
+			break
+		}
+	}
+
+	// A nil channel never fires, so no pings are sent when disabled
+	var pings <-chan time.Time
+	if pingInterval > 0 {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+	pinged := uint64(0)
+
+	for {
+		var res *streamv1.DirectMessageResponse
+		select {
+		case <-pings:
+			pinged++
+			res = &streamv1.DirectMessageResponse{
+				Event: &streamv1.DirectMessageResponse_Ping{Ping: &streamv1.Ping{Id: strconv.FormatUint(pinged, 10)}},
+			}
+		case event, ok := <-participant.Events():
+			if !ok {
+				return sendErr
+			}
+			res = newEventResponse(participant, event)
+		}
+
+		if sendErr == nil {
+			sendErr = stream.Send(res)
+		}
+	}
+}
+
+// newEventResponse builds the response delivering an event fanned out by the hub to a participant
+func newEventResponse(participant *chat.Participant, event chat.Event) *streamv1.DirectMessageResponse {
+	switch {
+	case event.Presence != nil:
+		return &streamv1.DirectMessageResponse{
+			Event: &streamv1.DirectMessageResponse_Presence{Presence: toPresence(*event.Presence)},
+		}
+	case event.Typing != nil:
+		return &streamv1.DirectMessageResponse{
+			Event: &streamv1.DirectMessageResponse_Typing{Typing: &streamv1.TypingEvent{
+				User:      event.Typing.User,
+				Room:      event.Typing.Room,
+				Recipient: event.Typing.Recipient,
+			}},
+		}
+	default:
+		return newDirectMessageResponse(participant, *event.Message)
+	}
+}
+
+// newDirectMessageResponse builds the response delivering a chat message to a participant
+func newDirectMessageResponse(participant *chat.Participant, msg chat.Message) *streamv1.DirectMessageResponse {
+	return &streamv1.DirectMessageResponse{
+		Message:   msg.Text,
+		Id:        msg.ID,
+		Sender:    msg.Sender,
+		SentAt:    timestamppb.New(msg.SentAt),
+		Room:      msg.Room,
+		Recipient: msg.Recipient,
+		// Only the recipient's copy of a direct message is kept in an inbox
+		AckRequired: msg.Recipient != "" && msg.Recipient == participant.User,
+		Redelivered: msg.Redelivered,
+	}
+}
+
+// isChatRequestError reports whether a chat error is caused by an invalid request
+func isChatRequestError(err error) bool {
+	return errors.Is(err, chat.ErrNoTarget) ||
+		errors.Is(err, chat.ErrEmptyRoom) ||
+		errors.Is(err, chat.ErrEmptyText) ||
+		errors.Is(err, chat.ErrEmptyID)
+}
+
+func (s *StreamService) ListOnline(ctx context.Context, req *connect.Request[streamv1.ListOnlineRequest]) (*connect.Response[streamv1.ListOnlineResponse], error) {
+	// Grab the presence of the connected users
+	online := s.Hub.Online()
+
+	res := &streamv1.ListOnlineResponse{
+		Users: make([]*streamv1.Presence, 0, len(online)),
+	}
+	for _, presence := range online {
+		res.Users = append(res.Users, toPresence(presence))
+	}
+
+	return connect.NewResponse(res), nil
+}
+
+func (s *StreamService) GetHistory(ctx context.Context, req *connect.Request[streamv1.GetHistoryRequest]) (*connect.Response[streamv1.GetHistoryResponse], error) {
+	if req.Msg.Limit < 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("limit must not be negative"))
+	}
+
+	// Grab the requested page of the conversation, the caller being one of the peers of a direct conversation
+	entries, hasMore, err := s.Hub.History(auth.SubjectFromContext(ctx), req.Msg.Room, req.Msg.Peer, chat.HistoryQuery{
+		Before: req.Msg.Before,
+		After:  req.Msg.After,
+		Limit:  int(req.Msg.Limit),
+	})
+	if errors.Is(err, chat.ErrNoConversation) || errors.Is(err, chat.ErrInvalidCursor) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if err != nil {
+		zap.L().Error("Error reading chat history", zap.Error(err))
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("error reading chat history"))
+	}
+
+	res := &streamv1.GetHistoryResponse{
+		Messages: make([]*streamv1.HistoryMessage, 0, len(entries)),
+		HasMore:  hasMore,
+	}
+	for _, entry := range entries {
+		res.Messages = append(res.Messages, &streamv1.HistoryMessage{
+			Cursor:    entry.Cursor(),
+			Id:        entry.ID,
+			Sender:    entry.Sender,
+			Message:   entry.Text,
+			SentAt:    timestamppb.New(entry.SentAt),
+			Room:      entry.Room,
+			Recipient: entry.Recipient,
+		})
+	}
+
+	return connect.NewResponse(res), nil
+}
+
+func (s *StreamService) Subscribe(ctx context.Context, req *connect.Request[streamv1.SubscribeRequest], stream *connect.ServerStream[streamv1.Announcement]) error {
+	// Register for the requested topics for the lifetime of the stream
+	subscriber, err := s.Broker.Subscribe(req.Msg.Topics)
+	if err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	defer s.Broker.Unsubscribe(subscriber)
+
+	// Close the stream once open for too long; as it receives nothing after the request, it is never idle
+	watchdog := startWatchdog(0, s.MaxLifetime)
+	defer watchdog.stop()
+
+	// Send the announcements retained for late subscribers first
+	for _, announcement := range subscriber.Retained() {
+		if err := stream.Send(toAnnouncement(announcement, true)); err != nil {
 			zap.L().Error("Error sending stream", zap.Error(err))
 			return connect.NewError(connect.CodeInternal, fmt.Errorf("error sending stream"))
 		}
 	}
+
+	// Then the ones published from now on, until the client goes away
+	for {
+		select {
+		case announcement := <-subscriber.Announcements():
+			if err := stream.Send(toAnnouncement(announcement, false)); err != nil {
+				zap.L().Error("Error sending stream", zap.Error(err))
+				return connect.NewError(connect.CodeInternal, fmt.Errorf("error sending stream"))
+			}
+		case err := <-watchdog.expired():
+			return err
+		case <-ctx.Done():
+			return contextError(ctx)
+		}
+	}
+}
+
+func (s *StreamService) Publish(ctx context.Context, req *connect.Request[streamv1.PublishRequest]) (*connect.Response[streamv1.PublishResponse], error) {
+	// Only the admins may reach every connected client
+	if !auth.IsAdmin(ctx) {
+		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("publishing announcements requires the admin token"))
+	}
+
+	// Fan the announcement out to the topic's subscribers, retaining it for the late ones
+	announcement, subscribers, err := s.Broker.Publish(auth.SubjectFromContext(ctx), req.Msg.Topic, req.Msg.Message)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	return connect.NewResponse(&streamv1.PublishResponse{
+		Id:          announcement.ID,
+		PublishedAt: timestamppb.New(announcement.PublishedAt),
+		Subscribers: uint32(subscribers),
+	}), nil
+}
+
+// toAnnouncement converts an announcement to its proto representation
+func toAnnouncement(announcement broadcast.Announcement, retained bool) *streamv1.Announcement {
+	return &streamv1.Announcement{
+		Id:          announcement.ID,
+		Topic:       announcement.Topic,
+		Message:     announcement.Text,
+		Publisher:   announcement.Publisher,
+		PublishedAt: timestamppb.New(announcement.PublishedAt),
+		Retained:    retained,
+	}
+}
+
+// toPresence converts the presence of a user to its proto representation
+func toPresence(presence chat.Presence) *streamv1.Presence {
+	status := streamv1.PresenceStatus_PRESENCE_STATUS_OFFLINE
+	switch presence.Status {
+	case chat.StatusOnline:
+		status = streamv1.PresenceStatus_PRESENCE_STATUS_ONLINE
+	case chat.StatusIdle:
+		status = streamv1.PresenceStatus_PRESENCE_STATUS_IDLE
+	}
+
+	return &streamv1.Presence{
+		User:   presence.User,
+		Status: status,
+		Since:  timestamppb.New(presence.Since),
+	}
+}
+
+// newUploadFileResponse builds the response to an upload from the stored file metadata
+func newUploadFileResponse(info storage.FileInfo, deduplicated bool, wireSize uint64) *connect.Response[streamv1.UploadFileResponse] {
+	return connect.NewResponse(&streamv1.UploadFileResponse{
+		FileName:     info.Name,
+		Size:         uint64(info.Size),
+		Id:           info.ID,
+		Sha256:       info.Digest,
+		Deduplicated: deduplicated,
+		WireSize:     wireSize,
+	})
+}
+
+// isSHA256 reports whether the given string is a hex-encoded SHA-256 digest
+func isSHA256(digest string) bool {
+	if len(digest) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil
 }
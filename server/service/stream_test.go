@@ -1,16 +1,32 @@
 package service
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"connectrpc.com/connect"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/segmentio/ksuid"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	streamv1 "github.com/serbanmarti/go-grpc/proto_gen/stream/v1"
 	"github.com/serbanmarti/go-grpc/proto_gen/stream/v1/streamv1connect"
+	"github.com/serbanmarti/go-grpc/server/chat"
+	"github.com/serbanmarti/go-grpc/server/storage"
 )
 
 func TestStreamService_UploadFile(t *testing.T) {
@@ -36,6 +52,8 @@ func TestStreamService_UploadFile(t *testing.T) {
 			resData: streamv1.UploadFileResponse{
 				FileName: "smaller.txt",
 				Size:     42,
+				Sha256:   "ad94099bede68fdc4a8ffe047b6ed86ce222ed814aa4c3cecfabadf117760043",
+				WireSize: 42,
 			},
 		},
 		{
@@ -58,6 +76,8 @@ func TestStreamService_UploadFile(t *testing.T) {
 			resData: streamv1.UploadFileResponse{
 				FileName: "larger.txt",
 				Size:     442,
+				Sha256:   "4551baa25ef9b3b72a93dd8ba53dc2a0d7a8ff348f197d8610323b86a0b24e12",
+				WireSize: 442,
 			},
 		},
 	}
@@ -84,53 +104,419 @@ func TestStreamService_UploadFile(t *testing.T) {
 			if !cmp.Equal(
 				tt.resData, *res.Msg,
 				cmpopts.IgnoreUnexported(streamv1.UploadFileResponse{}),
+				cmpopts.IgnoreFields(streamv1.UploadFileResponse{}, "Id"),
 			) {
 				t.Errorf("want[-], got[+]\n%v", cmp.Diff(
 					tt.resData, *res.Msg,
 					cmpopts.IgnoreUnexported(streamv1.UploadFileResponse{}),
+					cmpopts.IgnoreFields(streamv1.UploadFileResponse{}, "Id"),
 				))
 			}
+
+			if _, err := ksuid.Parse(res.Msg.Id); err != nil {
+				t.Errorf("ID is not a valid KSUID: %v", err)
+			}
 		})
 	}
 }
 
+func TestStreamService_UploadFileDeduplication(t *testing.T) {
+	content := []byte("Content uploaded more than once")
+	hash := sha256.Sum256(content)
+	digest := hex.EncodeToString(hash[:])
+
+	// Store the content once, so later uploads can be deduplicated
+	uploadTestFile(t, "dedup-owner", "original.txt", content)
+
+	tests := []struct {
+		name         string
+		owner        string
+		reqData      []*streamv1.UploadFileRequest
+		size         uint64
+		deduplicated bool
+		expectedErr  error
+	}{
+		{
+			name:  "Test declared digest of content stored by the caller skips the upload",
+			owner: "dedup-owner",
+			reqData: []*streamv1.UploadFileRequest{
+				{
+					FileName: "copy.txt",
+					Sha256:   digest,
+				},
+			},
+			size:         uint64(len(content)),
+			deduplicated: true,
+		},
+		{
+			name:  "Test declared digest of content stored by another owner is not linked",
+			owner: "other-owner",
+			reqData: []*streamv1.UploadFileRequest{
+				{
+					FileName: "copy.txt",
+					Sha256:   digest,
+				},
+			},
+			expectedErr: connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("uploaded content does not match the declared sha256 digest")),
+		},
+		{
+			name:  "Test declared digest of content stored by another owner is uploaded",
+			owner: "other-owner",
+			reqData: []*streamv1.UploadFileRequest{
+				{
+					FileName: "copy.txt",
+					Sha256:   digest,
+					Chunk:    content,
+				},
+			},
+			size:         uint64(len(content)),
+			deduplicated: true,
+		},
+		{
+			name: "Test identical content without declared digest is deduplicated",
+			reqData: []*streamv1.UploadFileRequest{
+				{
+					FileName: "copy.txt",
+					Chunk:    content,
+				},
+			},
+			size:         uint64(len(content)),
+			deduplicated: true,
+		},
+		{
+			name: "Test declared digest of new content is uploaded",
+			reqData: []*streamv1.UploadFileRequest{
+				{
+					FileName: "new.txt",
+					Sha256:   "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", // Empty content
+				},
+			},
+			size: 0,
+		},
+		{
+			name: "Test declared digest not matching the content",
+			reqData: []*streamv1.UploadFileRequest{
+				{
+					FileName: "mismatch.txt",
+					Sha256:   "0000000000000000000000000000000000000000000000000000000000000000",
+					Chunk:    []byte("Some other content"),
+				},
+			},
+			expectedErr: connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("uploaded content does not match the declared sha256 digest")),
+		},
+		{
+			name: "Test invalid declared digest",
+			reqData: []*streamv1.UploadFileRequest{
+				{
+					FileName: "invalid.txt",
+					Sha256:   "not-a-digest",
+				},
+			},
+			expectedErr: connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid sha256 digest")),
+		},
+	}
+
+	client := streamv1connect.NewStreamServiceClient(
+		newInsecureClient(),
+		"http://127.0.0.1:8080",
+		connect.WithGRPC(),
+	)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			stream := client.UploadFile(ctx)
+			stream.RequestHeader().Set(testClientIDHeader, tt.owner)
+
+			for _, req := range tt.reqData {
+				// The server may respond before receiving all the messages
+				if err := stream.Send(req); errors.Is(err, io.EOF) {
+					break
+				}
+			}
+
+			res, err := stream.CloseAndReceive()
+
+			if tt.expectedErr == nil {
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, tt.size, res.Msg.Size)
+				assert.Equal(t, tt.deduplicated, res.Msg.Deduplicated)
+			} else {
+				if !cmp.Equal(
+					tt.expectedErr.Error(), err.Error(),
+				) {
+					t.Errorf("want[-], got[+]\n%v", cmp.Diff(
+						tt.expectedErr.Error(), err.Error(),
+					))
+				}
+			}
+		})
+	}
+}
+
+func TestStreamService_UploadFileCompression(t *testing.T) {
+	text := bytes.Repeat([]byte("Text-heavy content compresses well. "), 100)
+	compressedText := gzipBytes(t, text)
+	bomb := gzipBytes(t, make([]byte, DefaultMaxChunkBytes+1))
+
+	tests := []struct {
+		name        string
+		reqData     []*streamv1.UploadFileRequest
+		size        uint64
+		wireSize    uint64
+		expectedErr error
+	}{
+		{
+			name: "Test gzip compressed chunks",
+			reqData: []*streamv1.UploadFileRequest{
+				{
+					FileName:    "compressed.txt",
+					Chunk:       compressedText,
+					Compression: streamv1.Compression_COMPRESSION_GZIP,
+				},
+				{
+					Chunk: []byte("Uncompressed tail"),
+				},
+			},
+			size:     uint64(len(text) + len("Uncompressed tail")),
+			wireSize: uint64(len(compressedText) + len("Uncompressed tail")),
+		},
+		{
+			name: "Test decompression bomb",
+			reqData: []*streamv1.UploadFileRequest{
+				{
+					FileName:    "bomb.txt",
+					Chunk:       bomb,
+					Compression: streamv1.Compression_COMPRESSION_GZIP,
+				},
+			},
+			expectedErr: connect.NewError(connect.CodeResourceExhausted, fmt.Errorf("decompressed chunk exceeds the maximum of %d bytes", DefaultMaxChunkBytes)),
+		},
+		{
+			name: "Test corrupted compressed chunk",
+			reqData: []*streamv1.UploadFileRequest{
+				{
+					FileName:    "corrupted.txt",
+					Chunk:       []byte("Not gzip at all"),
+					Compression: streamv1.Compression_COMPRESSION_GZIP,
+				},
+			},
+			expectedErr: connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid compressed chunk")),
+		},
+		{
+			name: "Test unsupported compression",
+			reqData: []*streamv1.UploadFileRequest{
+				{
+					FileName:    "unsupported.txt",
+					Chunk:       []byte("Some content"),
+					Compression: streamv1.Compression(99),
+				},
+			},
+			expectedErr: connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unsupported chunk compression: 99")),
+		},
+	}
+
+	client := streamv1connect.NewStreamServiceClient(
+		newInsecureClient(),
+		"http://127.0.0.1:8080",
+		connect.WithGRPC(),
+	)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			stream := client.UploadFile(ctx)
+
+			for _, req := range tt.reqData {
+				// The server may respond before receiving all the messages
+				if err := stream.Send(req); errors.Is(err, io.EOF) {
+					break
+				}
+			}
+
+			res, err := stream.CloseAndReceive()
+
+			if tt.expectedErr == nil {
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, tt.size, res.Msg.Size)
+				assert.Equal(t, tt.wireSize, res.Msg.WireSize)
+			} else {
+				if !cmp.Equal(
+					tt.expectedErr.Error(), err.Error(),
+				) {
+					t.Errorf("want[-], got[+]\n%v", cmp.Diff(
+						tt.expectedErr.Error(), err.Error(),
+					))
+				}
+			}
+		})
+	}
+}
+
+// gzipBytes compresses data with gzip
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// chatStep is a request sent on the stream of a user, followed by the message each stream then receives
+type chatStep struct {
+	user    string
+	reqData *streamv1.DirectMessageRequest
+	resData map[string]*streamv1.DirectMessageResponse
+}
+
 func TestStreamService_DirectMessage(t *testing.T) {
 	tests := []struct {
-		name    string
-		reqData []streamv1.DirectMessageRequest
-		resData []streamv1.DirectMessageResponse
+		name        string
+		users       []string
+		steps       []chatStep
+		expectedErr error // Error expected on the stream of the first user, after the steps
 	}{
 		{
-			name: "Test single message",
-			reqData: []streamv1.DirectMessageRequest{
+			name:  "Test room message reaches all members",
+			users: []string{"room-alice", "room-bob"},
+			steps: []chatStep{
 				{
-					Message: "Hello",
+					user:    "room-alice",
+					reqData: &streamv1.DirectMessageRequest{Room: "room-general", Message: "Hello"},
+					resData: map[string]*streamv1.DirectMessageResponse{
+						"room-alice": {Sender: "room-alice", Room: "room-general", Message: "Hello"},
+					},
+				},
+				{
+					user:    "room-bob",
+					reqData: &streamv1.DirectMessageRequest{Room: "room-general", Message: "Hi Alice"},
+					resData: map[string]*streamv1.DirectMessageResponse{
+						"room-alice": {Sender: "room-bob", Room: "room-general", Message: "Hi Alice"},
+						"room-bob":   {Sender: "room-bob", Room: "room-general", Message: "Hi Alice"},
+					},
+				},
+				{
+					user:    "room-alice",
+					reqData: &streamv1.DirectMessageRequest{Room: "room-general", Message: "How are you?"},
+					resData: map[string]*streamv1.DirectMessageResponse{
+						"room-alice": {Sender: "room-alice", Room: "room-general", Message: "How are you?"},
+						"room-bob":   {Sender: "room-alice", Room: "room-general", Message: "How are you?"},
+					},
 				},
 			},
-			resData: []streamv1.DirectMessageResponse{
+		},
+		{
+			name:  "Test explicit join and leave",
+			users: []string{"join-alice", "join-bob"},
+			steps: []chatStep{
+				{
+					user:    "join-bob",
+					reqData: &streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Join{Join: &streamv1.JoinRoom{Room: "join-room"}}},
+				},
 				{
-					Message: "Received message: Hello",
+					// Messages on a stream are handled in order, so this makes sure the join is done
+					user:    "join-bob",
+					reqData: &streamv1.DirectMessageRequest{Recipient: "join-bob", Message: "Joined"},
+					resData: map[string]*streamv1.DirectMessageResponse{
+						"join-bob": {Sender: "join-bob", Recipient: "join-bob", Message: "Joined", AckRequired: true},
+					},
+				},
+				{
+					user:    "join-alice",
+					reqData: &streamv1.DirectMessageRequest{Room: "join-room", Message: "Anyone here?"},
+					resData: map[string]*streamv1.DirectMessageResponse{
+						"join-alice": {Sender: "join-alice", Room: "join-room", Message: "Anyone here?"},
+						"join-bob":   {Sender: "join-alice", Room: "join-room", Message: "Anyone here?"},
+					},
+				},
+				{
+					user:    "join-bob",
+					reqData: &streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Leave{Leave: &streamv1.LeaveRoom{Room: "join-room"}}},
+				},
+				{
+					user:    "join-bob",
+					reqData: &streamv1.DirectMessageRequest{Recipient: "join-bob", Message: "Left"},
+					resData: map[string]*streamv1.DirectMessageResponse{
+						"join-bob": {Sender: "join-bob", Recipient: "join-bob", Message: "Left", AckRequired: true},
+					},
+				},
+				{
+					// Bob left the room, so only Alice receives her own message
+					user:    "join-alice",
+					reqData: &streamv1.DirectMessageRequest{Room: "join-room", Message: "Bye"},
+					resData: map[string]*streamv1.DirectMessageResponse{
+						"join-alice": {Sender: "join-alice", Room: "join-room", Message: "Bye"},
+					},
+				},
+				{
+					user:    "join-alice",
+					reqData: &streamv1.DirectMessageRequest{Recipient: "join-bob", Message: "Still there?"},
+					resData: map[string]*streamv1.DirectMessageResponse{
+						"join-alice": {Sender: "join-alice", Recipient: "join-bob", Message: "Still there?"},
+						"join-bob":   {Sender: "join-alice", Recipient: "join-bob", Message: "Still there?", AckRequired: true},
+					},
 				},
 			},
 		},
 		{
-			name: "Test multiple messages",
-			reqData: []streamv1.DirectMessageRequest{
+			name:  "Test direct message reaches recipient and sender",
+			users: []string{"dm-alice", "dm-bob", "dm-carol"},
+			steps: []chatStep{
 				{
-					Message: "Hello",
+					user:    "dm-alice",
+					reqData: &streamv1.DirectMessageRequest{Recipient: "dm-bob", Message: "Psst"},
+					resData: map[string]*streamv1.DirectMessageResponse{
+						"dm-alice": {Sender: "dm-alice", Recipient: "dm-bob", Message: "Psst"},
+						"dm-bob":   {Sender: "dm-alice", Recipient: "dm-bob", Message: "Psst", AckRequired: true},
+					},
 				},
 				{
-					Message: "How are you?",
+					user:    "dm-carol",
+					reqData: &streamv1.DirectMessageRequest{Recipient: "dm-carol", Message: "Nothing for me"},
+					resData: map[string]*streamv1.DirectMessageResponse{
+						"dm-carol": {Sender: "dm-carol", Recipient: "dm-carol", Message: "Nothing for me", AckRequired: true},
+					},
 				},
 			},
-			resData: []streamv1.DirectMessageResponse{
+		},
+		{
+			name:  "Test typing indicator reaches the recipient",
+			users: []string{"typing-alice", "typing-bob"},
+			steps: []chatStep{
 				{
-					Message: "Received message: Hello",
+					user:    "typing-alice",
+					reqData: &streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Typing{Typing: &streamv1.Typing{Recipient: "typing-bob"}}},
+					resData: map[string]*streamv1.DirectMessageResponse{
+						"typing-bob": {Event: &streamv1.DirectMessageResponse_Typing{Typing: &streamv1.TypingEvent{User: "typing-alice", Recipient: "typing-bob"}}},
+					},
 				},
 				{
-					Message: "Received message: How are you?",
+					user:    "typing-alice",
+					reqData: &streamv1.DirectMessageRequest{Recipient: "typing-bob", Message: "Done typing"},
+					resData: map[string]*streamv1.DirectMessageResponse{
+						"typing-alice": {Sender: "typing-alice", Recipient: "typing-bob", Message: "Done typing"},
+						"typing-bob":   {Sender: "typing-alice", Recipient: "typing-bob", Message: "Done typing", AckRequired: true},
+					},
+				},
+			},
+		},
+		{
+			name:  "Test message without room nor recipient",
+			users: []string{"invalid-alice"},
+			steps: []chatStep{
+				{
+					user:    "invalid-alice",
+					reqData: &streamv1.DirectMessageRequest{Message: "Hello?"},
 				},
 			},
+			expectedErr: connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("message must be sent to either a room or a recipient")),
 		},
 	}
 
@@ -143,30 +529,635 @@ func TestStreamService_DirectMessage(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx := context.Background()
-			stream := client.DirectMessage(ctx)
 
-			for idx, req := range tt.reqData {
-				err := stream.Send(&req)
+			// Open a stream for each user, exchanging a first message so the user is known to be connected
+			streams := make(map[string]*connect.BidiStreamForClient[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse])
+			for _, user := range tt.users {
+				stream := client.DirectMessage(ctx)
+				stream.RequestHeader().Set(testClientIDHeader, user)
+				streams[user] = stream
+
+				err := stream.Send(&streamv1.DirectMessageRequest{Recipient: user, Message: "Connected"})
+				assert.NoError(t, err)
+				res, err := receiveChatResponse(stream)
+				if !assert.NoError(t, err) {
+					return
+				}
+				ackTestMessage(t, stream, res)
+			}
+
+			for _, step := range tt.steps {
+				err := streams[step.user].Send(step.reqData)
 				assert.NoError(t, err)
 
-				res, err := stream.Receive()
+				for user, want := range step.resData {
+					res, err := receiveChatResponse(streams[user])
+					if !assert.NoError(t, err) {
+						return
+					}
+
+					if !cmp.Equal(
+						want, res,
+						cmpopts.IgnoreUnexported(streamv1.DirectMessageResponse{}, streamv1.TypingEvent{}),
+						cmpopts.IgnoreFields(streamv1.DirectMessageResponse{}, "Id", "SentAt"),
+					) {
+						t.Errorf("want[-], got[+]\n%v", cmp.Diff(
+							want, res,
+							cmpopts.IgnoreUnexported(streamv1.DirectMessageResponse{}, streamv1.TypingEvent{}),
+							cmpopts.IgnoreFields(streamv1.DirectMessageResponse{}, "Id", "SentAt"),
+						))
+					}
+
+					// Only messages, unlike events, carry an ID and a timestamp
+					if res.Event == nil {
+						if _, err := ksuid.Parse(res.Id); err != nil {
+							t.Errorf("ID is not a valid KSUID: %v", err)
+						}
+						assert.NotNil(t, res.SentAt)
+					}
+					ackTestMessage(t, streams[user], res)
+				}
+			}
+
+			if tt.expectedErr != nil {
+				_, err := receiveChatResponse(streams[tt.users[0]])
+				if !cmp.Equal(
+					tt.expectedErr.Error(), err.Error(),
+				) {
+					t.Errorf("want[-], got[+]\n%v", cmp.Diff(
+						tt.expectedErr.Error(), err.Error(),
+					))
+				}
+			}
+
+			for _, user := range tt.users {
+				err := streams[user].CloseRequest()
+				assert.NoError(t, err)
+				err = streams[user].CloseResponse()
 				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// receiveChatResponse receives the next response on a chat stream, skipping the presence events of the users coming and going
+func receiveChatResponse(stream *connect.BidiStreamForClient[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse]) (*streamv1.DirectMessageResponse, error) {
+	for {
+		res, err := stream.Receive()
+		if err != nil || res.GetPresence() == nil {
+			return res, err
+		}
+	}
+}
+
+// ackTestMessage acknowledges a received message if needed, so it does not stay in the inbox of the test user
+func ackTestMessage(t *testing.T, stream *connect.BidiStreamForClient[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse], res *streamv1.DirectMessageResponse) {
+	if res.AckRequired {
+		err := stream.Send(&streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Ack{Ack: &streamv1.AckMessage{Id: res.Id}}})
+		assert.NoError(t, err)
+	}
+}
+
+func TestStreamService_DirectMessageRedelivery(t *testing.T) {
+	// Name the users uniquely, as their inboxes outlive the test
+	alice, bob := "redelivery-alice-"+ksuid.New().String(), "redelivery-bob-"+ksuid.New().String()
+
+	client := streamv1connect.NewStreamServiceClient(
+		newInsecureClient(),
+		"http://127.0.0.1:8080",
+		connect.WithGRPC(),
+	)
+
+	// open opens a stream for the user, sending a first request so the user is known to be connected
+	open := func(ctx context.Context, user string) *connect.BidiStreamForClient[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse] {
+		stream := client.DirectMessage(ctx)
+		stream.RequestHeader().Set(testClientIDHeader, user)
+		err := stream.Send(&streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Join{Join: &streamv1.JoinRoom{Room: "redelivery-lobby"}}})
+		assert.NoError(t, err)
+		return stream
+	}
+	// receive checks the next message received on the stream, returning its ID
+	receive := func(stream *connect.BidiStreamForClient[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse], want *streamv1.DirectMessageResponse) string {
+		res, err := receiveChatResponse(stream)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		if !cmp.Equal(
+			want, res,
+			cmpopts.IgnoreUnexported(streamv1.DirectMessageResponse{}),
+			cmpopts.IgnoreFields(streamv1.DirectMessageResponse{}, "Id", "SentAt"),
+		) {
+			t.Errorf("want[-], got[+]\n%v", cmp.Diff(
+				want, res,
+				cmpopts.IgnoreUnexported(streamv1.DirectMessageResponse{}),
+				cmpopts.IgnoreFields(streamv1.DirectMessageResponse{}, "Id", "SentAt"),
+			))
+		}
+		return res.Id
+	}
+	// flush sends a message to the room the user joined and waits for it, so all prior requests are handled
+	flush := func(stream *connect.BidiStreamForClient[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse], user string) {
+		err := stream.Send(&streamv1.DirectMessageRequest{Room: "redelivery-lobby", Message: "Sync"})
+		assert.NoError(t, err)
+		receive(stream, &streamv1.DirectMessageResponse{Sender: user, Room: "redelivery-lobby", Message: "Sync"})
+	}
+	ack := func(stream *connect.BidiStreamForClient[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse], id string) {
+		err := stream.Send(&streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Ack{Ack: &streamv1.AckMessage{Id: id}}})
+		assert.NoError(t, err)
+	}
+
+	// Alice writes to Bob while he is offline
+	aliceStream := open(context.Background(), alice)
+	texts := []string{"First", "Second", "Third"}
+	for _, text := range texts {
+		err := aliceStream.Send(&streamv1.DirectMessageRequest{Recipient: bob, Message: text})
+		assert.NoError(t, err)
+		receive(aliceStream, &streamv1.DirectMessageResponse{Sender: alice, Recipient: bob, Message: text})
+	}
+	assert.NoError(t, aliceStream.CloseRequest())
+	assert.NoError(t, aliceStream.CloseResponse())
+
+	// Bob connects and gets the messages in order, but his stream is killed after acknowledging the first one only
+	ctx, cancel := context.WithCancel(context.Background())
+	bobStream := open(ctx, bob)
+	ids := make([]string, 0, len(texts))
+	for _, text := range texts {
+		ids = append(ids, receive(bobStream, &streamv1.DirectMessageResponse{Sender: alice, Recipient: bob, Message: text, AckRequired: true}))
+	}
+	ack(bobStream, ids[0])
+	flush(bobStream, bob)
+	cancel()
+
+	// Once reconnected, Bob gets the unacknowledged messages again
+	bobStream = open(context.Background(), bob)
+	for idx, text := range texts[1:] {
+		id := receive(bobStream, &streamv1.DirectMessageResponse{Sender: alice, Recipient: bob, Message: text, AckRequired: true, Redelivered: true})
+		assert.Equal(t, ids[idx+1], id)
+		ack(bobStream, id)
+	}
+	flush(bobStream, bob)
+	assert.NoError(t, bobStream.CloseRequest())
+	assert.NoError(t, bobStream.CloseResponse())
+
+	// With everything acknowledged, nothing is pending when Bob reconnects
+	bobStream = open(context.Background(), bob)
+	flush(bobStream, bob)
+	assert.NoError(t, bobStream.CloseRequest())
+	assert.NoError(t, bobStream.CloseResponse())
+}
+
+func TestStreamService_ListOnline(t *testing.T) {
+	// Name the user uniquely, as other tests may leave their users connected for a moment
+	user := "online-" + ksuid.New().String()
+
+	client := streamv1connect.NewStreamServiceClient(
+		newInsecureClient(),
+		"http://127.0.0.1:8080",
+		connect.WithGRPC(),
+	)
+
+	// isOnline lists the connected users, reporting whether the user is one of them
+	isOnline := func() bool {
+		res, err := client.ListOnline(context.Background(), connect.NewRequest(&streamv1.ListOnlineRequest{}))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		for _, presence := range res.Msg.Users {
+			if presence.User == user {
+				assert.Equal(t, streamv1.PresenceStatus_PRESENCE_STATUS_ONLINE, presence.Status)
+				return true
+			}
+		}
+		return false
+	}
+	assert.False(t, isOnline())
+
+	// Connecting reports the user online, to everyone including itself
+	stream := client.DirectMessage(context.Background())
+	stream.RequestHeader().Set(testClientIDHeader, user)
+	err := stream.Send(&streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Heartbeat{Heartbeat: &streamv1.Heartbeat{}}})
+	assert.NoError(t, err)
+	for {
+		res, err := stream.Receive()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		if res.GetPresence().GetUser() == user {
+			assert.Equal(t, streamv1.PresenceStatus_PRESENCE_STATUS_ONLINE, res.GetPresence().GetStatus())
+			break
+		}
+	}
+	assert.True(t, isOnline())
+
+	// Once the stream closes, the user is eventually no longer listed
+	assert.NoError(t, stream.CloseRequest())
+	assert.NoError(t, stream.CloseResponse())
+	assert.Eventually(t, func() bool { return !isOnline() }, time.Second, 10*time.Millisecond)
+}
+
+func TestStreamService_GetHistory(t *testing.T) {
+	client := streamv1connect.NewStreamServiceClient(
+		newInsecureClient(),
+		"http://127.0.0.1:8080",
+		connect.WithGRPC(),
+	)
+
+	// Exchange the messages to be found in the history, as users not used by other tests
+	stream := client.DirectMessage(context.Background())
+	stream.RequestHeader().Set(testClientIDHeader, "history-alice")
+	for _, req := range []*streamv1.DirectMessageRequest{
+		{Recipient: "history-bob", Message: "Hi Bob"},
+		{Room: "history-room", Message: "Hi room"},
+		{Recipient: "history-bob", Message: "How are you?"},
+		{Room: "history-room", Message: "Anyone?"},
+		{Recipient: "history-bob", Message: "Bye"},
+	} {
+		err := stream.Send(req)
+		assert.NoError(t, err)
+		_, err = receiveChatResponse(stream)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, stream.CloseRequest())
+	assert.NoError(t, stream.CloseResponse())
+
+	tests := []struct {
+		name        string
+		caller      string
+		reqData     *streamv1.GetHistoryRequest
+		messages    []string
+		hasMore     bool
+		expectedErr error
+	}{
+		{
+			name:     "Test history of direct messages as the recipient",
+			caller:   "history-bob",
+			reqData:  &streamv1.GetHistoryRequest{Peer: "history-alice"},
+			messages: []string{"Hi Bob", "How are you?", "Bye"},
+		},
+		{
+			name:     "Test history of direct messages as the sender",
+			caller:   "history-alice",
+			reqData:  &streamv1.GetHistoryRequest{Peer: "history-bob"},
+			messages: []string{"Hi Bob", "How are you?", "Bye"},
+		},
+		{
+			name:     "Test history of direct messages limited",
+			caller:   "history-bob",
+			reqData:  &streamv1.GetHistoryRequest{Peer: "history-alice", Limit: 2},
+			messages: []string{"How are you?", "Bye"},
+			hasMore:  true,
+		},
+		{
+			name:     "Test history of a room",
+			caller:   "history-carol",
+			reqData:  &streamv1.GetHistoryRequest{Room: "history-room"},
+			messages: []string{"Hi room", "Anyone?"},
+		},
+		{
+			name:     "Test history of direct messages exchanged by others",
+			caller:   "history-carol",
+			reqData:  &streamv1.GetHistoryRequest{Peer: "history-alice"},
+			messages: []string{},
+		},
+		{
+			name:        "Test history of both a room and a peer",
+			caller:      "history-bob",
+			reqData:     &streamv1.GetHistoryRequest{Room: "history-room", Peer: "history-alice"},
+			expectedErr: connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("history must be requested for either a room or a peer")),
+		},
+		{
+			name:        "Test history with invalid cursor",
+			caller:      "history-bob",
+			reqData:     &streamv1.GetHistoryRequest{Peer: "history-alice", Before: "not-a-cursor"},
+			expectedErr: connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid history cursor")),
+		},
+		{
+			name:        "Test history with negative limit",
+			caller:      "history-bob",
+			reqData:     &streamv1.GetHistoryRequest{Peer: "history-alice", Limit: -1},
+			expectedErr: connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("limit must not be negative")),
+		},
+	}
 
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := connect.NewRequest(tt.reqData)
+			req.Header().Set(testClientIDHeader, tt.caller)
+
+			res, err := client.GetHistory(context.Background(), req)
+			if tt.expectedErr != nil {
 				if !cmp.Equal(
-					tt.resData[idx], *res,
-					cmpopts.IgnoreUnexported(streamv1.DirectMessageResponse{}),
+					tt.expectedErr.Error(), err.Error(),
 				) {
 					t.Errorf("want[-], got[+]\n%v", cmp.Diff(
-						tt.resData[idx], *res,
-						cmpopts.IgnoreUnexported(streamv1.DirectMessageResponse{}),
+						tt.expectedErr.Error(), err.Error(),
 					))
 				}
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
 			}
 
-			err := stream.CloseRequest()
-			assert.NoError(t, err)
-			err = stream.CloseResponse()
-			assert.NoError(t, err)
+			messages := []string{}
+			for _, msg := range res.Msg.Messages {
+				messages = append(messages, msg.Message)
+				assert.NotEmpty(t, msg.Cursor)
+			}
+			if !cmp.Equal(tt.messages, messages) {
+				t.Errorf("want[-], got[+]\n%v", cmp.Diff(tt.messages, messages))
+			}
+			assert.Equal(t, tt.hasMore, res.Msg.HasMore)
 		})
 	}
 }
+
+// slowResponseWriter delays every write, as if the client were behind a slow network
+type slowResponseWriter struct {
+	http.ResponseWriter
+	delay time.Duration
+}
+
+func (w *slowResponseWriter) Write(data []byte) (int, error) {
+	time.Sleep(w.delay)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *slowResponseWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *slowResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestStreamService_DirectMessageSlowConsumer(t *testing.T) {
+	// Serve a hub disconnecting slow consumers, apart from the one shared by the other tests
+	inboxes, err := chat.OpenInboxes(t.TempDir(), 0)
+	assert.NoError(t, err)
+	history, err := chat.OpenHistory("", 0)
+	assert.NoError(t, err)
+	hub := chat.NewHub(inboxes, history, chat.Options{QueueSize: 2, QueuePolicy: chat.QueueDisconnect})
+	defer hub.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&StreamService{
+		Hub: hub,
+	}, connect.WithInterceptors(&identityInterceptor{})))
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Bob is behind a slow network
+		if r.Header.Get(testClientIDHeader) == "slow-bob" {
+			w = &slowResponseWriter{ResponseWriter: w, delay: 100 * time.Millisecond}
+		}
+		ReceiveAbortHandler(mux).ServeHTTP(w, r)
+	}), &http2.Server{}))
+	defer server.Close()
+
+	client := streamv1connect.NewStreamServiceClient(
+		newInsecureClient(),
+		server.URL,
+		connect.WithGRPC(),
+	)
+
+	// Bob joins the room, and his messages pile up as they are sent to him slowly
+	bob := client.DirectMessage(context.Background())
+	bob.RequestHeader().Set(testClientIDHeader, "slow-bob")
+	err = bob.Send(&streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Join{Join: &streamv1.JoinRoom{Room: "slow-room"}}})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return hub.QueueStats().Participants == 1 }, time.Second, 10*time.Millisecond)
+
+	// Alice keeps writing to the room, reading each of her messages before the next
+	alice := client.DirectMessage(context.Background())
+	alice.RequestHeader().Set(testClientIDHeader, "slow-alice")
+	for i := 0; i < 20 && hub.QueueStats().Disconnected == 0; i++ {
+		err := alice.Send(&streamv1.DirectMessageRequest{Room: "slow-room", Message: "Hello"})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		for {
+			res, err := alice.Receive()
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			if res.Message == "Hello" {
+				break
+			}
+		}
+	}
+	assert.Equal(t, uint64(1), hub.QueueStats().Disconnected)
+
+	// Once the queued messages are read, Bob's stream ends with a clear status
+	for {
+		_, err := bob.Receive()
+		if err != nil {
+			assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+			break
+		}
+	}
+
+	assert.NoError(t, alice.CloseRequest())
+	assert.NoError(t, alice.CloseResponse())
+}
+
+// answerPings answers the pings received on a chat stream until it ends, returning the number of pings and the error it ended with
+func answerPings(stream *connect.BidiStreamForClient[streamv1.DirectMessageRequest, streamv1.DirectMessageResponse]) (int, error) {
+	pings := 0
+	for {
+		res, err := stream.Receive()
+		if err != nil {
+			return pings, err
+		}
+		if res.GetPing() != nil {
+			pings++
+			// A failed send means the stream ended, the next receive returning why
+			_ = stream.Send(&streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Pong{Pong: &streamv1.Pong{Id: res.GetPing().Id}}})
+		}
+	}
+}
+
+func TestStreamService_StreamTimeouts(t *testing.T) {
+	// Serve streams pinged and closed quickly, apart from the server shared by the other tests
+	files, err := storage.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	inboxes, err := chat.OpenInboxes(t.TempDir(), 0)
+	assert.NoError(t, err)
+	history, err := chat.OpenHistory("", 0)
+	assert.NoError(t, err)
+	hub := chat.NewHub(inboxes, history, chat.Options{})
+	defer hub.Close()
+	returned := &returnedInterceptor{}
+
+	mux := http.NewServeMux()
+	mux.Handle(streamv1connect.NewStreamServiceHandler(&StreamService{
+		Files:        files,
+		Hub:          hub,
+		PingInterval: 50 * time.Millisecond,
+		IdleTimeout:  300 * time.Millisecond,
+		MaxLifetime:  time.Second,
+	}, connect.WithInterceptors(&identityInterceptor{}, returned)))
+	server := httptest.NewServer(h2c.NewHandler(ReceiveAbortHandler(mux), &http2.Server{}))
+	defer server.Close()
+
+	client := streamv1connect.NewStreamServiceClient(
+		newInsecureClient(),
+		server.URL,
+		connect.WithGRPC(),
+	)
+
+	// Answering the pings keeps a chat stream open, until its maximum lifetime
+	start := time.Now()
+	alive := client.DirectMessage(context.Background())
+	alive.RequestHeader().Set(testClientIDHeader, "timeout-alice")
+	assert.NoError(t, alive.Send(&streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Heartbeat{Heartbeat: &streamv1.Heartbeat{}}}))
+	pings, err := answerPings(alive)
+	assert.Equal(t, connect.CodeDeadlineExceeded, connect.CodeOf(err))
+	assert.ErrorContains(t, err, "maximum lifetime")
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Greater(t, pings, 5)
+
+	// Without answering them, a chat stream is closed once idle
+	start = time.Now()
+	idle := client.DirectMessage(context.Background())
+	idle.RequestHeader().Set(testClientIDHeader, "timeout-bob")
+	assert.NoError(t, idle.Send(&streamv1.DirectMessageRequest{Kind: &streamv1.DirectMessageRequest_Heartbeat{Heartbeat: &streamv1.Heartbeat{}}}))
+	for {
+		res, err := idle.Receive()
+		if err != nil {
+			assert.Equal(t, connect.CodeDeadlineExceeded, connect.CodeOf(err))
+			assert.ErrorContains(t, err, "without activity")
+			break
+		}
+		assert.Empty(t, res.Message)
+	}
+	assert.Less(t, time.Since(start), time.Second)
+
+	// An upload stream is closed once idle as well
+	upload := client.UploadFile(context.Background())
+	assert.NoError(t, upload.Send(&streamv1.UploadFileRequest{FileName: "idle.txt", Chunk: []byte("Hello")}))
+	time.Sleep(500 * time.Millisecond)
+	_, err = upload.CloseAndReceive()
+	assert.Equal(t, connect.CodeDeadlineExceeded, connect.CodeOf(err))
+	assert.ErrorContains(t, err, "without activity")
+	list, _, err := files.List(storage.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+
+	// The handlers stopped receiving before they returned, leaving a moment for late receives to show up
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, returned.usedAfterReturn.Load())
+}
+
+// returnedInterceptor records whether a stream was used once its handler returned, which connect forbids
+type returnedInterceptor struct {
+	usedAfterReturn atomic.Bool
+}
+
+func (i *returnedInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return next
+}
+
+func (i *returnedInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *returnedInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		watched := &returnedHandlerConn{StreamingHandlerConn: conn, interceptor: i}
+		err := next(ctx, watched)
+		watched.returned.Store(true)
+		return err
+	}
+}
+
+// returnedHandlerConn reports the receives and sends ending once the handler returned
+type returnedHandlerConn struct {
+	connect.StreamingHandlerConn
+	interceptor *returnedInterceptor
+	returned    atomic.Bool
+}
+
+func (c *returnedHandlerConn) Receive(msg any) error {
+	err := c.StreamingHandlerConn.Receive(msg)
+	if c.returned.Load() {
+		c.interceptor.usedAfterReturn.Store(true)
+	}
+	return err
+}
+
+func (c *returnedHandlerConn) Send(msg any) error {
+	err := c.StreamingHandlerConn.Send(msg)
+	if c.returned.Load() {
+		c.interceptor.usedAfterReturn.Store(true)
+	}
+	return err
+}
+
+func TestStreamService_Broadcast(t *testing.T) {
+	client := streamv1connect.NewStreamServiceClient(
+		newInsecureClient(),
+		"http://localhost:8080",
+		connect.WithGRPC(),
+	)
+	topic := "maintenance-" + ksuid.New().String()
+
+	publish := func(admin bool, message string) (*streamv1.PublishResponse, error) {
+		req := connect.NewRequest(&streamv1.PublishRequest{Topic: topic, Message: message})
+		req.Header().Set(testClientIDHeader, "ops")
+		if admin {
+			req.Header().Set(testAdminHeader, "true")
+		}
+		res, err := client.Publish(context.Background(), req)
+		if err != nil {
+			return nil, err
+		}
+		return res.Msg, nil
+	}
+
+	// Only admins may publish, and only non-empty announcements
+	_, err := publish(false, "Maintenance tonight")
+	assert.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+	_, err = publish(true, "")
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	// Announcements published before any subscription are retained, up to the retention of the broker
+	for _, message := range []string{"Maintenance 1", "Maintenance 2", "Maintenance 3"} {
+		res, err := publish(true, message)
+		assert.NoError(t, err)
+		assert.Zero(t, res.Subscribers)
+	}
+
+	// A late subscriber receives the retained announcements first
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Subscribe(ctx, connect.NewRequest(&streamv1.SubscribeRequest{Topics: []string{topic, topic}}))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for _, message := range []string{"Maintenance 2", "Maintenance 3"} {
+		if !assert.True(t, stream.Receive(), stream.Err()) {
+			t.FailNow()
+		}
+		assert.Equal(t, message, stream.Msg().Message)
+		assert.Equal(t, "ops", stream.Msg().Publisher)
+		assert.True(t, stream.Msg().Retained)
+	}
+
+	// Then the announcements published from now on, once per topic
+	res, err := publish(true, "Maintenance 4")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), res.Subscribers)
+	if !assert.True(t, stream.Receive(), stream.Err()) {
+		t.FailNow()
+	}
+	assert.Equal(t, res.Id, stream.Msg().Id)
+	assert.Equal(t, "Maintenance 4", stream.Msg().Message)
+	assert.False(t, stream.Msg().Retained)
+	cancel()
+	assert.NoError(t, stream.Close())
+
+	// Subscribing requires a topic
+	stream, err = client.Subscribe(context.Background(), connect.NewRequest(&streamv1.SubscribeRequest{}))
+	assert.NoError(t, err)
+	assert.False(t, stream.Receive())
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(stream.Err()))
+}
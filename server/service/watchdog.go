@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"connectrpc.com/connect"
+)
+
+// watchdog closes streams receiving nothing for longer than the idle timeout, or open for longer than the max lifetime
+type watchdog struct {
+	activity chan struct{}
+	expiry   chan error
+	done     chan struct{}
+}
+
+// startWatchdog starts watching a stream, a zero timeout disabling the matching check
+func startWatchdog(idleTimeout, maxLifetime time.Duration) *watchdog {
+	w := &watchdog{
+		activity: make(chan struct{}, 1),
+		expiry:   make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go w.run(idleTimeout, maxLifetime)
+	return w
+}
+
+// touch records an activity on the stream, postponing the idle timeout
+func (w *watchdog) touch() {
+	// A pending activity is enough, the watchdog does not need to see each of them
+	select {
+	case w.activity <- struct{}{}:
+	default:
+	}
+}
+
+// expired returns a channel receiving the error to end the stream with, once it expired
+func (w *watchdog) expired() <-chan error {
+	return w.expiry
+}
+
+// stop stops watching the stream
+func (w *watchdog) stop() {
+	close(w.done)
+}
+
+func (w *watchdog) run(idleTimeout, maxLifetime time.Duration) {
+	// Nil channels never fire, so the disabled checks are left out of the select
+	var idle, lifetime <-chan time.Time
+	var idleTimer *time.Timer
+	if idleTimeout > 0 {
+		idleTimer = time.NewTimer(idleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+	if maxLifetime > 0 {
+		lifetimeTimer := time.NewTimer(maxLifetime)
+		defer lifetimeTimer.Stop()
+		lifetime = lifetimeTimer.C
+	}
+
+	for {
+		select {
+		case <-w.activity:
+			if idleTimer == nil {
+				continue
+			}
+			// Drain the timer if it fired in the meantime, so the reset starts from a clean slate
+			if !idleTimer.Stop() {
+				select {
+				case <-idleTimer.C:
+				default:
+				}
+			}
+			idleTimer.Reset(idleTimeout)
+		case <-idle:
+			w.expiry <- connect.NewError(connect.CodeDeadlineExceeded, fmt.Errorf("stream closed after %s without activity", idleTimeout))
+			return
+		case <-lifetime:
+			w.expiry <- connect.NewError(connect.CodeDeadlineExceeded, fmt.Errorf("stream closed after reaching its maximum lifetime of %s", maxLifetime))
+			return
+		case <-w.done:
+			return
+		}
+	}
+}
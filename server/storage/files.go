@@ -0,0 +1,453 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// DefaultPageSize is the number of files listed when no page size is requested
+	DefaultPageSize = 50
+	// MaxPageSize is the maximum number of files listed in a single page
+	MaxPageSize = 1000
+
+	indexFileName = "index.json"
+	blobsDirName  = "blobs"
+	tmpDirName    = "tmp"
+
+	// sniffLen is the number of bytes used to detect the content type (see http.DetectContentType)
+	sniffLen = 512
+)
+
+var (
+	// ErrNotFound is returned when a file does not exist in the store
+	ErrNotFound = errors.New("file not found")
+	// ErrInvalidPageToken is returned when listing files with a malformed page token
+	ErrInvalidPageToken = errors.New("invalid page token")
+)
+
+// FileInfo holds the metadata of a stored file
+type FileInfo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	Digest      string    `json:"sha256"`
+	ContentType string    `json:"content_type"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	Owner       string    `json:"owner"`
+}
+
+// ListOptions filters and paginates the files returned by FileStore.List
+type ListOptions struct {
+	NamePrefix string
+	Owner      string
+	PageSize   int
+	PageToken  string
+}
+
+// FileStore stores uploaded files on disk, along with a metadata index that survives restarts
+// File contents are stored once per SHA-256 digest, shared by all files with identical content
+type FileStore struct {
+	dir   string
+	mutex sync.RWMutex
+	files map[string]FileInfo
+	refs  map[string]int // Number of files referencing each stored content, by digest
+}
+
+// NewFileStore opens (or creates) a file store in the given directory, loading its metadata index
+func NewFileStore(dir string) (*FileStore, error) {
+	for _, d := range []string{dir, filepath.Join(dir, blobsDirName), filepath.Join(dir, tmpDirName)} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create storage directory: %w", err)
+		}
+	}
+
+	// Remove any leftovers of uploads interrupted by a previous shutdown
+	leftovers, err := filepath.Glob(filepath.Join(dir, tmpDirName, "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, leftover := range leftovers {
+		_ = os.Remove(leftover)
+	}
+
+	s := &FileStore{
+		dir:   dir,
+		files: make(map[string]FileInfo),
+		refs:  make(map[string]int),
+	}
+
+	// Load the metadata index, if one was persisted before
+	data, err := os.ReadFile(filepath.Join(dir, indexFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file index: %w", err)
+	}
+	var files []FileInfo
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("failed to parse file index: %w", err)
+	}
+	for _, f := range files {
+		s.files[f.ID] = f
+		s.refs[f.Digest]++
+	}
+
+	return s, nil
+}
+
+// NewUpload starts staging a new file; the file is only visible in the store once committed
+func (s *FileStore) NewUpload() (*Upload, error) {
+	file, err := os.CreateTemp(filepath.Join(s.dir, tmpDirName), "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+
+	return &Upload{
+		store: s,
+		file:  file,
+		hash:  sha256.New(),
+	}, nil
+}
+
+// Link stores a new file sharing the content with the given digest already stored by the same owner,
+// returning ErrNotFound if the owner stores no such content
+// Content stored by other owners is not linked, so callers can neither claim content they never uploaded
+// nor learn whether others stored it; theirs is still deduplicated once uploaded in full
+func (s *FileStore) Link(digest, name, owner string) (FileInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.owns(digest, owner) {
+		return FileInfo{}, ErrNotFound
+	}
+
+	// Read back the start of the content, to detect its content type
+	blob, err := os.Open(s.blobPath(digest))
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("failed to open file content: %w", err)
+	}
+	defer blob.Close()
+	stat, err := blob.Stat()
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("failed to open file content: %w", err)
+	}
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(blob, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return FileInfo{}, fmt.Errorf("failed to read file content: %w", err)
+	}
+
+	return s.add(FileInfo{
+		ID:          ksuid.New().String(),
+		Name:        name,
+		Size:        stat.Size(),
+		Digest:      digest,
+		ContentType: detectContentType(name, head[:n]),
+		UploadedAt:  time.Now().UTC(),
+		Owner:       owner,
+	})
+}
+
+// owns reports whether the owner stores a file with the given digest, the caller holding the lock
+func (s *FileStore) owns(digest, owner string) bool {
+	if s.refs[digest] == 0 {
+		return false
+	}
+	for _, info := range s.files {
+		if info.Digest == digest && info.Owner == owner {
+			return true
+		}
+	}
+	return false
+}
+
+// Stat returns the metadata of a file
+func (s *FileStore) Stat(id string) (FileInfo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	info, ok := s.files[id]
+	if !ok {
+		return FileInfo{}, ErrNotFound
+	}
+	return info, nil
+}
+
+// List returns a page of files matching the options, ordered by upload time, and the token of the next page
+// The token is empty when there are no more files to list
+func (s *FileStore) List(opts ListOptions) ([]FileInfo, string, error) {
+	pageSize := opts.PageSize
+	switch {
+	case pageSize < 0:
+		return nil, "", fmt.Errorf("invalid page size: %d", pageSize)
+	case pageSize == 0:
+		pageSize = DefaultPageSize
+	case pageSize > MaxPageSize:
+		pageSize = MaxPageSize
+	}
+
+	// The page token points to the last file of the previous page
+	var after *FileInfo
+	if opts.PageToken != "" {
+		cursor, err := parsePageToken(opts.PageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		after = &cursor
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var matches []FileInfo
+	for _, f := range s.files {
+		if after != nil && !listedBefore(*after, f) {
+			continue
+		}
+		if opts.Owner != "" && f.Owner != opts.Owner {
+			continue
+		}
+		if !strings.HasPrefix(f.Name, opts.NamePrefix) {
+			continue
+		}
+		matches = append(matches, f)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return listedBefore(matches[i], matches[j])
+	})
+
+	if len(matches) <= pageSize {
+		return matches, "", nil
+	}
+	return matches[:pageSize], pageToken(matches[pageSize-1]), nil
+}
+
+// Usage returns the number of files stored, and the bytes taken by their contents (each stored once)
+func (s *FileStore) Usage() (int, int64) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var bytes int64
+	counted := make(map[string]struct{}, len(s.refs))
+	for _, f := range s.files {
+		if _, ok := counted[f.Digest]; ok {
+			continue
+		}
+		counted[f.Digest] = struct{}{}
+		bytes += f.Size
+	}
+	return len(s.files), bytes
+}
+
+// Delete removes a file from the store, returning its metadata
+func (s *FileStore) Delete(id string) (FileInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info, ok := s.files[id]
+	if !ok {
+		return FileInfo{}, ErrNotFound
+	}
+
+	// Remove the file from the index first, so a failure cannot leave an entry without content
+	delete(s.files, id)
+	if err := s.persist(); err != nil {
+		s.files[id] = info
+		return FileInfo{}, err
+	}
+
+	// Only reclaim the content once the last file referencing it is gone
+	s.refs[info.Digest]--
+	if s.refs[info.Digest] > 0 {
+		return info, nil
+	}
+	delete(s.refs, info.Digest)
+	if err := os.Remove(s.blobPath(info.Digest)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return FileInfo{}, fmt.Errorf("failed to remove file content: %w", err)
+	}
+
+	return info, nil
+}
+
+// add indexes a new file whose content is already stored; the mutex must be held by the caller
+func (s *FileStore) add(info FileInfo) (FileInfo, error) {
+	s.files[info.ID] = info
+	if err := s.persist(); err != nil {
+		delete(s.files, info.ID)
+		return FileInfo{}, err
+	}
+	s.refs[info.Digest]++
+
+	return info, nil
+}
+
+// blobPath returns the path on disk of the content with the given digest
+func (s *FileStore) blobPath(digest string) string {
+	return filepath.Join(s.dir, blobsDirName, digest)
+}
+
+// persist atomically writes the metadata index to disk; the mutex must be held by the caller
+func (s *FileStore) persist() error {
+	files := make([]FileInfo, 0, len(s.files))
+	for _, f := range s.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ID < files[j].ID
+	})
+
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := WriteFileAtomic(filepath.Join(s.dir, indexFileName), data); err != nil {
+		return fmt.Errorf("failed to write file index: %w", err)
+	}
+
+	return nil
+}
+
+// Upload is a file being written to the store
+type Upload struct {
+	store *FileStore
+	file  *os.File
+	hash  hash.Hash
+	size  int64
+	head  []byte // First bytes of the file, used to detect its content type
+	done  bool
+}
+
+// Write appends data to the uploaded file
+func (u *Upload) Write(p []byte) (int, error) {
+	if len(u.head) < sniffLen {
+		u.head = append(u.head, p[:min(len(p), sniffLen-len(u.head))]...)
+	}
+
+	n, err := u.file.Write(p)
+	u.hash.Write(p[:n])
+	u.size += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes written so far
+func (u *Upload) Size() int64 {
+	return u.size
+}
+
+// Digest returns the hex-encoded SHA-256 digest of the bytes written so far
+func (u *Upload) Digest() string {
+	return hex.EncodeToString(u.hash.Sum(nil))
+}
+
+// Commit stores the uploaded file under the given name and owner, making it visible in the store
+// It also reports whether identical content was already stored, in which case the content is shared
+func (u *Upload) Commit(name, owner string) (FileInfo, bool, error) {
+	if u.done {
+		return FileInfo{}, false, fmt.Errorf("upload already finished")
+	}
+	u.done = true
+	defer os.Remove(u.file.Name())
+
+	if err := u.file.Sync(); err != nil {
+		u.file.Close()
+		return FileInfo{}, false, fmt.Errorf("failed to write file content: %w", err)
+	}
+	if err := u.file.Close(); err != nil {
+		return FileInfo{}, false, fmt.Errorf("failed to write file content: %w", err)
+	}
+
+	info := FileInfo{
+		ID:          ksuid.New().String(),
+		Name:        name,
+		Size:        u.size,
+		Digest:      u.Digest(),
+		ContentType: detectContentType(name, u.head),
+		UploadedAt:  time.Now().UTC(),
+		Owner:       owner,
+	}
+
+	s := u.store
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Identical content is already stored, share it instead of storing it again
+	if s.refs[info.Digest] > 0 {
+		stored, err := s.add(info)
+		return stored, err == nil, err
+	}
+
+	// Move the content in place before indexing it, so the index never points to missing content
+	if err := os.Rename(u.file.Name(), s.blobPath(info.Digest)); err != nil {
+		return FileInfo{}, false, fmt.Errorf("failed to store file content: %w", err)
+	}
+	stored, err := s.add(info)
+	if err != nil {
+		_ = os.Remove(s.blobPath(info.Digest))
+		return FileInfo{}, false, err
+	}
+
+	return stored, false, nil
+}
+
+// Abort discards the uploaded file; it is a no-op if the upload was already committed
+func (u *Upload) Abort() {
+	if u.done {
+		return
+	}
+	u.done = true
+	u.file.Close()
+	_ = os.Remove(u.file.Name())
+}
+
+// listedBefore reports whether file a is listed before file b, ordering by upload time and then ID
+func listedBefore(a, b FileInfo) bool {
+	if !a.UploadedAt.Equal(b.UploadedAt) {
+		return a.UploadedAt.Before(b.UploadedAt)
+	}
+	return a.ID < b.ID
+}
+
+// pageToken builds the page token pointing after the given file
+func pageToken(f FileInfo) string {
+	return fmt.Sprintf("%d.%s", f.UploadedAt.UnixNano(), f.ID)
+}
+
+// parsePageToken parses a page token into the (partial) file it points after
+func parsePageToken(token string) (FileInfo, error) {
+	nanos, id, ok := strings.Cut(token, ".")
+	if !ok || id == "" {
+		return FileInfo{}, ErrInvalidPageToken
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return FileInfo{}, ErrInvalidPageToken
+	}
+	return FileInfo{ID: id, UploadedAt: time.Unix(0, n).UTC()}, nil
+}
+
+// detectContentType guesses the content type of a file from its name, falling back to its content
+func detectContentType(name string, head []byte) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(head)
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+)
+
+// storeTestFile uploads and commits a file to the store
+func storeTestFile(t *testing.T, store *FileStore, name, owner string, content []byte) FileInfo {
+	t.Helper()
+
+	upload, err := store.NewUpload()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = upload.Write(content)
+	assert.NoError(t, err)
+
+	info, _, err := upload.Commit(name, owner)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return info
+}
+
+func TestFileStore_Reopen(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewFileStore(dir)
+	assert.NoError(t, err)
+
+	kept := storeTestFile(t, store, "kept.txt", "owner", []byte("Kept across restarts"))
+	deleted := storeTestFile(t, store, "deleted.txt", "owner", []byte("Deleted before restart"))
+	_, err = store.Delete(deleted.ID)
+	assert.NoError(t, err)
+
+	// An upload in progress during the shutdown must not show up after the restart
+	upload, err := store.NewUpload()
+	assert.NoError(t, err)
+	_, err = upload.Write([]byte("Interrupted"))
+	assert.NoError(t, err)
+
+	// Reopen the store from the same directory, as a restarted server would
+	reopened, err := NewFileStore(dir)
+	assert.NoError(t, err)
+
+	info, err := reopened.Stat(kept.ID)
+	assert.NoError(t, err)
+	if !cmp.Equal(kept, info) {
+		t.Errorf("want[-], got[+]\n%v", cmp.Diff(kept, info))
+	}
+
+	_, err = reopened.Stat(deleted.ID)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	files, _, err := reopened.List(ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestFileStore_Abort(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+
+	upload, err := store.NewUpload()
+	assert.NoError(t, err)
+	_, err = upload.Write([]byte("Aborted"))
+	assert.NoError(t, err)
+	upload.Abort()
+
+	// Committing an aborted upload must fail and leave the store empty
+	_, _, err = upload.Commit("aborted.txt", "owner")
+	assert.Error(t, err)
+
+	files, _, err := store.List(ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestFileStore_Deduplication(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.NoError(t, err)
+
+	first := storeTestFile(t, store, "first.txt", "owner-1", []byte("Shared content"))
+	second := storeTestFile(t, store, "second.txt", "owner-2", []byte("Shared content"))
+	assert.Equal(t, first.Digest, second.Digest)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	// Linking an unknown digest must fail, as well as content stored by other owners only,
+	// while content stored by the same owner is shared
+	_, err = store.Link("0000000000000000000000000000000000000000000000000000000000000000", "unknown.txt", "owner")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = store.Link(first.Digest, "linked.txt", "owner-3")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	linked, err := store.Link(first.Digest, "linked.txt", "owner-1")
+	assert.NoError(t, err)
+	assert.Equal(t, first.Size, linked.Size)
+	assert.Equal(t, "text/plain; charset=utf-8", linked.ContentType)
+
+	// All three files share a single stored content
+	blobs, err := os.ReadDir(filepath.Join(dir, blobsDirName))
+	assert.NoError(t, err)
+	assert.Len(t, blobs, 1)
+	count, bytes := store.Usage()
+	assert.Equal(t, 3, count)
+	assert.Equal(t, first.Size, bytes)
+
+	// The content is only reclaimed once the last reference is deleted
+	for _, id := range []string{first.ID, second.ID} {
+		_, err = store.Delete(id)
+		assert.NoError(t, err)
+		_, err = os.Stat(store.blobPath(first.Digest))
+		assert.NoError(t, err)
+	}
+	_, err = store.Delete(linked.ID)
+	assert.NoError(t, err)
+	_, err = os.Stat(store.blobPath(first.Digest))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	// Once reclaimed, the content can no longer be linked
+	_, err = store.Link(first.Digest, "linked.txt", "owner-1")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
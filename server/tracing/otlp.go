@@ -0,0 +1,263 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultBatchSize is the number of spans sent at most per export request
+	DefaultBatchSize = 512
+	// DefaultFlushInterval is how long ended spans wait at most before being exported
+	DefaultFlushInterval = 5 * time.Second
+	// DefaultQueueSize is the number of ended spans kept waiting for export, the next ones being dropped
+	DefaultQueueSize = 2048
+)
+
+// OTLPExporter exports the spans in batches to an OTLP collector, over HTTP with the JSON encoding
+// (https://opentelemetry.io/docs/specs/otlp/#otlphttp)
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+
+	batchSize     int
+	flushInterval time.Duration
+
+	queue   chan *Span
+	flush   chan chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+// NewOTLPExporter starts exporting the spans of the service to the traces endpoint of a collector
+// (e.g. http://localhost:4318/v1/traces), until shut down
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint:      endpoint,
+		serviceName:   serviceName,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		queue:         make(chan *Span, DefaultQueueSize),
+		flush:         make(chan chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// ExportSpan queues an ended span for export, dropping it if the queue is full
+func (e *OTLPExporter) ExportSpan(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of spans dropped because the queue was full
+func (e *OTLPExporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// Flush exports the queued spans right away, returning once they are sent or the context is done
+func (e *OTLPExporter) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case e.flush <- flushed:
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports the queued spans, then stops exporting
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	err := e.Flush(ctx)
+	e.once.Do(func() { close(e.done) })
+	return err
+}
+
+// run exports the queued spans whenever a batch is full or the flush interval elapses
+func (e *OTLPExporter) run() {
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	send := func() {
+		if len(batch) > 0 {
+			if err := e.send(batch); err != nil {
+				zap.L().Error("Error exporting spans", zap.Error(err), zap.Int("spans", len(batch)))
+			}
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= e.batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-e.flush:
+			// Take the spans queued so far along
+			for queued := len(e.queue); queued > 0; queued-- {
+				batch = append(batch, <-e.queue)
+				if len(batch) >= e.batchSize {
+					send()
+				}
+			}
+			send()
+			close(flushed)
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// send posts a batch of spans to the collector
+func (e *OTLPExporter) send(batch []*Span) error {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		return err
+	}
+	res, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with status %s", res.Status)
+	}
+	return nil
+}
+
+// The OTLP JSON encoding of the spans, limited to the fields recorded here
+// IDs are hex-encoded and times are Unix nanoseconds, 64-bit integers being encoded as strings
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		TraceState        string          `json:"traceState,omitempty"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              SpanKind        `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpStatus struct {
+		Code    StatusCode `json:"code,omitempty"`
+		Message string     `json:"message,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// encode builds the export request of a batch of spans
+func (e *OTLPExporter) encode(batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		encoded := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			TraceState:        span.Context.TraceState,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+			Attributes:        encodeAttributes(span.Attributes),
+			Status:            otlpStatus{Code: span.Status, Message: span.StatusMessage},
+		}
+		if span.ParentSpanID.IsValid() {
+			encoded.ParentSpanID = span.ParentSpanID.String()
+		}
+		spans = append(spans, encoded)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: encodeAttributes([]Attribute{
+			{Key: "service.name", Value: e.serviceName},
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/serbanmarti/go-grpc/server/tracing"},
+			Spans: spans,
+		}},
+	}}}
+}
+
+func encodeAttributes(attributes []Attribute) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attributes))
+	for _, attribute := range attributes {
+		var value otlpValue
+		switch v := attribute.Value.(type) {
+		case string:
+			value.StringValue = &v
+		case bool:
+			value.BoolValue = &v
+		case int:
+			s := strconv.Itoa(v)
+			value.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			value.IntValue = &s
+		case float64:
+			value.DoubleValue = &v
+		default:
+			s := fmt.Sprint(v)
+			value.StringValue = &s
+		}
+		encoded = append(encoded, otlpAttribute{Key: attribute.Key, Value: value})
+	}
+	return encoded
+}
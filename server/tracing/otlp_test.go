@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubCollector records the export requests it receives
+type stubCollector struct {
+	mutex    sync.Mutex
+	requests []otlpRequest
+}
+
+func (c *stubCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req otlpRequest
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.requests = append(c.requests, req)
+}
+
+func (c *stubCollector) spans() []otlpSpan {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var spans []otlpSpan
+	for _, req := range c.requests {
+		for _, resourceSpans := range req.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				spans = append(spans, scopeSpans.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+func TestOTLPExporter(t *testing.T) {
+	collector := &stubCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL+"/v1/traces", "test-service")
+	tracer := NewTracer(exporter, 1)
+
+	parent := tracer.Start("parent", SpanKindServer, SpanContext{})
+	child := tracer.Start("child", SpanKindInternal, parent.Context)
+	child.SetAttributes(Attribute{Key: "messages", Value: 3}, Attribute{Key: "ok", Value: true})
+	child.End()
+	parent.SetStatus(StatusError, "failed")
+	parent.End()
+	parent.End()
+
+	// Spans of unsampled traces are not exported
+	NewTracer(exporter, 0).Start("unsampled", SpanKindServer, SpanContext{}).End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, exporter.Shutdown(ctx))
+
+	spans := collector.spans()
+	if !assert.Len(t, spans, 2) {
+		t.FailNow()
+	}
+	assert.Equal(t, "test-service", *collector.requests[0].ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, parent.Context.TraceID.String(), spans[0].TraceID)
+	assert.Equal(t, parent.Context.SpanID.String(), spans[0].ParentSpanID)
+	assert.Equal(t, SpanKindInternal, spans[0].Kind)
+	assert.Equal(t, "messages", spans[0].Attributes[0].Key)
+	assert.Equal(t, "3", *spans[0].Attributes[0].Value.IntValue)
+	assert.True(t, *spans[0].Attributes[1].Value.BoolValue)
+
+	assert.Equal(t, "parent", spans[1].Name)
+	assert.Empty(t, spans[1].ParentSpanID)
+	assert.Equal(t, otlpStatus{Code: StatusError, Message: "failed"}, spans[1].Status)
+	assert.NotEqual(t, spans[1].StartTimeUnixNano, spans[1].EndTimeUnixNano)
+}
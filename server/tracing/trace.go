@@ -0,0 +1,253 @@
+// Package tracing records spans of the requests, propagated through W3C Trace Context headers
+// (https://www.w3.org/TR/trace-context/) and exported to an OTLP collector
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+
+	// flagSampled is the trace flag telling the trace is recorded
+	flagSampled = 0x01
+)
+
+// TraceID and SpanID identify a trace, and a span within it
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+func (id SpanID) IsValid() bool  { return id != SpanID{} }
+
+// SpanContext is the part of a span propagated to the other services
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid reports whether the span context identifies a span
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as the value of a traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses the value of a traceparent header, refusing the malformed ones
+// Versions above 00 are accepted, as long as they start with the fields of version 00
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return sc, false
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || version == "ff" || !isLowerHex(version) || (version == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if len(traceID) != 32 || len(spanID) != 16 || len(flags) != 2 ||
+		!isLowerHex(traceID) || !isLowerHex(spanID) || !isLowerHex(flags) {
+		return sc, false
+	}
+
+	_, _ = hex.Decode(sc.TraceID[:], []byte(traceID))
+	_, _ = hex.Decode(sc.SpanID[:], []byte(spanID))
+	var flagBits [1]byte
+	_, _ = hex.Decode(flagBits[:], []byte(flags))
+	sc.Sampled = flagBits[0]&flagSampled != 0
+	return sc, sc.IsValid()
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract returns the span context propagated in the headers, if any
+func Extract(header http.Header) (SpanContext, bool) {
+	sc, ok := ParseTraceparent(header.Get(TraceparentHeader))
+	if !ok {
+		return SpanContext{}, false
+	}
+	sc.TraceState = header.Get(TracestateHeader)
+	return sc, true
+}
+
+// Inject propagates the span context in the headers
+func Inject(header http.Header, sc SpanContext) {
+	header.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set(TracestateHeader, sc.TraceState)
+	}
+}
+
+// SpanKind tells the role of a span in the request, with the values of OTLP
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// StatusCode is the status of a span, with the values of OTLP
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key-value pair describing a span, its value being a string, a bool, an int64 or a float64
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Span is an operation of a trace, exported once ended if the trace is sampled
+type Span struct {
+	tracer *Tracer
+
+	Name         string
+	Kind         SpanKind
+	Context      SpanContext
+	ParentSpanID SpanID
+	StartTime    time.Time
+
+	mutex         sync.Mutex
+	EndTime       time.Time
+	Attributes    []Attribute
+	Status        StatusCode
+	StatusMessage string
+	ended         bool
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Attributes = append(s.Attributes, attributes...)
+}
+
+// SetStatus sets the status of the span, the message only being kept for errors
+func (s *Span) SetStatus(code StatusCode, message string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Status = code
+	if code == StatusError {
+		s.StatusMessage = message
+	}
+}
+
+// End ends the span, exporting it if sampled; the span must not be changed afterwards
+func (s *Span) End() {
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mutex.Unlock()
+
+	if s.Context.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpan(s)
+	}
+}
+
+// Exporter sends the ended spans to a collector; it must not block
+type Exporter interface {
+	ExportSpan(span *Span)
+}
+
+// Tracer starts the spans, sampling a ratio of the new traces and exporting the spans with the exporter (if not nil)
+type Tracer struct {
+	exporter    Exporter
+	sampleRatio float64
+}
+
+func NewTracer(exporter Exporter, sampleRatio float64) *Tracer {
+	return &Tracer{exporter: exporter, sampleRatio: sampleRatio}
+}
+
+// Start starts a span, child of the given parent if it is valid, the root of a new trace otherwise
+// A child is sampled along with its parent, so a trace is either recorded by all its services or by none
+func (t *Tracer) Start(name string, kind SpanKind, parent SpanContext) *Span {
+	span := &Span{
+		tracer:    t,
+		Name:      name,
+		Kind:      kind,
+		StartTime: time.Now(),
+	}
+	if parent.IsValid() {
+		span.Context = SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled, TraceState: parent.TraceState}
+		span.ParentSpanID = parent.SpanID
+	} else {
+		_, _ = rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = t.sample(span.Context.TraceID)
+	}
+	_, _ = rand.Read(span.Context.SpanID[:])
+	return span
+}
+
+// sample decides whether a new trace is recorded, from the random low bits of its ID
+func (t *Tracer) sample(id TraceID) bool {
+	switch {
+	case t.sampleRatio >= 1:
+		return true
+	case t.sampleRatio <= 0:
+		return false
+	default:
+		return float64(binary.BigEndian.Uint64(id[8:])>>11)/(1<<53) < t.sampleRatio
+	}
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of the context carrying the span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span stored in the context, if any
+func SpanFromContext(ctx context.Context) (*Span, bool) {
+	span, ok := ctx.Value(spanKey{}).(*Span)
+	return span, ok
+}
+
+// TraceIDFromContext returns the ID of the trace of the span stored in the context, or an empty string if there is none
+func TraceIDFromContext(ctx context.Context) string {
+	if span, ok := SpanFromContext(ctx); ok {
+		return span.Context.TraceID.String()
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		wantOK      bool
+		wantSampled bool
+	}{
+		{name: "Sampled", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantOK: true, wantSampled: true},
+		{name: "Not sampled", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", wantOK: true},
+		{name: "Future version", traceparent: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", wantOK: true, wantSampled: true},
+		{name: "Version 00 with extra fields", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{name: "Invalid version", traceparent: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "Zero trace ID", traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "Zero span ID", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{name: "Uppercase", traceparent: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{name: "Short trace ID", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01"},
+		{name: "Empty", traceparent: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.traceparent)
+			assert.Equal(t, tt.wantOK, ok)
+			if ok {
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+				assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+				assert.Equal(t, tt.wantSampled, sc.Sampled)
+			}
+		})
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Set(TracestateHeader, "vendor=value")
+	parent, ok := Extract(header)
+	assert.True(t, ok)
+	assert.Equal(t, "vendor=value", parent.TraceState)
+
+	// A child continues the trace of its parent, and is sampled along with it
+	tracer := NewTracer(nil, 0)
+	child := tracer.Start("child", SpanKindServer, parent)
+	assert.Equal(t, parent.TraceID, child.Context.TraceID)
+	assert.Equal(t, parent.SpanID, child.ParentSpanID)
+	assert.NotEqual(t, parent.SpanID, child.Context.SpanID)
+	assert.True(t, child.Context.Sampled)
+
+	out := http.Header{}
+	Inject(out, child.Context)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+child.Context.SpanID.String()+"-01", out.Get(TraceparentHeader))
+	assert.Equal(t, "vendor=value", out.Get(TracestateHeader))
+
+	// New traces are sampled according to the ratio
+	root := tracer.Start("root", SpanKindServer, SpanContext{})
+	assert.True(t, root.Context.TraceID.IsValid())
+	assert.False(t, root.ParentSpanID.IsValid())
+	assert.False(t, root.Context.Sampled)
+	assert.True(t, NewTracer(nil, 1).Start("root", SpanKindServer, SpanContext{}).Context.Sampled)
+
+	ctx := ContextWithSpan(context.Background(), child)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", TraceIDFromContext(ctx))
+	assert.Empty(t, TraceIDFromContext(context.Background()))
+}